@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/audit"
+	"github.com/SmallGaoX/clamd-api/auth"
+	"github.com/SmallGaoX/clamd-api/webhook"
+)
+
+// AdminHandler 处理 API key 管理接口
+type AdminHandler struct {
+	apiKeyManager *auth.APIKeyManager
+	audit         *audit.Logger
+}
+
+// NewAdminHandler 创建一个新的AdminHandler实例
+func NewAdminHandler(apiKeyManager *auth.APIKeyManager, auditLog *audit.Logger) *AdminHandler {
+	return &AdminHandler{apiKeyManager: apiKeyManager, audit: auditLog}
+}
+
+// CreateKeyRequest 是 POST /admin/keys 的请求体
+type CreateKeyRequest struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes,omitempty"`   // 为空时使用默认权限 scan
+	ExpiresAt time.Time `json:"expiresAt,omitzero"` // 为空时永不过期
+	Webhooks  []string  `json:"webhooks,omitempty"` // 发现威胁时回调的地址
+}
+
+// CreateKeyResponse 是 POST /admin/keys 的响应，Key 只在创建时返回这一次
+type CreateKeyResponse struct {
+	auth.KeyInfo
+	Key string `json:"key"`
+}
+
+// KeysHandler 处理 GET /admin/keys（列出所有 key，只包含 key ID）和 POST /admin/keys（创建 key）
+func (h *AdminHandler) KeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !h.reload(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.apiKeyManager.ListAPIKeys())
+	case http.MethodPost:
+		h.createKey(w, r)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+// createKey 生成并保存新的 API key，响应中包含完整的 key
+func (h *AdminHandler) createKey(w http.ResponseWriter, r *http.Request) {
+	var request CreateKeyRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "解析请求体失败: "+err.Error(), nil)
+		return
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "缺少 name", map[string]any{"field": "name"})
+		return
+	}
+	if err := auth.ValidateScopes(request.Scopes); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error(), map[string]any{"field": "scopes"})
+		return
+	}
+	if !request.ExpiresAt.IsZero() && !request.ExpiresAt.After(time.Now()) {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "expiresAt 必须晚于当前时间", map[string]any{"field": "expiresAt"})
+		return
+	}
+	for _, target := range request.Webhooks {
+		if err := webhook.ValidateURL(target); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidCallbackURL, err.Error(), map[string]any{"field": "webhooks", "url": target})
+			return
+		}
+	}
+
+	apiKey, err := auth.GenerateAPIKey()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "生成 API key 失败", "error", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternalError, "生成 API key 失败", nil)
+		return
+	}
+
+	err = h.apiKeyManager.AddAPIKey(apiKey, request.Name, auth.KeyOptions{
+		Scopes:    request.Scopes,
+		ExpiresAt: request.ExpiresAt,
+		Webhooks:  request.Webhooks,
+	})
+	if errors.Is(err, auth.ErrKeyExists) {
+		writeError(w, r, http.StatusConflict, CodeConflict, "API key 名称已存在", map[string]any{"name": request.Name})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "添加 API key 失败", "name", request.Name, "error", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternalError, "添加 API key 失败", nil)
+		return
+	}
+
+	info, _ := h.apiKeyManager.GetAPIKeyInfo(request.Name)
+	h.auditKey(r, audit.EventKeyAdd, info.Name, info.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", "/admin/keys/"+url.PathEscape(request.Name))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateKeyResponse{KeyInfo: info, Key: apiKey})
+}
+
+// KeyHandler 处理 GET /admin/keys/{name}（查看 key 信息）和 DELETE /admin/keys/{name}（删除 key）
+func (h *AdminHandler) KeyHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	switch r.Method {
+	case http.MethodGet:
+		if !h.reload(w, r) {
+			return
+		}
+		info, exists := h.apiKeyManager.GetAPIKeyInfo(name)
+		if !exists {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "API key 不存在", map[string]any{"name": name})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	case http.MethodDelete:
+		info, _ := h.apiKeyManager.GetAPIKeyInfo(name)
+		err := h.apiKeyManager.RemoveAPIKey(name)
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "API key 不存在", map[string]any{"name": name})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "删除 API key 失败", "name", name, "error", err)
+			writeError(w, r, http.StatusInternalServerError, CodeInternalError, "删除 API key 失败", nil)
+			return
+		}
+		h.auditKey(r, audit.EventKeyDelete, name, info.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// reload 重新加载 API key 文件以包含 CLI 等做出的修改，失败时写入 500 响应
+func (h *AdminHandler) reload(w http.ResponseWriter, r *http.Request) bool {
+	if err := h.apiKeyManager.LoadAPIKeys(); err != nil {
+		slog.ErrorContext(r.Context(), "重新加载 API keys 失败", "error", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternalError, "加载 API keys 失败", nil)
+		return false
+	}
+	return true
+}
+
+// auditKey 写入 API key 管理操作的审计记录
+func (h *AdminHandler) auditKey(r *http.Request, event, name, id string) {
+	entry := auditEntry(r, event)
+	entry.Key = adminName(r)
+	entry.Target = name
+	entry.TargetID = id
+	h.audit.Log(entry)
+}
+
+// adminName 返回调用管理接口的身份，使用引导凭据时为 admin_token
+func adminName(r *http.Request) string {
+	if name := apiKeyName(r); name != "" {
+		return name
+	}
+	return "admin_token"
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SmallGaoX/clamd-api/auth"
+)
+
+// newAdminServer 返回注册了 /admin/keys 路由的测试服务器，引导凭据为 admin-token
+func newAdminServer(t *testing.T) (*httptest.Server, *auth.APIKeyManager) {
+	t.Helper()
+
+	keys, err := auth.NewAPIKeyManager(filepath.Join(t.TempDir(), "api_keys.json"), []byte("secret"))
+	if err != nil {
+		t.Fatalf("创建 APIKeyManager 失败: %v", err)
+	}
+	admin := NewAdminHandler(keys, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/keys", AdminAuthMiddleware(admin.KeysHandler, keys, "admin-token"))
+	mux.HandleFunc("/admin/keys/{name}", AdminAuthMiddleware(admin.KeyHandler, keys, "admin-token"))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, keys
+}
+
+// adminRequest 发送带引导凭据的请求，返回状态码和响应体
+func adminRequest(t *testing.T, server *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Admin-Token", "admin-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("请求 %s %s 失败: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+// errorCode 返回错误响应中的 code
+func errorCode(t *testing.T, body string) string {
+	t.Helper()
+	var response ErrorResponse
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatalf("解析错误响应失败: %v: %s", err, body)
+	}
+	return response.Error.Code
+}
+
+func TestAdminCreateKey(t *testing.T) {
+	server, keys := newAdminServer(t)
+
+	status, body := adminRequest(t, server, http.MethodPost, "/admin/keys", `{"name":"ci","scopes":["scan","admin:reload"]}`)
+	if status != http.StatusCreated {
+		t.Fatalf("创建 key 返回 %d: %s", status, body)
+	}
+	var created CreateKeyResponse
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Key == "" || created.Name != "ci" || created.ID == "" || len(created.Scopes) != 2 {
+		t.Fatalf("创建 key 的响应 = %s", body)
+	}
+	if !keys.IsValidAPIKey(created.Key) {
+		t.Error("返回的 key 无法认证")
+	}
+
+	// 之后的列表和查询都不包含 key 或其 HMAC
+	for _, path := range []string{"/admin/keys", "/admin/keys/ci"} {
+		status, body := adminRequest(t, server, http.MethodGet, path, "")
+		if status != http.StatusOK {
+			t.Fatalf("GET %s 返回 %d: %s", path, status, body)
+		}
+		if strings.Contains(body, created.Key) || strings.Contains(body, "hmac-sha256") ||
+			strings.Contains(body, `"key"`) || strings.Contains(body, `"hash"`) {
+			t.Errorf("GET %s 暴露了 key: %s", path, body)
+		}
+		if !strings.Contains(body, created.ID) {
+			t.Errorf("GET %s 缺少 key ID: %s", path, body)
+		}
+	}
+
+	// 名称重复
+	status, body = adminRequest(t, server, http.MethodPost, "/admin/keys", `{"name":"ci"}`)
+	if status != http.StatusConflict || errorCode(t, body) != CodeConflict {
+		t.Errorf("重复名称返回 %d: %s", status, body)
+	}
+	if strings.Contains(body, created.Key) {
+		t.Errorf("冲突响应中包含已有的 key: %s", body)
+	}
+
+	// 请求体错误
+	for _, request := range []string{`{"name":""}`, `{"name":"x","scopes":["root"]}`, `{"name":"x","unknown":1}`, `{"name":"x","webhooks":["ftp://a"]}`} {
+		if status, body := adminRequest(t, server, http.MethodPost, "/admin/keys", request); status != http.StatusBadRequest {
+			t.Errorf("请求体 %s 返回 %d: %s", request, status, body)
+		}
+	}
+}
+
+func TestAdminKeyNotFound(t *testing.T) {
+	server, _ := newAdminServer(t)
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		status, body := adminRequest(t, server, method, "/admin/keys/missing", "")
+		if status != http.StatusNotFound || errorCode(t, body) != CodeNotFound {
+			t.Errorf("%s 不存在的 key 返回 %d: %s", method, status, body)
+		}
+	}
+}
+
+func TestAdminDeleteKey(t *testing.T) {
+	server, keys := newAdminServer(t)
+	if err := keys.AddAPIKey("key-1", "ci", auth.KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body := adminRequest(t, server, http.MethodDelete, "/admin/keys/ci", ""); status != http.StatusNoContent {
+		t.Fatalf("删除 key 返回 %d: %s", status, body)
+	}
+	if keys.IsValidAPIKey("key-1") {
+		t.Error("删除后 key 仍然有效")
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	server, keys := newAdminServer(t)
+	if err := keys.AddAPIKey("scan-key", "scanner", auth.KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.AddAPIKey("admin-key", "admin", auth.KeyOptions{Scopes: []string{auth.ScopeAdminKeys}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+		code   string
+	}{
+		{"缺少凭据", "", "", http.StatusUnauthorized, CodeMissingAPIKey},
+		{"错误的管理凭据", "X-Admin-Token", "wrong", http.StatusForbidden, CodeInvalidAdminToken},
+		{"无效的 API key", "X-API-Key", "wrong", http.StatusUnauthorized, CodeInvalidAPIKey},
+		{"缺少权限的 API key", "X-API-Key", "scan-key", http.StatusForbidden, CodeInsufficientScope},
+		{"admin:keys 权限的 API key", "X-API-Key", "admin-key", http.StatusOK, ""},
+		{"管理凭据", "X-Admin-Token", "admin-token", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/admin/keys", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("返回 %d，期望 %d", resp.StatusCode, tt.status)
+			}
+			if tt.code != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.Error.Code != tt.code {
+					t.Errorf("错误码 = %q, %v，期望 %q", response.Error.Code, err, tt.code)
+				}
+			}
+		})
+	}
+
+	// 未配置 admin_token 时引导凭据不可用
+	keysOnly := AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {}, keys, "")
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/keys", nil)
+	req.Header.Set("X-Admin-Token", "anything")
+	keysOnly(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("未配置 admin_token 时返回 %d", w.Code)
+	}
+}
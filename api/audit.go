@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/SmallGaoX/clamd-api/audit"
+	"github.com/SmallGaoX/clamd-api/jobs"
+	"github.com/SmallGaoX/clamd-api/logging"
+)
+
+// auditEntry 创建包含请求者信息的审计记录
+func auditEntry(r *http.Request, event string) audit.Entry {
+	return audit.Entry{
+		Event:     event,
+		RequestID: logging.RequestID(r.Context()),
+		Key:       apiKeyName(r),
+		ClientIP:  clientIP(r),
+		Method:    r.Method,
+		Route:     r.URL.Path,
+	}
+}
+
+// auditFiles 将扫描结果转换为审计记录中的文件
+func auditFiles(results []ScanResult) []audit.File {
+	files := make([]audit.File, 0, len(results))
+	for _, result := range results {
+		file := audit.File{
+			Name:    result.FileName,
+			Size:    result.Size,
+			SHA256:  result.SHA256,
+			Verdict: string(result.Status),
+			Error:   result.Error,
+		}
+		if len(result.Threats) > 0 {
+			file.Signatures = result.Threats
+		} else if result.Threat != "" {
+			file.Signatures = strings.Split(result.Threat, ", ")
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// AuditJob 在异步任务结束时写入扫描的审计记录，与 Notifier.JobFinished 一起用作 jobs.Options.OnFinish
+func AuditJob(logger *audit.Logger, job *jobs.Job) {
+	response := newJobResponse(job)
+	entry := audit.Entry{
+		Event: audit.EventScan,
+		Key:   job.Owner,
+		Route: "/jobs",
+		JobID: job.ID,
+		Files: auditFiles(response.Results),
+		Error: job.Error,
+	}
+	logger.Log(entry)
+}
+
+// clientIP 返回请求的客户端地址（不含端口）
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
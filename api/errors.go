@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/SmallGaoX/clamd-api/logging"
+	"github.com/SmallGaoX/clamd-api/ratelimit"
+)
+
+// 错误码，写入错误响应的 code 字段，客户端应根据错误码而不是错误信息进行处理
+const (
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeInvalidRequest     = "invalid_request"      // 请求体或参数格式错误
+	CodeInvalidCallbackURL = "invalid_callback_url" // callback_url 或 webhook 地址无效
+	CodeNoFiles            = "no_files"             // 请求中没有上传文件
+	CodeMissingAPIKey      = "missing_api_key"
+	CodeInvalidAPIKey      = "invalid_api_key" // API key 无效、已过期或已删除
+	CodeInvalidAdminToken  = "invalid_admin_token"
+	CodeInsufficientScope  = "insufficient_scope" // details.scope 为缺少的权限
+	CodePathNotAllowed     = "path_not_allowed"   // 路径不在 scan_roots 内，details.path 为被拒绝的路径
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeRateLimited        = "rate_limited"   // details.retryAfter 为建议的重试等待秒数
+	CodeQuotaExceeded      = "quota_exceeded" // details.retryAfter 为距离配额重置的秒数
+	CodeServerBusy         = "server_busy"    // 同时进行的扫描数已达上限
+	CodeQueueFull          = "queue_full"     // 异步任务队列已满
+	CodeClamdError         = "clamd_error"    // 与 clamd 通信失败或 clamd 返回错误
+	CodeNotImplemented     = "not_implemented"
+	CodeInternalError      = "internal_error"
+)
+
+// ErrorBody 是错误响应中 error 字段的内容
+type ErrorBody struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	RequestID string         `json:"requestId,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// ErrorResponse 是所有错误响应的格式：{"error": {...}}
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// writeError 写入错误响应，客户端通过 Accept 请求纯文本时只返回 message
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]any) {
+	if prefersText(r) {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: logging.RequestID(r.Context()),
+		Details:   details,
+	}})
+}
+
+// methodNotAllowed 写入 405 响应并设置 Allow 响应头
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	var message string
+	if len(methods) == 1 && methods[0] == http.MethodPost {
+		message = "只允许 POST 请求"
+	} else {
+		message = fmt.Sprintf("只支持%s方法", strings.Join(methods, "和"))
+	}
+	writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, message, map[string]any{"allow": methods})
+}
+
+// insufficientScope 写入 API key 缺少权限的 403 响应
+func insufficientScope(w http.ResponseWriter, r *http.Request, keyName, scope string) {
+	message := fmt.Sprintf("API key '%s' 缺少权限 '%s'", keyName, scope)
+	writeError(w, r, http.StatusForbidden, CodeInsufficientScope, message, map[string]any{"scope": scope})
+}
+
+// writeMessage 写入纯文本的成功响应；客户端没有通过 Accept 请求纯文本时改为写入 JSON 的 body
+func writeMessage(w http.ResponseWriter, r *http.Request, text string, body any) {
+	if prefersText(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(text))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// parseForm 解析 multipart 表单，失败时写入错误响应并返回 false
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	if err := r.ParseMultipartForm(32 << 20); err != nil { // 32 MB
+		writeBodyError(w, r, err, "解析表单数据失败")
+		return false
+	}
+	return true
+}
+
+// writeBodyError 写入读取请求体失败的响应，上传超过每日配额时返回 429
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if errors.Is(err, ratelimit.ErrQuotaExceeded) {
+		writeError(w, r, http.StatusTooManyRequests, CodeQuotaExceeded, "超过每日上传配额", nil)
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, message, nil)
+}
+
+// prefersText 根据 Accept 请求头判断客户端是否更希望接收纯文本
+//
+// 只有 text/plain 的权重高于 application/json 时才返回纯文本，没有 Accept 或权重相同时使用 JSON。
+func prefersText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	return acceptWeight(accept, "text", "plain") > acceptWeight(accept, "application", "json")
+}
+
+// acceptWeight 返回 Accept 中与 type/subtype 匹配的最具体媒体范围的权重（q 值），不匹配时为0
+func acceptWeight(accept, typ, subtype string) float64 {
+	weight, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		rangeType, rangeSubtype, _ := strings.Cut(mediaType, "/")
+
+		level := -1
+		switch {
+		case rangeType == typ && rangeSubtype == subtype:
+			level = 2
+		case rangeType == typ && rangeSubtype == "*":
+			level = 1
+		case rangeType == "*" && rangeSubtype == "*":
+			level = 0
+		}
+		if level <= specificity {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		weight, specificity = q, level
+	}
+	return weight
+}
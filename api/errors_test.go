@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SmallGaoX/clamd-api/logging"
+)
+
+func TestWriteErrorJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/scan", nil)
+	r = r.WithContext(logging.WithRequestID(r.Context(), "req-1"))
+	w := httptest.NewRecorder()
+
+	writeError(w, r, http.StatusForbidden, CodeInsufficientScope, "缺少权限", map[string]any{"scope": "scan:path"})
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("状态码 = %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q", got)
+	}
+
+	var envelope map[string]map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&envelope); err != nil {
+		t.Fatalf("解析错误响应失败: %v", err)
+	}
+	body := envelope["error"]
+	details, _ := body["details"].(map[string]any)
+	if len(envelope) != 1 || body["code"] != CodeInsufficientScope || body["message"] != "缺少权限" ||
+		body["requestId"] != "req-1" || details["scope"] != "scan:path" {
+		t.Errorf("错误响应 = %v", envelope)
+	}
+
+	// 没有请求 ID 和 details 时省略这两个字段
+	w = httptest.NewRecorder()
+	writeError(w, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusNotFound, CodeNotFound, "不存在", nil)
+	if got := strings.TrimSpace(w.Body.String()); got != `{"error":{"code":"not_found","message":"不存在"}}` {
+		t.Errorf("错误响应 = %s", got)
+	}
+}
+
+func TestWriteErrorText(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/scan", nil)
+	r.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+
+	writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "请求错误", map[string]any{"field": "file"})
+
+	if w.Code != http.StatusBadRequest || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("状态码 = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := w.Body.String(); got != "请求错误\n" {
+		t.Errorf("响应 = %q", got)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	w := httptest.NewRecorder()
+	methodNotAllowed(w, httptest.NewRequest(http.MethodPut, "/admin/keys", nil), http.MethodGet, http.MethodPost)
+
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("状态码 = %d, Allow = %q", w.Code, w.Header().Get("Allow"))
+	}
+	var response ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if allow, _ := response.Error.Details["allow"].([]any); response.Error.Code != CodeMethodNotAllowed || len(allow) != 2 {
+		t.Errorf("错误响应 = %+v", response)
+	}
+}
+
+func TestPrefersText(t *testing.T) {
+	tests := []struct {
+		accept string
+		text   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/plain", true},
+		{"text/*", true},
+		{"text/plain, application/json", false}, // 权重相同时使用 JSON
+		{"application/json;q=0.5, text/plain", true},
+		{"text/plain;q=0.9, application/json", false},
+		{"text/plain;q=0.9, */*;q=0.1", true},
+		{"text/html, */*;q=0.8", false},
+		{"text/*;q=0.2, text/plain;q=0.8, application/*;q=0.5", true}, // 使用最具体的媒体范围
+		{"text/plain;q=0.2, text/*;q=0.9, application/json;q=0.5", false},
+		{"text/plain;q=0, */*", false}, // q=0 表示不接受
+		{"application/json;q=0, text/plain", true},
+		{"application/json;q=0, text/plain;q=0", false},
+		{"invalid;;, text/plain", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := prefersText(r); got != tt.text {
+			t.Errorf("prefersText(%q) = %v，期望 %v", tt.accept, got, tt.text)
+		}
+	}
+}
+
+func TestAcceptWeight(t *testing.T) {
+	tests := []struct {
+		accept string
+		weight float64
+	}{
+		{"text/plain", 1},
+		{"text/plain;q=0.3", 0.3},
+		{"TEXT/PLAIN;Q=0.3", 0.3},
+		{"text/*;q=0.4", 0.4},
+		{"*/*;q=0.1", 0.1},
+		{"*/*;q=0.1, text/*;q=0.4, text/plain;q=0.7", 0.7},
+		{"text/plain;q=0.7, text/*;q=0.4", 0.7},
+		{"text/plain;q=0", 0},
+		{"text/plain;q=abc", 1}, // 无法解析的 q 值按 1 处理
+		{"application/json", 0},
+		{"text/html", 0},
+	}
+	for _, tt := range tests {
+		if got := acceptWeight(tt.accept, "text", "plain"); got != tt.weight {
+			t.Errorf("acceptWeight(%q) = %v，期望 %v", tt.accept, got, tt.weight)
+		}
+	}
+}
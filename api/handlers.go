@@ -1,140 +1,266 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/SmallGaoX/clamd-api/audit"
 	"github.com/SmallGaoX/clamd-api/auth"
+	"github.com/SmallGaoX/clamd-api/cache"
 	"github.com/SmallGaoX/clamd-api/clamav"
 	"github.com/SmallGaoX/clamd-api/config"
+	"github.com/SmallGaoX/clamd-api/metrics"
 )
 
 // Handler 结构体包含所有API处理程序
 type Handler struct {
-	scanner       clamav.Scanner
+	scanner       clamav.ContextScanner
 	config        *config.Config
 	apiKeyManager *auth.APIKeyManager
+	notifier      *Notifier
+	cache         *cache.Scanner
+	audit         *audit.Logger
+
+	staleSignatures atomic.Bool // 最近一次检查时病毒库是否已过期，用于只在状态变化时记录日志
 }
 
 // NewHandler 创建一个新的Handler实例
-func NewHandler(scanner clamav.Scanner, cfg *config.Config, apiKeyManager *auth.APIKeyManager) *Handler {
+func NewHandler(scanner clamav.ContextScanner, cfg *config.Config, apiKeyManager *auth.APIKeyManager, notifier *Notifier, auditLog *audit.Logger) *Handler {
 	return &Handler{
 		scanner:       scanner,
 		config:        cfg,
 		apiKeyManager: apiKeyManager,
+		notifier:      notifier,
+		audit:         auditLog,
+		cache: cache.NewScanner(scanner, cache.Options{
+			Size:       cfg.CacheSize,
+			TTL:        cfg.CacheTTL,
+			VersionTTL: cfg.CacheVersionTTL,
+		}),
 	}
 }
 
 // VersionHandler 处理获取ClamAV版本的请求
 func (h *Handler) VersionHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodGet)
 		return
 	}
 
-	version, err := h.scanner.GetVersion()
+	version, err := h.scanner.GetVersionContext(r.Context())
 	if err != nil {
-		log.Printf("获取版本失败: %v", err)
-		http.Error(w, "获取版本失败", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "获取版本失败", "error", err)
+		writeError(w, r, http.StatusInternalServerError, CodeClamdError, "获取版本失败", nil)
 		return
 	}
 
-	response := map[string]string{"version": version}
+	response := VersionResponse{Version: version}
+	info, err := clamav.ParseVersion(version)
+	if err != nil {
+		slog.WarnContext(r.Context(), "解析版本信息失败", "error", err)
+		response.Warning = err.Error()
+	} else {
+		response.Engine = info.Engine
+		response.Database = info.Database
+		if !info.DatabaseTime.IsZero() {
+			ageHours := math.Round(info.DatabaseAge(time.Now()).Hours()*10) / 10
+			response.DatabaseTime = &info.DatabaseTime
+			response.DBAgeHours = &ageHours
+		}
+
+		warning, err := h.signatureFreshness(r.Context(), info)
+		if err != nil {
+			warning = err.Error()
+		}
+		response.Stale = warning != ""
+		response.Warning = warning
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// VersionResponse 是 /version 的响应
+type VersionResponse struct {
+	Version      string     `json:"version"`                // clamd 的原始回复，例如 "ClamAV 1.3.1/27400/Mon Sep 30 09:30:00 2024"
+	Engine       string     `json:"engine"`                 // 引擎版本
+	Database     int        `json:"database"`               // 病毒库版本号，未加载病毒库时为0
+	DatabaseTime *time.Time `json:"databaseTime,omitempty"` // 病毒库发布时间
+	DBAgeHours   *float64   `json:"dbAgeHours,omitempty"`   // 病毒库发布至今的小时数
+	Stale        bool       `json:"stale"`                  // 病毒库是否未加载或超过 signature_warn_age、max_signature_age
+	Warning      string     `json:"warning,omitempty"`      // 病毒库过期或版本信息无法解析的原因
+}
+
 // PingHandler 处理Ping请求
 func (h *Handler) PingHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodGet)
 		return
 	}
 
-	err := h.scanner.Ping()
+	err := h.scanner.PingContext(r.Context())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Ping失败: %v", err), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, CodeClamdError, fmt.Sprintf("Ping失败: %v", err), nil)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("PONG"))
+	writeMessage(w, r, "PONG", map[string]string{"status": "PONG"})
 }
 
 // ReloadHandler 处理重新加载病毒数据库请求
 func (h *Handler) ReloadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 
-	err := h.scanner.Reload()
+	err := h.scanner.ReloadContext(r.Context())
+	// 即使部分后端重新加载失败，病毒库也可能已经变化
+	h.cache.Purge()
+
+	entry := auditEntry(r, audit.EventReload)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	h.audit.Log(entry)
+
 	if err != nil {
-		http.Error(w, fmt.Sprintf("重新加载失败: %v", err), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, CodeClamdError, fmt.Sprintf("重新加载失败: %v", err), nil)
+		return
+	}
+
+	writeMessage(w, r, "病毒数据库已重新加载", map[string]string{"status": "reloaded", "message": "病毒数据库已重新加载"})
+}
+
+// BackendsHandler 返回各 clamd 后端的健康状态
+func (h *Handler) BackendsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	reporter, ok := h.scanner.(clamav.HealthReporter)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, CodeNotImplemented, "当前扫描器不支持后端健康状态查询", nil)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("病毒数据库已重新加载"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reporter.Backends())
+}
+
+// ClamdStatsHandler 返回各 clamd 后端 STATS 的解析结果，查询失败的后端在 error 中给出原因
+func (h *Handler) ClamdStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	reporter, ok := h.scanner.(clamav.StatsReporter)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, CodeNotImplemented, "当前扫描器不支持 STATS 查询", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reporter.BackendStats(r.Context()))
+}
+
+// ClamdCapabilitiesHandler 返回各 clamd 后端通过 VERSIONCOMMANDS 报告的命令及因此降级的功能
+func (h *Handler) ClamdCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	reporter, ok := h.scanner.(clamav.CapabilityReporter)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, CodeNotImplemented, "当前扫描器不支持命令查询", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reporter.BackendCapabilities(r.Context()))
 }
 
 // ScanResult 结构体表示单个文件的扫描结果
+//
+// Status 区分 "clean"（安全）、"infected"（发现威胁）和 "error"（扫描失败），
+// 扫描失败时 IsSafe 为 false，但 Threat 为空，原因见 Error。
 type ScanResult struct {
-	FileName string `json:"fileName"`
-	IsSafe   bool   `json:"isSafe"`
-	Threat   string `json:"threat"`
+	FileName string        `json:"fileName"`
+	Status   clamav.Status `json:"status"`
+	IsSafe   bool          `json:"isSafe"`
+	Threat   string        `json:"threat"`
+	Threats  []string      `json:"threats,omitempty"` // clamd 报告的命中的特征，仅在请求 threats=true 时返回
+	Error    string        `json:"error,omitempty"`
+	Size     int64         `json:"size,omitempty"`   // 上传文件的大小（字节）
+	SHA256   string        `json:"sha256,omitempty"` // 上传文件内容的 SHA-256
+	Cached   bool          `json:"cached"`           // 结论是否来自缓存
+}
+
+// newScanResult 根据扫描结论创建扫描结果
+func newScanResult(fileName string, verdict clamav.Verdict) ScanResult {
+	return ScanResult{
+		FileName: fileName,
+		Status:   verdict.Status,
+		IsSafe:   verdict.Clean(),
+		Threat:   strings.Join(verdict.Signatures, ", "),
+		Error:    verdict.Error,
+	}
+}
+
+// errorScanResult 创建表示扫描失败的结果
+func errorScanResult(fileName string, format string, err error) ScanResult {
+	return ScanResult{
+		FileName: fileName,
+		Status:   clamav.StatusError,
+		IsSafe:   false,
+		Error:    fmt.Sprintf(format, err),
+	}
 }
 
 // ScanFileHandler 处理文件扫描请求（支持单个或多个文件）
 func (h *Handler) ScanFileHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "只允许 POST 请求", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 
-	err := r.ParseMultipartForm(32 << 20) // 32 MB
-	if err != nil {
-		http.Error(w, "解析表单数据失败", http.StatusBadRequest)
+	if !parseForm(w, r) {
 		return
 	}
 
-	var results []ScanResult
+	callback := callbackURL(r)
+	if !validCallbackURL(w, r, h.notifier, callback) {
+		return
+	}
+	threats, ok := threatsOption(w, r)
+	if !ok {
+		return
+	}
 
-	for _, fileHeaders := range r.MultipartForm.File {
-		for _, fileHeader := range fileHeaders {
-			file, err := fileHeader.Open()
-			if err != nil {
-				results = append(results, ScanResult{
-					FileName: fileHeader.Filename,
-					IsSafe:   false,
-					Threat:   fmt.Sprintf("打开文件失败: %v", err),
-				})
-				continue
-			}
-			defer file.Close()
-
-			scanResult, err := h.scanner.ScanStream(file)
-			if err != nil {
-				results = append(results, ScanResult{
-					FileName: fileHeader.Filename,
-					IsSafe:   false,
-					Threat:   fmt.Sprintf("扫描错误: %v", err),
-				})
-			} else {
-				isSafe, threat := parseScanResult(scanResult)
-				results = append(results, ScanResult{
-					FileName: fileHeader.Filename,
-					IsSafe:   isSafe,
-					Threat:   threat,
-				})
-			}
-		}
+	results := h.scanMultipartFiles(r, threats)
+	h.auditScan(r, results)
+	if r.Context().Err() != nil {
+		slog.WarnContext(r.Context(), "客户端已断开，扫描中止", "error", r.Context().Err())
+		return
 	}
 
+	h.notifier.ScanCompleted(r, callback, results)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
@@ -142,99 +268,214 @@ func (h *Handler) ScanFileHandler(w http.ResponseWriter, r *http.Request) {
 // ScanStreamHandler 处理文件列表扫描请求（支持文件路径列表和多文件上传）
 func (h *Handler) ScanStreamHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "只允许 POST 请求", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 
 	var results []ScanResult
 
 	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
-		err := r.ParseMultipartForm(32 << 20) // 32 MB
-		if err != nil {
-			http.Error(w, "解析表单数据失败", http.StatusBadRequest)
+		if !parseForm(w, r) {
 			return
 		}
+	}
+
+	callback := callbackURL(r)
+	if !validCallbackURL(w, r, h.notifier, callback) {
+		return
+	}
+	threats, ok := threatsOption(w, r)
+	if !ok {
+		return
+	}
+
+	if r.MultipartForm != nil {
+		results = h.scanMultipartFiles(r, threats)
+	} else if results, ok = h.scanPaths(w, r, threats); !ok {
+		return
+	}
+
+	h.auditScan(r, results)
+	if r.Context().Err() != nil {
+		slog.WarnContext(r.Context(), "客户端已断开，扫描中止", "error", r.Context().Err())
+		return
+	}
+
+	h.notifier.ScanCompleted(r, callback, results)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// scanPaths 扫描请求体中每行一个的服务器路径，返回 clamd 报告的每个文件的结果
+//
+// 所有路径都必须位于 scan_roots 配置的目录内，否则整个请求被拒绝；mode 参数选择扫描目录使用的命令，
+// 未指定时 threats 为 true 则使用 ALLMATCHSCAN。失败时已写入错误响应并返回 false。
+func (h *Handler) scanPaths(w http.ResponseWriter, r *http.Request, threats bool) ([]ScanResult, bool) {
+	// 扫描服务器上的文件路径需要单独的权限
+	if !h.apiKeyManager.HasScope(apiKeyName(r), auth.ScopeScanPath) {
+		insufficientScope(w, r, apiKeyName(r), auth.ScopeScanPath)
+		return nil, false
+	}
+	if len(h.config.ScanRoots) == 0 {
+		writeError(w, r, http.StatusForbidden, CodePathNotAllowed, "未配置 scan_roots，不允许扫描服务器上的路径", nil)
+		return nil, false
+	}
+	pathScanner, ok := h.scanner.(clamav.PathScanner)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, CodeNotImplemented, "当前扫描器不支持路径扫描", nil)
+		return nil, false
+	}
+	mode, err := clamav.ParseScanMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error(), map[string]any{"field": "mode"})
+		return nil, false
+	}
+	if threats && r.URL.Query().Get("mode") == "" {
+		mode = clamav.ModeAllMatchScan
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, r, err, "读取请求体失败")
+		return nil, false
+	}
+	defer r.Body.Close()
 
-		for _, fileHeaders := range r.MultipartForm.File {
-			for _, fileHeader := range fileHeaders {
-				file, err := fileHeader.Open()
-				if err != nil {
-					results = append(results, ScanResult{
-						FileName: fileHeader.Filename,
-						IsSafe:   false,
-						Threat:   fmt.Sprintf("打开文件失败: %v", err),
-					})
-					continue
-				}
-				defer file.Close()
-
-				scanResult, err := h.scanner.ScanStream(file)
-				if err != nil {
-					results = append(results, ScanResult{
-						FileName: fileHeader.Filename,
-						IsSafe:   false,
-						Threat:   fmt.Sprintf("扫描错误: %v", err),
-					})
-				} else {
-					isSafe, threat := parseScanResult(scanResult)
-					results = append(results, ScanResult{
-						FileName: fileHeader.Filename,
-						IsSafe:   isSafe,
-						Threat:   threat,
-					})
-				}
+	// 先检查所有路径，任何一个不允许时都不扫描
+	type target struct {
+		path     string // 请求中的路径
+		resolved string // 解析符号链接后的路径，为空时 err 为检查失败的原因
+		err      error
+	}
+	var targets []target
+	for _, line := range strings.Split(string(body), "\n") {
+		path := strings.TrimSpace(line)
+		if path == "" {
+			continue
+		}
+		resolved, err := resolveScanPath(h.config.ScanRoots, path)
+		if errors.Is(err, errPathNotAllowed) {
+			writeError(w, r, http.StatusForbidden, CodePathNotAllowed, err.Error(), map[string]any{"path": path})
+			return nil, false
+		}
+		targets = append(targets, target{path: path, resolved: resolved, err: err})
+	}
+	if len(targets) == 0 {
+		writeError(w, r, http.StatusBadRequest, CodeNoFiles, "请求中没有路径", nil)
+		return nil, false
+	}
+
+	var results []ScanResult
+	for _, t := range targets {
+		if r.Context().Err() != nil {
+			break
+		}
+		if t.err != nil {
+			result := errorScanResult(t.path, "无法访问文件: %v", t.err)
+			if os.IsNotExist(t.err) {
+				result.Error = "文件不存在"
 			}
+			results = append(results, result)
+			continue
 		}
-	} else {
-		body, err := io.ReadAll(r.Body)
+
+		done := metrics.TrackScan()
+		verdicts, err := pathScanner.ScanPathContext(r.Context(), t.resolved, mode)
 		if err != nil {
-			http.Error(w, "读取请求体失败", http.StatusBadRequest)
-			return
+			done(string(clamav.StatusError))
+			results = append(results, errorScanResult(t.path, "扫描错误: %v", err))
+			continue
 		}
-		defer r.Body.Close()
+		done(string(worstStatus(verdicts)))
+		for _, verdict := range verdicts {
+			result := newScanResult(verdict.Path, verdict)
+			if threats {
+				result.Threats = verdict.Signatures
+			}
+			results = append(results, result)
+		}
+	}
+	return results, true
+}
 
-		filePaths := strings.Split(string(body), "\n")
+// worstStatus 返回一组结论中最严重的状态：infected 优先于 error，error 优先于 clean
+func worstStatus(verdicts []clamav.Verdict) clamav.Status {
+	status := clamav.StatusClean
+	for _, verdict := range verdicts {
+		switch {
+		case verdict.Infected():
+			return clamav.StatusInfected
+		case verdict.Status == clamav.StatusError:
+			status = clamav.StatusError
+		}
+	}
+	return status
+}
 
-		for _, filePath := range filePaths {
-			filePath = strings.TrimSpace(filePath)
-			if filePath == "" {
-				continue
-			}
+// auditScan 写入扫描的审计记录，客户端中途断开时只包含已扫描的文件
+func (h *Handler) auditScan(r *http.Request, results []ScanResult) {
+	entry := auditEntry(r, audit.EventScan)
+	entry.Files = auditFiles(results)
+	h.audit.Log(entry)
+}
+
+// scanMultipartFiles 扫描已解析的表单中的所有上传文件，请求取消后不再扫描剩余文件
+//
+// threats 为 true 时将clamd报告的特征写入结果的 Threats。INSTREAM 没有全部匹配的命令，
+// clamd 通常只报告上传文件命中的第一个特征。
+func (h *Handler) scanMultipartFiles(r *http.Request, threats bool) []ScanResult {
+	var results []ScanResult
 
-			scanResult, err := h.scanner.ScanFile(filePath)
-			if err != nil {
-				results = append(results, ScanResult{
-					FileName: filePath,
-					IsSafe:   false,
-					Threat:   fmt.Sprintf("扫描错误: %v", err),
-				})
-			} else {
-				isSafe, threat := parseScanResult(scanResult)
-				results = append(results, ScanResult{
-					FileName: filePath,
-					IsSafe:   isSafe,
-					Threat:   threat,
-				})
+	for _, fileHeaders := range r.MultipartForm.File {
+		for _, fileHeader := range fileHeaders {
+			if r.Context().Err() != nil {
+				return results
 			}
+			results = append(results, h.scanFileHeader(r.Context(), fileHeader, threats))
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	return results
 }
 
-// parseScanResult 解析扫描结果字符串
-func parseScanResult(result string) (bool, string) {
-	parts := strings.Split(result, ":")
-	if len(parts) < 2 {
-		return false, "解析扫描结果失败"
+// scanFileHeader 以流的方式扫描单个上传文件
+func (h *Handler) scanFileHeader(ctx context.Context, fileHeader *multipart.FileHeader, threats bool) ScanResult {
+	file, err := fileHeader.Open()
+	if err != nil {
+		result := errorScanResult(fileHeader.Filename, "打开文件失败: %v", err)
+		result.Size = fileHeader.Size
+		return result
 	}
+	defer file.Close()
 
-	status := strings.TrimSpace(parts[len(parts)-1])
-	if status == "OK" {
-		return true, ""
+	scanned, err := h.cache.ScanStreamContext(ctx, file)
+	if err != nil {
+		result := errorScanResult(fileHeader.Filename, "扫描错误: %v", err)
+		result.Size = fileHeader.Size
+		return result
+	}
+
+	result := newScanResult(fileHeader.Filename, scanned.Verdict)
+	if threats {
+		result.Threats = scanned.Verdict.Signatures
 	}
+	result.Size = fileHeader.Size
+	result.SHA256 = scanned.SHA256
+	result.Cached = scanned.Cached
+	return result
+}
 
-	threat := strings.TrimSpace(strings.TrimSuffix(status, "FOUND"))
-	return false, threat
+// threatsOption 解析 threats 查询参数，参数无效时写入错误响应并返回 false
+func threatsOption(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	value := r.URL.Query().Get("threats")
+	if value == "" {
+		return false, true
+	}
+	threats, err := strconv.ParseBool(value)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("无效的 threats 参数 '%s'", value), map[string]any{"field": "threats"})
+		return false, false
+	}
+	return threats, true
 }
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/clamav"
+	"github.com/SmallGaoX/clamd-api/metrics"
+)
+
+// 检查结果状态
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// CheckResult 是单项就绪检查的结果
+type CheckResult struct {
+	Status   string         `json:"status"` // ok 或 fail
+	Error    string         `json:"error,omitempty"`
+	Duration string         `json:"duration"`
+	Details  map[string]any `json:"details,omitempty"`
+}
+
+// ReadinessResponse 是 /readyz 的响应
+type ReadinessResponse struct {
+	Status string                 `json:"status"` // ready 或 not_ready
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// HealthzHandler 处理存活检查，只要进程能够响应请求就返回 200，不需要 API key
+func (h *Handler) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler 处理就绪检查，检查 clamd、病毒库时效和 API key 文件，不需要 API key
+func (h *Handler) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	response := ReadinessResponse{
+		Status: "ready",
+		Checks: map[string]CheckResult{
+			"clamd":      h.runCheck(r.Context(), h.checkClamd),
+			"signatures": h.runCheck(r.Context(), h.checkSignatures),
+			"apiKeyFile": h.runCheck(r.Context(), h.checkAPIKeyFile),
+		},
+	}
+
+	status := http.StatusOK
+	for _, check := range response.Checks {
+		if check.Status != checkOK {
+			response.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// runCheck 在超时时间内执行一项检查并记录耗时
+func (h *Handler) runCheck(ctx context.Context, check func(ctx context.Context) (map[string]any, error)) CheckResult {
+	if h.config.ReadyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.config.ReadyTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	details, err := check(ctx)
+	result := CheckResult{
+		Status:   checkOK,
+		Duration: time.Since(start).String(),
+		Details:  details,
+	}
+	if err != nil {
+		result.Status = checkFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkClamd 检查 clamd 是否响应 PING；有多个后端时只要有一个响应即通过，details 中给出健康的后端数
+func (h *Handler) checkClamd(ctx context.Context) (map[string]any, error) {
+	err := h.scanner.PingContext(ctx)
+
+	reporter, ok := h.scanner.(clamav.HealthReporter)
+	if !ok {
+		return nil, err
+	}
+	backends := reporter.Backends()
+	healthy := 0
+	for _, backend := range backends {
+		if backend.Healthy {
+			healthy++
+		}
+	}
+	return map[string]any{"backends": len(backends), "healthyBackends": healthy}, err
+}
+
+// checkSignatures 检查病毒库是否超过 MaxSignatureAge，超过 SignatureWarnAge 时只在 details.warning 中提示
+func (h *Handler) checkSignatures(ctx context.Context) (map[string]any, error) {
+	version, err := h.scanner.GetVersionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := clamav.ParseVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]any{
+		"engine":   info.Engine,
+		"database": info.Database,
+	}
+	if !info.DatabaseTime.IsZero() {
+		details["databaseTime"] = info.DatabaseTime
+		details["ageHours"] = int(info.DatabaseAge(time.Now()).Hours())
+	}
+
+	warning, err := h.signatureFreshness(ctx, info)
+	if warning != "" {
+		details["warning"] = warning
+	}
+	return details, err
+}
+
+// signatureFreshness 检查病毒库时效：未加载病毒库或超过 MaxSignatureAge 时返回错误，超过 SignatureWarnAge 时只返回警告
+//
+// 同时更新病毒库时长指标，病毒库在过期与未过期之间变化时记录日志。
+func (h *Handler) signatureFreshness(ctx context.Context, info clamav.VersionInfo) (string, error) {
+	if info.DatabaseTime.IsZero() {
+		return "", errors.New("clamd 未加载病毒库")
+	}
+
+	age := info.DatabaseAge(time.Now())
+	metrics.SignatureAge.Set(age.Seconds())
+
+	var warning string
+	var err error
+	switch maxAge, warnAge := h.config.MaxSignatureAge, h.config.SignatureWarnAge; {
+	case maxAge > 0 && age > maxAge:
+		err = fmt.Errorf("病毒库已超过 %s 未更新", maxAge)
+	case warnAge > 0 && age > warnAge:
+		warning = fmt.Sprintf("病毒库已超过 %s 未更新", warnAge)
+	}
+
+	stale := err != nil || warning != ""
+	if h.staleSignatures.Swap(stale) != stale {
+		if stale {
+			slog.WarnContext(ctx, "病毒库已过期", "database", info.Database, "databaseTime", info.DatabaseTime, "ageHours", int(age.Hours()))
+		} else {
+			slog.InfoContext(ctx, "病毒库已更新", "database", info.Database, "databaseTime", info.DatabaseTime)
+		}
+	}
+	return warning, err
+}
+
+// checkAPIKeyFile 检查 API key 文件是否可读
+func (h *Handler) checkAPIKeyFile(ctx context.Context) (map[string]any, error) {
+	file, err := os.Open(h.apiKeyManager.GetFilePath())
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 API key 文件: %v", err)
+	}
+	return nil, file.Close()
+}
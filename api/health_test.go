@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/auth"
+	"github.com/SmallGaoX/clamd-api/clamav"
+	"github.com/SmallGaoX/clamd-api/config"
+)
+
+// stubBackend 是只响应 PING 和 VERSION 的 clamd 后端，down 为 true 时两者都失败
+type stubBackend struct {
+	clamav.ContextScanner
+	down bool
+}
+
+func (s *stubBackend) PingContext(ctx context.Context) error {
+	if s.down {
+		return errors.New("连接ClamAV失败")
+	}
+	return nil
+}
+
+func (s *stubBackend) GetVersionContext(ctx context.Context) (string, error) {
+	if s.down {
+		return "", errors.New("连接ClamAV失败")
+	}
+	return "ClamAV 1.3.1/27400/" + time.Now().UTC().Add(-time.Hour).Format(time.ANSIC), nil
+}
+
+// newHealthHandler 创建使用 backends 的 Handler，并等待首次健康检查结束
+func newHealthHandler(t *testing.T, backends ...*stubBackend) *Handler {
+	t.Helper()
+
+	var list []clamav.Backend
+	healthy := 0
+	for i, backend := range backends {
+		list = append(list, clamav.Backend{Address: fmt.Sprintf("tcp://clamd-%d:3310", i), Scanner: backend})
+		if !backend.down {
+			healthy++
+		}
+	}
+	balancer, err := clamav.NewBalancer(list, clamav.BalancerOptions{HealthInterval: time.Hour, FailThreshold: 1})
+	if err != nil {
+		t.Fatalf("创建负载均衡器失败: %v", err)
+	}
+	t.Cleanup(func() { balancer.Close() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for countHealthy(balancer.Backends()) != healthy {
+		if time.Now().After(deadline) {
+			t.Fatalf("健康检查没有结束: %+v", balancer.Backends())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	keys, err := auth.NewAPIKeyManager(filepath.Join(t.TempDir(), "api_keys.json"), []byte("secret"))
+	if err != nil {
+		t.Fatalf("创建 APIKeyManager 失败: %v", err)
+	}
+
+	return &Handler{
+		scanner:       balancer,
+		config:        &config.Config{ReadyTimeout: time.Second, MaxSignatureAge: 72 * time.Hour},
+		apiKeyManager: keys,
+	}
+}
+
+func countHealthy(statuses []clamav.BackendStatus) int {
+	n := 0
+	for _, status := range statuses {
+		if status.Healthy {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHealthzHandler(t *testing.T) {
+	h := &Handler{}
+
+	w := httptest.NewRecorder()
+	h.HealthzHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /healthz 返回 %d", w.Code)
+	}
+
+	for _, handler := range []http.HandlerFunc{h.HealthzHandler, h.ReadyzHandler} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/", nil))
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodGet {
+			t.Errorf("POST 返回 %d，Allow = %q", w.Code, w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestReadyzBackends(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []*stubBackend
+		status   int
+		healthy  int
+	}{
+		{"全部健康", []*stubBackend{{}, {}}, http.StatusOK, 2},
+		{"部分健康", []*stubBackend{{down: true}, {}}, http.StatusOK, 1},
+		{"全部不可用", []*stubBackend{{down: true}, {down: true}}, http.StatusServiceUnavailable, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHealthHandler(t, tt.backends...)
+
+			w := httptest.NewRecorder()
+			h.ReadyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.status {
+				t.Errorf("/readyz 返回 %d，期望 %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			var response ReadinessResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("解析响应失败: %v", err)
+			}
+			clamd := response.Checks["clamd"]
+			if clamd.Details["backends"] != float64(len(tt.backends)) || clamd.Details["healthyBackends"] != float64(tt.healthy) {
+				t.Errorf("clamd 检查 = %+v", clamd)
+			}
+			wantStatus := map[int]string{http.StatusOK: "ready", http.StatusServiceUnavailable: "not_ready"}[tt.status]
+			if response.Status != wantStatus || response.Checks["apiKeyFile"].Status != checkOK {
+				t.Errorf("响应 = %+v", response)
+			}
+		})
+	}
+}
+
+func TestSignatureFreshness(t *testing.T) {
+	h := &Handler{config: &config.Config{MaxSignatureAge: 72 * time.Hour, SignatureWarnAge: 24 * time.Hour}}
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		info        clamav.VersionInfo
+		wantWarning bool
+		wantErr     bool
+	}{
+		{"最新", clamav.VersionInfo{DatabaseTime: now.Add(-time.Hour)}, false, false},
+		{"超过警告时长", clamav.VersionInfo{DatabaseTime: now.Add(-30 * time.Hour)}, true, false},
+		{"超过最大时长", clamav.VersionInfo{DatabaseTime: now.Add(-100 * time.Hour)}, false, true},
+		{"未加载病毒库", clamav.VersionInfo{Engine: "1.3.1"}, false, true},
+	}
+	for _, tt := range tests {
+		warning, err := h.signatureFreshness(context.Background(), tt.info)
+		if (warning != "") != tt.wantWarning || (err != nil) != tt.wantErr {
+			t.Errorf("%s: signatureFreshness = %q, %v", tt.name, warning, err)
+		}
+	}
+
+	// 两项都为0时不检查时效
+	h = &Handler{config: &config.Config{}}
+	if warning, err := h.signatureFreshness(context.Background(), clamav.VersionInfo{DatabaseTime: now.AddDate(-1, 0, 0)}); warning != "" || err != nil {
+		t.Errorf("不检查时效时 signatureFreshness = %q, %v", warning, err)
+	}
+}
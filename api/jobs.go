@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/audit"
+	"github.com/SmallGaoX/clamd-api/clamav"
+	"github.com/SmallGaoX/clamd-api/jobs"
+)
+
+// JobHandler 处理异步扫描任务相关的请求
+type JobHandler struct {
+	manager  *jobs.Manager
+	notifier *Notifier
+	audit    *audit.Logger
+}
+
+// NewJobHandler 创建一个新的JobHandler实例
+func NewJobHandler(manager *jobs.Manager, notifier *Notifier, auditLog *audit.Logger) *JobHandler {
+	return &JobHandler{manager: manager, notifier: notifier, audit: auditLog}
+}
+
+// JobResponse 表示返回给客户端的任务状态
+type JobResponse struct {
+	ID        string       `json:"id"`
+	Status    jobs.Status  `json:"status"`
+	Files     int          `json:"files"`     // 文件总数
+	Completed int          `json:"completed"` // 已扫描的文件数
+	Results   []ScanResult `json:"results"`   // 已扫描文件的结果
+	Error     string       `json:"error,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+// newJobResponse 将任务转换为响应格式
+func newJobResponse(job *jobs.Job) JobResponse {
+	response := JobResponse{
+		ID:        job.ID,
+		Status:    job.Status,
+		Files:     len(job.Files),
+		Results:   []ScanResult{},
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+
+	for _, file := range job.Files {
+		if !file.Done {
+			continue
+		}
+		response.Completed++
+		result := ScanResult{
+			FileName: file.Name,
+			Status:   clamav.StatusError,
+			Error:    file.Error,
+		}
+		if file.Verdict != nil {
+			result = newScanResult(file.Name, *file.Verdict)
+		}
+		result.Size = file.Size
+		result.SHA256 = file.SHA256
+		response.Results = append(response.Results, result)
+	}
+
+	return response
+}
+
+// SubmitHandler 处理 POST /jobs，暂存上传的文件并立即返回任务ID
+func (h *JobHandler) SubmitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	if !parseForm(w, r) {
+		return
+	}
+
+	callback := callbackURL(r)
+	if !validCallbackURL(w, r, h.notifier, callback) {
+		return
+	}
+
+	var uploads []jobs.Upload
+	for _, fileHeaders := range r.MultipartForm.File {
+		for _, fileHeader := range fileHeaders {
+			fileHeader := fileHeader
+			uploads = append(uploads, jobs.Upload{
+				Name: fileHeader.Filename,
+				Open: func() (io.ReadCloser, error) { return fileHeader.Open() },
+			})
+		}
+	}
+	if len(uploads) == 0 {
+		writeError(w, r, http.StatusBadRequest, CodeNoFiles, "请求中没有文件", nil)
+		return
+	}
+
+	job, err := h.manager.Submit(apiKeyName(r), callback, uploads)
+	if errors.Is(err, jobs.ErrQueueFull) {
+		writeError(w, r, http.StatusServiceUnavailable, CodeQueueFull, "任务队列已满，请稍后重试", nil)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "创建扫描任务失败", "error", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternalError, "创建扫描任务失败", nil)
+		return
+	}
+
+	entry := auditEntry(r, audit.EventJobSubmit)
+	entry.JobID = job.ID
+	for _, file := range job.Files {
+		entry.Files = append(entry.Files, audit.File{Name: file.Name, Size: file.Size, SHA256: file.SHA256})
+	}
+	h.audit.Log(entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newJobResponse(job))
+}
+
+// StatusHandler 处理 GET /jobs/{id}（查询状态）和 DELETE /jobs/{id}（取消任务）
+func (h *JobHandler) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	job, err := h.manager.Get(id)
+	if err == nil && job.Owner != apiKeyName(r) {
+		// 不暴露其他 API key 创建的任务
+		err = jobs.ErrNotFound
+	}
+	if errors.Is(err, jobs.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "任务不存在", map[string]any{"id": id})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "获取任务失败", "jobId", id, "error", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternalError, "获取任务失败", nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		job, err = h.manager.Cancel(id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "取消任务失败", "jobId", id, "error", err)
+			writeError(w, r, http.StatusInternalServerError, CodeInternalError, "取消任务失败", nil)
+			return
+		}
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodDelete)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newJobResponse(job))
+}
+
+// apiKeyName 返回 AuthMiddleware 写入请求上下文的 API key 名称
+func apiKeyName(r *http.Request) string {
+	name, _ := r.Context().Value("APIKeyName").(string)
+	return name
+}
@@ -2,43 +2,263 @@ package api
 
 import (
 	"context"
-	"log"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/SmallGaoX/clamd-api/auth"
+	"github.com/SmallGaoX/clamd-api/logging"
+	"github.com/SmallGaoX/clamd-api/metrics"
+	"github.com/SmallGaoX/clamd-api/ratelimit"
 )
 
-// LoggingMiddleware 记录请求的中间件
+// RequestIDMiddleware 为每个请求分配请求 ID 的中间件，包在所有路由之外
+//
+// 请求带有合法的 X-Request-ID 时沿用该值，否则生成新的 ID；ID 写入 X-Request-ID 响应头和请求上下文，
+// 使用 slog 的 *Context 方法记录的日志和审计记录都会带上它。
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID 判断客户端提供的请求 ID 是否可以沿用：不超过128个可打印 ASCII 字符
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID 生成随机的请求 ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLog 保存访问日志需要的、由内层中间件填写的信息
+type requestLog struct {
+	key string
+}
+
+// requestLogKey 是 requestLog 在请求上下文中的键
+type requestLogKey struct{}
+
+// setLogKey 记录请求使用的 API key 名称，供访问日志使用
+func setLogKey(r *http.Request, name string) {
+	if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		entry.key = name
+	}
+}
+
+// LoggingMiddleware 记录请求的中间件，每个请求输出一条包含请求 ID、API key、路由、状态码和耗时的日志
+//
+// 查询参数中的敏感值会被脱敏。
 func LoggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s %s %s", r.RemoteAddr, r.Method, r.RequestURI, time.Since(start))
+		entry := &requestLog{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, entry)))
+
+		route := r.URL.Path
+		if r.URL.RawQuery != "" {
+			route = logging.RedactURL(r.URL.RequestURI())
+		}
+		slog.InfoContext(r.Context(), "HTTP 请求",
+			"method", r.Method,
+			"route", route,
+			"status", recorder.status,
+			"duration", time.Since(start),
+			"key", entry.key,
+			"clientIp", clientIP(r),
+		)
 	}
 }
 
-// AuthMiddleware 使用 API key 进行身份验证的中间件
-func AuthMiddleware(next http.HandlerFunc, apiKeyManager *auth.APIKeyManager) http.HandlerFunc {
+// MetricsMiddleware 按路由和状态码记录请求数与耗时，route 使用注册时的路由模式以避免标签过多
+func MetricsMiddleware(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		status := strconv.Itoa(recorder.status)
+		metrics.HTTPRequests.Inc(route, r.Method, status)
+		metrics.HTTPDuration.Observe(time.Since(start).Seconds(), route, status)
+	}
+}
+
+// statusRecorder 记录处理程序写入的状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader 记录状态码
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap 供 http.ResponseController 访问底层的 ResponseWriter
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// AuthMiddleware 使用 API key 进行身份验证的中间件，scope 为访问该路由需要的权限范围，为空时任何有效的 key 都可以访问
+func AuthMiddleware(next http.HandlerFunc, apiKeyManager *auth.APIKeyManager, scope string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		apiKey := r.Header.Get("X-API-Key")
 		if apiKey == "" {
-			http.Error(w, "缺少 API key", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, CodeMissingAPIKey, "缺少 API key", nil)
 			return
 		}
 
-		apiKeyManager.DebugPrintKeys()
-
 		if !apiKeyManager.IsValidAPIKey(apiKey) {
-			http.Error(w, "无效的 API key", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, CodeInvalidAPIKey, "无效的 API key", nil)
 			return
 		}
 
 		// 获取 API key 的名称，并将其添加到请求上下文中
-		if keyName, exists := apiKeyManager.GetAPIKeyName(apiKey); exists {
-			r = r.WithContext(context.WithValue(r.Context(), "APIKeyName", keyName))
+		keyName, exists := apiKeyManager.GetAPIKeyName(apiKey)
+		if !exists {
+			writeError(w, r, http.StatusUnauthorized, CodeInvalidAPIKey, "无效的 API key", nil)
+			return
+		}
+		metrics.APIKeyRequests.Inc(keyName)
+		setLogKey(r, keyName)
+
+		if !apiKeyManager.HasScope(keyName, scope) {
+			insufficientScope(w, r, keyName, scope)
+			return
 		}
 
+		r = r.WithContext(context.WithValue(r.Context(), "APIKeyName", keyName))
 		next.ServeHTTP(w, r)
 	}
 }
+
+// AdminAuthMiddleware 管理接口的身份验证中间件
+//
+// 请求带有 X-Admin-Token 时与 adminToken 进行常量时间比较，不匹配（或 adminToken 为空、引导凭据不可用）时返回 403；
+// 否则要求拥有 admin:keys 权限的 API key，缺少 key 时返回 401。引导凭据用于在还没有任何管理员 key 时创建第一个 key。
+func AdminAuthMiddleware(next http.HandlerFunc, apiKeyManager *auth.APIKeyManager, adminToken string) http.HandlerFunc {
+	keyAuth := AuthMiddleware(next, apiKeyManager, auth.ScopeAdminKeys)
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Admin-Token")
+		if token == "" {
+			keyAuth(w, r)
+			return
+		}
+
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			writeError(w, r, http.StatusForbidden, CodeInvalidAdminToken, "无效的管理凭据", nil)
+			return
+		}
+		setLogKey(r, "admin_token")
+		next.ServeHTTP(w, r)
+	}
+}
+
+// RateLimitMiddleware 按 API key 限流并检查每日上传配额的中间件，需放在 AuthMiddleware 之后
+//
+// 超过限制时返回 429 和 Retry-After；请求体在读取时计入当天的上传字节数。
+func RateLimitMiddleware(next http.HandlerFunc, limiter *ratelimit.Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyName(r)
+
+		decision := limiter.Allow(key)
+		setRateLimitHeaders(w, "X-RateLimit", decision)
+		if !decision.Allowed {
+			metrics.RateLimited.Inc(key, "rate")
+			writeError(w, r, http.StatusTooManyRequests, CodeRateLimited, "请求过于频繁，请稍后重试", retryAfter(w, decision.RetryAfter))
+			return
+		}
+
+		quota := limiter.CheckQuota(key, r.ContentLength)
+		setRateLimitHeaders(w, "X-RateLimit-Bytes", quota)
+		if !quota.Allowed {
+			metrics.RateLimited.Inc(key, "quota")
+			writeError(w, r, http.StatusTooManyRequests, CodeQuotaExceeded, "超过每日上传配额", retryAfter(w, quota.RetryAfter))
+			return
+		}
+
+		r.Body = &quotaBody{ReadCloser: r.Body, key: key, limiter: limiter}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// ConcurrencyMiddleware 限制同时进行的扫描请求数，没有空闲名额时返回 503，避免 clamd 过载
+func ConcurrencyMiddleware(next http.HandlerFunc, limiter *ratelimit.Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, ok := limiter.Acquire()
+		if !ok {
+			metrics.RateLimited.Inc(apiKeyName(r), "concurrency")
+			writeError(w, r, http.StatusServiceUnavailable, CodeServerBusy, "服务器繁忙，请稍后重试", retryAfter(w, time.Second))
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	}
+}
+
+// setRateLimitHeaders 设置 <prefix>-Limit、<prefix>-Remaining 和 <prefix>-Reset（秒）响应头，未配置限制时不设置
+func setRateLimitHeaders(w http.ResponseWriter, prefix string, decision ratelimit.Decision) {
+	if decision.Limit == 0 {
+		return
+	}
+	w.Header().Set(prefix+"-Limit", strconv.FormatInt(decision.Limit, 10))
+	w.Header().Set(prefix+"-Remaining", strconv.FormatInt(decision.Remaining, 10))
+	w.Header().Set(prefix+"-Reset", strconv.FormatInt(ceilSeconds(decision.Reset), 10))
+}
+
+// retryAfter 设置 Retry-After 响应头（至少为1秒），并返回写入错误响应 details 的 retryAfter
+func retryAfter(w http.ResponseWriter, d time.Duration) map[string]any {
+	seconds := max(1, ceilSeconds(d))
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	return map[string]any{"retryAfter": seconds}
+}
+
+// ceilSeconds 将时长向上取整为秒
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// quotaBody 在读取请求体时把字节数计入 API key 的每日配额，超过配额后读取返回错误
+type quotaBody struct {
+	io.ReadCloser
+	key     string
+	limiter *ratelimit.Limiter
+}
+
+// Read 读取请求体并记录字节数
+func (b *quotaBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if quotaErr := b.limiter.AddBytes(b.key, int64(n)); quotaErr != nil {
+			metrics.RateLimited.Inc(b.key, "quota")
+			return n, quotaErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,22 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPISpec 是描述所有接口的 OpenAPI 3 文档，修改接口时需同步更新 openapi.json
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// OpenAPIHandler 处理 GET /openapi.json，返回 OpenAPI 文档，不需要 API key
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(OpenAPISpec)
+}
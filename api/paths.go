@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// errPathNotAllowed 表示路径不在 scan_roots 配置的目录内
+var errPathNotAllowed = errors.New("路径不在允许扫描的目录内")
+
+// resolveScanPath 检查 path 位于 roots 中的某个目录内，返回解析符号链接后的绝对路径
+//
+// 先按清理后的字面路径检查，避免通过返回的错误探测允许范围外的路径是否存在；
+// 再检查解析符号链接后的路径，防止通过符号链接跳出允许的目录。
+// 路径不存在等文件系统错误原样返回。
+func resolveScanPath(roots []string, path string) (string, error) {
+	if strings.ContainsAny(path, "\x00\r\n") {
+		return "", fmt.Errorf("%w: 路径包含控制字符", errPathNotAllowed)
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%w: '%s' 不是绝对路径", errPathNotAllowed, path)
+	}
+
+	cleaned := filepath.Clean(path)
+	if !withinRoots(roots, cleaned) {
+		return "", fmt.Errorf("%w: '%s'", errPathNotAllowed, path)
+	}
+
+	resolved, err := filepath.EvalSymlinks(cleaned)
+	if err != nil {
+		return "", err
+	}
+	if !withinRoots(roots, resolved) {
+		return "", fmt.Errorf("%w: '%s' 通过符号链接指向允许的目录之外", errPathNotAllowed, path)
+	}
+	return resolved, nil
+}
+
+// withinRoots 判断 path 是否为某个根目录或位于其中，根目录本身是符号链接时也与解析后的目录比较
+func withinRoots(roots []string, path string) bool {
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if within(root, path) {
+			return true
+		}
+		if resolved, err := filepath.EvalSymlinks(root); err == nil && within(resolved, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// within 判断 path 是否为 root 或位于 root 之下，两者都应是清理过的绝对路径
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
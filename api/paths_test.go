@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveScanPath(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "data")
+	outside := filepath.Join(base, "secret")
+	for _, dir := range []string{filepath.Join(root, "dir"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(root, "dir", "a.txt"), filepath.Join(outside, "key")} {
+		if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// root 内指向 root 外和 root 内的符号链接
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "dir", "a.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	// 根目录本身是符号链接
+	linkedRoot := filepath.Join(base, "linked")
+	if err := os.Symlink(root, linkedRoot); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := map[string]string{
+		root:                                  root,
+		filepath.Join(root, "dir") + "/":      filepath.Join(root, "dir"),
+		filepath.Join(root, "dir", "a.txt"):   filepath.Join(root, "dir", "a.txt"),
+		root + "/dir/../dir/a.txt":            filepath.Join(root, "dir", "a.txt"),
+		filepath.Join(root, "link.txt"):       filepath.Join(root, "dir", "a.txt"),
+		filepath.Join(linkedRoot, "dir"):      filepath.Join(root, "dir"),
+		filepath.Join(root, "dir", "missing"): "",
+	}
+	for path, want := range allowed {
+		got, err := resolveScanPath([]string{linkedRoot}, path)
+		if want == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("resolveScanPath(%q) 返回 %q, %v，期望文件不存在", path, got, err)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("resolveScanPath(%q) = %q, %v，期望 %q", path, got, err, want)
+		}
+	}
+
+	denied := []string{
+		outside,
+		filepath.Join(outside, "key"),
+		root + "/../secret/key",
+		filepath.Join(root, "escape"),
+		filepath.Join(root, "escape", "key"),
+		filepath.Join(base, "data2"),
+		filepath.Join(base, "missing", "x"), // 允许范围外的路径不返回是否存在
+		"data/dir",
+		root + "/dir\nPING",
+	}
+	for _, path := range denied {
+		if got, err := resolveScanPath([]string{root}, path); !errors.Is(err, errPathNotAllowed) {
+			t.Errorf("resolveScanPath(%q) = %q, %v，期望 errPathNotAllowed", path, got, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/SmallGaoX/clamd-api/ratelimit"
+)
+
+// UsageHandler 处理 API key 用量查询
+type UsageHandler struct {
+	limiter *ratelimit.Limiter
+}
+
+// NewUsageHandler 创建一个新的UsageHandler实例
+func NewUsageHandler(limiter *ratelimit.Limiter) *UsageHandler {
+	return &UsageHandler{limiter: limiter}
+}
+
+// UsageHandler 处理 GET /usage，返回当前 API key 当天的用量和限制
+func (h *UsageHandler) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.limiter.Usage(apiKeyName(r)))
+}
+
+// AllUsageHandler 处理 GET /admin/usage，返回当天有请求的所有 API key 的用量；
+// 指定 key 参数时只返回该 API key 的用量
+func (h *UsageHandler) AllUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if key := r.URL.Query().Get("key"); key != "" {
+		json.NewEncoder(w).Encode(h.limiter.Usage(key))
+		return
+	}
+	json.NewEncoder(w).Encode(h.limiter.AllUsage())
+}
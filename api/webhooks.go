@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/SmallGaoX/clamd-api/auth"
+	"github.com/SmallGaoX/clamd-api/clamav"
+	"github.com/SmallGaoX/clamd-api/jobs"
+	"github.com/SmallGaoX/clamd-api/logging"
+	"github.com/SmallGaoX/clamd-api/webhook"
+)
+
+// Notifier 在扫描完成时推送回调，并向 API key 配置的 webhook 推送威胁告警
+type Notifier struct {
+	dispatcher    *webhook.Dispatcher
+	apiKeyManager *auth.APIKeyManager
+}
+
+// NewNotifier 创建一个新的Notifier实例
+func NewNotifier(dispatcher *webhook.Dispatcher, apiKeyManager *auth.APIKeyManager) *Notifier {
+	return &Notifier{dispatcher: dispatcher, apiKeyManager: apiKeyManager}
+}
+
+// ScanCompletedEvent 是同步扫描完成时推送的数据
+type ScanCompletedEvent struct {
+	Route   string       `json:"route"`
+	Results []ScanResult `json:"results"`
+}
+
+// ThreatFoundEvent 是发现威胁时推送的数据
+type ThreatFoundEvent struct {
+	KeyName string       `json:"keyName"`
+	JobID   string       `json:"jobId,omitempty"`
+	Results []ScanResult `json:"results"` // 只包含发现威胁的文件
+}
+
+// ScanCompleted 推送同步扫描的结果
+func (n *Notifier) ScanCompleted(r *http.Request, callbackURL string, results []ScanResult) {
+	if n == nil {
+		return
+	}
+
+	owner := apiKeyName(r)
+	if callbackURL != "" {
+		n.send(owner, callbackURL, webhook.EventScanCompleted, ScanCompletedEvent{Route: r.URL.Path, Results: results})
+	}
+	n.threatFound(owner, "", results)
+}
+
+// JobFinished 推送异步任务的结果，用作 jobs.Options.OnFinish
+func (n *Notifier) JobFinished(job *jobs.Job) {
+	if n == nil {
+		return
+	}
+
+	response := newJobResponse(job)
+	if job.CallbackURL != "" {
+		n.send(job.Owner, job.CallbackURL, webhook.EventScanCompleted, response)
+	}
+	n.threatFound(job.Owner, job.ID, response.Results)
+}
+
+// threatFound 向 API key 配置的 webhook 推送发现威胁的文件
+func (n *Notifier) threatFound(owner, jobID string, results []ScanResult) {
+	var threats []ScanResult
+	for _, result := range results {
+		if result.Status == clamav.StatusInfected {
+			threats = append(threats, result)
+		}
+	}
+	if len(threats) == 0 {
+		return
+	}
+
+	event := ThreatFoundEvent{KeyName: owner, JobID: jobID, Results: threats}
+	for _, target := range n.apiKeyManager.GetWebhooks(owner) {
+		n.send(owner, target, webhook.EventThreatFound, event)
+	}
+}
+
+// send 将事件加入投递队列
+func (n *Notifier) send(owner, target, event string, data any) {
+	if _, err := n.dispatcher.Send(owner, target, event, data); err != nil {
+		slog.Error("webhook 加入投递队列失败", "event", event, "url", logging.RedactURL(target), "key", owner, "error", err)
+	}
+}
+
+// DeliveriesHandler 返回当前 API key 触发的 webhook 投递记录
+func (n *Notifier) DeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n.dispatcher.Deliveries(apiKeyName(r)))
+}
+
+// callbackURL 返回请求中的 callback_url 参数，multipart 表单字段优先于查询参数
+func callbackURL(r *http.Request) string {
+	if r.MultipartForm != nil {
+		if values := r.MultipartForm.Value["callback_url"]; len(values) > 0 {
+			return values[0]
+		}
+	}
+	return r.URL.Query().Get("callback_url")
+}
+
+// CheckURL 检查回调地址，拒绝指向非公网地址（不在 webhook_allowed_hosts 中）的地址
+func (n *Notifier) CheckURL(target string) error {
+	if n == nil {
+		return webhook.ValidateURL(target)
+	}
+	return n.dispatcher.CheckURL(target)
+}
+
+// validCallbackURL 校验 callback_url 参数，无效时返回 400 并返回 false
+func validCallbackURL(w http.ResponseWriter, r *http.Request, notifier *Notifier, target string) bool {
+	if target == "" {
+		return true
+	}
+	if err := notifier.CheckURL(target); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidCallbackURL, err.Error(), map[string]any{"field": "callback_url"})
+		return false
+	}
+	return true
+}
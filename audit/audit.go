@@ -0,0 +1,198 @@
+// Package audit 以 JSON lines 格式记录扫描和管理操作的审计日志，按文件大小轮转
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 审计事件类型
+const (
+	EventScan       = "scan"       // 同步扫描或异步任务完成
+	EventJobSubmit  = "job.submit" // 提交异步扫描任务
+	EventKeyAdd     = "key.add"
+	EventKeyDelete  = "key.delete"
+	EventKeyRotate  = "key.rotate"
+	EventKeyMigrate = "key.migrate"
+	EventReload     = "reload" // 重新加载病毒数据库
+)
+
+// File 是一次扫描中单个文件的记录
+type File struct {
+	Name       string   `json:"name"`
+	Size       int64    `json:"size,omitempty"`
+	SHA256     string   `json:"sha256,omitempty"`
+	Verdict    string   `json:"verdict,omitempty"` // clean、infected 或 error
+	Signatures []string `json:"signatures,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Entry 是一条审计记录
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	RequestID string    `json:"requestId,omitempty"` // HTTP 请求的 X-Request-ID
+	Key       string    `json:"key,omitempty"`       // 发起操作的 API key 名称，使用 admin_token 时为 "admin_token"
+	User      string    `json:"user,omitempty"`      // 通过命令行操作时的系统用户
+	ClientIP  string    `json:"clientIp,omitempty"`  // HTTP 请求的客户端地址
+	Method    string    `json:"method,omitempty"`
+	Route     string    `json:"route,omitempty"`
+	JobID     string    `json:"jobId,omitempty"`
+	Target    string    `json:"target,omitempty"` // 被操作的 API key 名称
+	TargetID  string    `json:"targetId,omitempty"`
+	Files     []File    `json:"files,omitempty"`
+	Error     string    `json:"error,omitempty"` // 操作失败的原因
+}
+
+// Options 是审计日志的配置
+type Options struct {
+	Path       string // 日志文件路径
+	MaxSize    int64  // 单个文件的最大字节数，超过后轮转，0 表示不轮转
+	MaxBackups int    // 保留的轮转文件数（<Path>.1 最新），0 表示不删除旧文件
+}
+
+// Logger 将审计记录追加写入文件，可以在多个协程中使用；nil 的 Logger 不记录任何内容
+type Logger struct {
+	opts Options
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open 打开（必要时创建）审计日志文件，Path 为空时返回 nil，表示不记录审计日志
+func Open(opts Options) (*Logger, error) {
+	if opts.Path == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0700); err != nil {
+		return nil, fmt.Errorf("创建审计日志目录失败: %v", err)
+	}
+
+	l := &Logger{opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open 以追加方式打开日志文件，调用方需持有锁或尚未共享 Logger
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("打开审计日志失败: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("打开审计日志失败: %v", err)
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Log 写入一条审计记录，Time 为空时使用当前时间
+//
+// 写入失败不影响请求的处理，但会记录到服务日志中。
+func (l *Logger) Log(entry Entry) {
+	if l == nil {
+		return
+	}
+	if err := l.write(entry); err != nil {
+		slog.Error("写入审计日志失败", "event", entry.Event, "requestId", entry.RequestID, "error", err)
+	}
+}
+
+// write 序列化并写入一条记录，必要时先轮转
+func (l *Logger) write(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("审计日志已关闭")
+	}
+	if l.opts.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.opts.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate 将当前文件重命名为 <Path>.1，已有的轮转文件依次后移，超过 MaxBackups 的被删除，调用方需持有锁
+//
+// 重命名失败时继续追加写入原文件。
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	renameErr := l.shiftBackups()
+	if err := l.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("轮转审计日志失败: %v", renameErr)
+	}
+	return nil
+}
+
+// shiftBackups 将轮转文件依次后移，并把当前文件重命名为 <Path>.1
+func (l *Logger) shiftBackups() error {
+	backups := l.opts.MaxBackups
+	if backups > 0 {
+		os.Remove(backupName(l.opts.Path, backups))
+	} else {
+		// 不删除旧文件：找到第一个不存在的序号
+		for backups = 1; ; backups++ {
+			if _, err := os.Stat(backupName(l.opts.Path, backups)); os.IsNotExist(err) {
+				break
+			}
+		}
+	}
+	for i := backups - 1; i >= 1; i-- {
+		if err := os.Rename(backupName(l.opts.Path, i), backupName(l.opts.Path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.opts.Path, backupName(l.opts.Path, 1))
+}
+
+// backupName 返回第 n 个轮转文件的路径
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Close 关闭日志文件
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
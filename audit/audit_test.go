@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readEntries 读取审计日志文件中的所有记录
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("无效的记录 %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Log(Entry{
+		Event:    EventScan,
+		Key:      "alice",
+		ClientIP: "192.0.2.1",
+		Route:    "/scan",
+		Files: []File{{
+			Name:       "eicar.com",
+			Size:       68,
+			SHA256:     "275a021bbfb6489e54d471899f7db9d1663fc695ec2fe2a2c4538aabf651fd0f",
+			Verdict:    "infected",
+			Signatures: []string{"Eicar-Signature"},
+		}},
+	})
+	l.Log(Entry{Event: EventKeyDelete, User: "root", Target: "bob", Time: time.Date(2024, 10, 1, 8, 0, 0, 0, time.FixedZone("CST", 8*3600))})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开后追加写入
+	l, err = Open(Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Log(Entry{Event: EventReload, Key: "ops"})
+	l.Close()
+
+	entries := readEntries(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("记录数 = %d，期望 3", len(entries))
+	}
+	if e := entries[0]; e.Key != "alice" || e.Time.IsZero() || len(e.Files) != 1 || e.Files[0].Signatures[0] != "Eicar-Signature" {
+		t.Errorf("entries[0] = %+v", e)
+	}
+	if e := entries[1]; e.Time.Location() != time.UTC || e.Time.Hour() != 0 || e.Target != "bob" {
+		t.Errorf("entries[1] = %+v", e)
+	}
+	if e := entries[2]; e.Event != EventReload {
+		t.Errorf("entries[2] = %+v", e)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("文件权限 = %v, %v", info.Mode().Perm(), err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(Options{Path: path, MaxSize: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 20; i++ {
+		l.Log(Entry{Event: EventScan, Key: "alice", Route: "/scan"})
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("%s 大小 = %d，超过 MaxSize", name, info.Size())
+		}
+		if len(readEntries(t, name)) == 0 {
+			t.Errorf("%s 中没有记录", name)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("超过 MaxBackups 的文件没有删除: %v", err)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	l, err := Open(Options{})
+	if err != nil || l != nil {
+		t.Fatalf("Open 返回 %v, %v，期望 nil", l, err)
+	}
+	l.Log(Entry{Event: EventScan})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,40 +1,90 @@
 package auth
 
 import (
-	"bufio"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 	"sync"
 	"time"
 )
 
-const encryptionKey = "clamav-api-secret" // 用于 XOR 加密的密钥
+// API keys 文件是带版本号的 JSON，每个 key 只保存随机的 key ID 和使用服务端密钥计算的 HMAC，
+// 文件泄露后无法还原出 API key。文件通过临时文件、fsync、rename 原子地写入，
+// 修改前获取 <文件>.lock 上的文件锁，CLI 和运行中的服务可以同时修改。
+//
+// 旧版本的 "<key ID> hmac-sha256:<HMAC> <名称>" 文本格式在加载时自动转换为 JSON，原文件备份为 <文件>.bak。
+// 更早的 "<XOR 加密的 key> <名称>" 记录可以还原出 key，加载时立即转换为 HMAC 并写回，备份中同样只保留 HMAC。
+
+// hashPrefix 是 HMAC 记录的前缀
+const hashPrefix = "hmac-sha256:"
+
+var (
+	// ErrKeyNotFound 表示指定名称的 API key 不存在
+	ErrKeyNotFound = errors.New("API key 不存在")
+	// ErrKeyExists 表示 API key 名称已存在
+	ErrKeyExists = errors.New("API key 名称已存在")
+)
+
+// keyRecord 是 API keys 文件中的一条记录
+type keyRecord struct {
+	ID         string       `json:"id"`   // 随机生成的 key ID，用于展示和日志，不能用来推导 key
+	Name       string       `json:"name"` // 名称（备注）
+	Hash       string       `json:"hash"` // "hmac-sha256:<hex>"；旧格式记录为 XOR 加密的 key
+	Legacy     bool         `json:"legacy,omitempty"`
+	Scopes     []string     `json:"scopes,omitempty"`   // 为空时使用 DefaultScopes
+	Webhooks   []string     `json:"webhooks,omitempty"` // 发现威胁时回调的地址
+	CreatedAt  time.Time    `json:"createdAt,omitzero"`
+	ExpiresAt  time.Time    `json:"expiresAt,omitzero"`
+	LastUsedAt time.Time    `json:"lastUsedAt,omitzero"`
+	Previous   *previousKey `json:"previous,omitempty"` // 轮换前的 key
+}
+
+// previousKey 是轮换前的 key，在 ExpiresAt 之前仍然有效
+type previousKey struct {
+	Hash      string    `json:"hash"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
 
 // APIKeyManager 管理 API keys
 type APIKeyManager struct {
-	apiKeys     map[string]string // 键是加密后的 API key，值是名称
-	nameToKey   map[string]string // 键是名称，值是加密后的 API key
-	mutex       sync.RWMutex
-	file        string
-	lastModTime time.Time
+	records  map[string]*keyRecord // 键是名称
+	secret   []byte                // 计算 HMAC 的服务端密钥
+	mutex    sync.RWMutex
+	file     string
+	fileInfo os.FileInfo // 上次加载的文件信息，用于判断文件是否被修改
+	text     bool        // 上次加载的文件是否为旧的文本格式
+
+	// 最近使用时间只记录在内存中，由 FlushLastUsed 定期写入文件
+	usedMutex sync.Mutex
+	lastUsed  map[string]time.Time
+	stop      chan struct{}
+	stopped   chan struct{}
 }
 
-// NewAPIKeyManager 创建一个新的 APIKeyManager
-func NewAPIKeyManager(file string) (*APIKeyManager, error) {
+// NewAPIKeyManager 创建一个新的 APIKeyManager，secret 为计算 HMAC 的服务端密钥
+func NewAPIKeyManager(file string, secret []byte) (*APIKeyManager, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("API key 密钥不能为空")
+	}
+
 	manager := &APIKeyManager{
-		apiKeys:   make(map[string]string),
-		nameToKey: make(map[string]string),
-		file:      file,
+		records:  make(map[string]*keyRecord),
+		secret:   secret,
+		file:     file,
+		lastUsed: make(map[string]time.Time),
 	}
 
 	// 检查文件是否存在，如果不存在则创建
 	if _, err := os.Stat(file); os.IsNotExist(err) {
-		_, err := os.Create(file)
-		if err != nil {
+		if err := manager.update(func() error { return nil }); err != nil {
 			return nil, fmt.Errorf("创建 API keys 文件失败: %v", err)
 		}
 	}
@@ -47,207 +97,189 @@ func NewAPIKeyManager(file string) (*APIKeyManager, error) {
 	return manager, nil
 }
 
-// loadAPIKeys 从文件加载 API keys
-func (m *APIKeyManager) loadAPIKeys() error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	fileInfo, err := os.Stat(m.file)
-	if err != nil {
-		return err
+// LoadOrCreateSecret 从文件读取 API key 密钥，文件不存在时生成随机密钥并以 0600 权限保存
+func LoadOrCreateSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return nil, fmt.Errorf("API key 密钥文件为空: %s", path)
+		}
+		return []byte(secret), nil
 	}
-
-	if fileInfo.Size() == 0 {
-		// 文件为空，不需要加载任何内容
-		m.lastModTime = fileInfo.ModTime()
-		return nil
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取 API key 密钥文件失败: %v", err)
 	}
 
-	if fileInfo.ModTime() == m.lastModTime {
-		return nil // 文件未被修改，无需重新加载
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("生成 API key 密钥失败: %v", err)
 	}
+	secret := hex.EncodeToString(b)
 
-	file, err := os.Open(m.file)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("创建 API key 密钥文件失败: %v", err)
 	}
 	defer file.Close()
-
-	m.apiKeys = make(map[string]string)
-	m.nameToKey = make(map[string]string)
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) == 2 {
-			encryptedKey := parts[0]
-			name := parts[1]
-			m.apiKeys[encryptedKey] = name
-			m.nameToKey[name] = encryptedKey
-		}
+	if _, err := fmt.Fprintln(file, secret); err != nil {
+		return nil, fmt.Errorf("写入 API key 密钥文件失败: %v", err)
 	}
 
-	m.lastModTime = fileInfo.ModTime()
-
-	return scanner.Err()
+	slog.Warn("已生成 API key 密钥文件，丢失该文件后所有 API key 都将失效", "file", path)
+	return []byte(secret), nil
 }
 
-// IsValidAPIKey 检查 API key 是否有效
-func (m *APIKeyManager) IsValidAPIKey(apiKey string) bool {
-	if err := m.loadAPIKeys(); err != nil {
-		fmt.Printf("重新加载 API keys 时出错: %v\n", err)
-		return false
-	}
+// loadAPIKeys 从文件加载 API keys，文件为旧的文本格式或包含旧的 XOR 记录时立即转换并写回
+func (m *APIKeyManager) loadAPIKeys() error {
+	m.mutex.Lock()
+	err := m.checkAndReload()
+	convert := err == nil && (m.text || m.hasLegacy())
+	m.mutex.Unlock()
 
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-	encryptedKey := encryptAPIKey(apiKey)
-	_, exists := m.apiKeys[encryptedKey]
-	return exists
+	if err != nil || !convert {
+		return err
+	}
+	// update 在文件锁内重新加载，并在写回前转换旧记录
+	if err := m.update(func() error { return nil }); err != nil {
+		return fmt.Errorf("转换 API keys 文件失败: %v", err)
+	}
+	return nil
 }
 
-// GetAPIKeyName 返回给定 API key 的名称
-func (m *APIKeyManager) GetAPIKeyName(apiKey string) (string, bool) {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-	encryptedKey := encryptAPIKey(apiKey)
-	name, exists := m.apiKeys[encryptedKey]
-	return name, exists
+// hashAPIKey 使用服务端密钥计算 API key 的 HMAC
+func (m *APIKeyManager) hashAPIKey(apiKey string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(apiKey))
+	return hashPrefix + hex.EncodeToString(mac.Sum(nil))
 }
 
-// AddAPIKey 添加新的 API key
-func (m *APIKeyManager) AddAPIKey(apiKey, name string) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	if _, exists := m.nameToKey[name]; exists {
-		return errors.New("API key 名称已存在")
+// lookup 查找 API key 对应的记录，已过期的 key 和已过宽限期的轮换前 key 不匹配，调用方需持有读锁
+//
+// 与每一条记录都进行常量时间比较且不提前返回，避免通过响应时间推测 key。
+func (m *APIKeyManager) lookup(apiKey string) *keyRecord {
+	hashed := []byte(m.hashAPIKey(apiKey))
+	legacy := []byte(encryptLegacyKey(apiKey))
+	matches := func(stored string) bool {
+		candidate := hashed
+		if isLegacyHash(stored) {
+			candidate = legacy
+		}
+		return subtle.ConstantTimeCompare(candidate, []byte(stored)) == 1
 	}
 
-	encryptedKey := encryptAPIKey(apiKey)
-	if _, exists := m.apiKeys[encryptedKey]; exists {
-		return errors.New("API key 已存在")
+	now := time.Now()
+	var found *keyRecord
+	for _, record := range m.records {
+		if matches(record.Hash) && !record.expired(now) {
+			found = record
+		}
+		if previous := record.Previous; previous != nil {
+			if matches(previous.Hash) && now.Before(previous.ExpiresAt) {
+				found = record
+			}
+		}
 	}
+	return found
+}
 
-	m.apiKeys[encryptedKey] = name
-	m.nameToKey[name] = encryptedKey
-
-	file, err := os.OpenFile(m.file, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		return err
+// IsValidAPIKey 检查 API key 是否有效，并记录最近使用时间
+func (m *APIKeyManager) IsValidAPIKey(apiKey string) bool {
+	if err := m.loadAPIKeys(); err != nil {
+		slog.Error("重新加载 API keys 时出错", "file", m.file, "error", err)
+		return false
 	}
-	defer file.Close()
 
-	_, err = fmt.Fprintf(file, "%s %s\n", encryptedKey, name)
-	return err
-}
-
-// RemoveAPIKey 通过名称删除 API key
-func (m *APIKeyManager) RemoveAPIKey(name string) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	m.mutex.RLock()
+	record := m.lookup(apiKey)
+	m.mutex.RUnlock()
 
-	// 检查是否需要重新加载
-	if err := m.checkAndReload(); err != nil {
-		return fmt.Errorf("重新加载 API keys 失败: %v", err)
+	if record == nil {
+		return false
 	}
+	m.touch(record.Name)
+	return true
+}
 
-	encryptedKey, exists := m.nameToKey[name]
-	if !exists {
-		return fmt.Errorf("未找到名称为 '%s' 的 API key", name)
+// GetAPIKeyName 返回给定 API key 的名称
+func (m *APIKeyManager) GetAPIKeyName(apiKey string) (string, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	record := m.lookup(apiKey)
+	if record == nil {
+		return "", false
 	}
-
-	delete(m.apiKeys, encryptedKey)
-	delete(m.nameToKey, name)
-
-	// 立即保存更改
-	return m.saveAPIKeys()
+	return record.Name, true
 }
 
-// 添加一个新的方法来检查是否需要重新加载
-func (m *APIKeyManager) checkAndReload() error {
-	fileInfo, err := os.Stat(m.file)
-	if err != nil {
+// AddAPIKey 添加新的 API key，文件中只保存 key ID 和 HMAC
+func (m *APIKeyManager) AddAPIKey(apiKey, name string, opts KeyOptions) error {
+	if err := ValidateScopes(opts.Scopes); err != nil {
 		return err
 	}
 
-	if fileInfo.ModTime() != m.lastModTime {
-		return m.loadAPIKeys()
-	}
-
-	return nil
-}
+	return m.update(func() error {
+		if _, exists := m.records[name]; exists {
+			return ErrKeyExists
+		}
 
-// rewriteAPIKeysFile 重写 API keys 文件
-func (m *APIKeyManager) rewriteAPIKeysFile() error {
-	file, err := os.Create(m.file)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+		if m.lookup(apiKey) != nil {
+			return errors.New("API key 已存在")
+		}
 
-	for encryptedKey, name := range m.apiKeys {
-		_, err := fmt.Fprintf(file, "%s %s\n", encryptedKey, name)
+		id, err := newKeyID()
 		if err != nil {
-			return err
+			return fmt.Errorf("生成 key ID 失败: %v", err)
 		}
-	}
-
-	return nil
-}
 
-// reloadAPIKeys 重新加载 API keys
-func (m *APIKeyManager) reloadAPIKeys() error {
-	m.apiKeys = make(map[string]string)
-	m.nameToKey = make(map[string]string)
-	return m.loadAPIKeys()
+		record := &keyRecord{ID: id, Hash: m.hashAPIKey(apiKey), Name: name}
+		opts.apply(record, time.Now())
+		m.records[name] = record
+		return nil
+	})
 }
 
-// LoadAPIKeys 加载 API keys
-func (m *APIKeyManager) LoadAPIKeys() error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-	return m.loadAPIKeys()
-}
+// RemoveAPIKey 通过名称删除 API key
+func (m *APIKeyManager) RemoveAPIKey(name string) error {
+	return m.update(func() error {
+		if _, exists := m.records[name]; !exists {
+			return fmt.Errorf("%w: '%s'", ErrKeyNotFound, name)
+		}
 
-// encryptAPIKey 加密 API key
-func encryptAPIKey(apiKey string) string {
-	encrypted := make([]byte, len(apiKey))
-	for i := 0; i < len(apiKey); i++ {
-		encrypted[i] = apiKey[i] ^ encryptionKey[i%len(encryptionKey)]
-	}
-	return base64.StdEncoding.EncodeToString(encrypted)
+		delete(m.records, name)
+		m.usedMutex.Lock()
+		delete(m.lastUsed, name)
+		m.usedMutex.Unlock()
+		return nil
+	})
 }
 
-// decryptAPIKey 解密 API key
-func decryptAPIKey(encryptedKey string) (string, error) {
-	decoded, err := base64.StdEncoding.DecodeString(encryptedKey)
-	if err != nil {
-		return "", err
-	}
-	decrypted := make([]byte, len(decoded))
-	for i := 0; i < len(decoded); i++ {
-		decrypted[i] = decoded[i] ^ encryptionKey[i%len(encryptionKey)]
-	}
-	return string(decrypted), nil
+// SetWebhooks 设置指定 API key 在发现威胁时回调的 webhook 地址，urls 为空时清除
+func (m *APIKeyManager) SetWebhooks(name string, urls []string) error {
+	return m.update(func() error {
+		record, exists := m.records[name]
+		if !exists {
+			return fmt.Errorf("%w: '%s'", ErrKeyNotFound, name)
+		}
+		record.Webhooks = append([]string(nil), urls...)
+		return nil
+	})
 }
 
-// GetAllAPIKeys 返回所有的 API key 及其备注
-func (m *APIKeyManager) GetAllAPIKeys() (map[string]string, error) {
+// GetWebhooks 返回指定 API key 配置的威胁告警 webhook 地址
+func (m *APIKeyManager) GetWebhooks(name string) []string {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
-
-	result := make(map[string]string)
-	for encryptedKey, name := range m.apiKeys {
-		decryptedKey, err := decryptAPIKey(encryptedKey)
-		if err != nil {
-			return nil, fmt.Errorf("解密 API key 失败: %v", err)
-		}
-		result[decryptedKey] = name
+	record, exists := m.records[name]
+	if !exists {
+		return nil
 	}
+	return append([]string(nil), record.Webhooks...)
+}
 
-	return result, nil
+// LoadAPIKeys 加载 API keys
+func (m *APIKeyManager) LoadAPIKeys() error {
+	return m.loadAPIKeys()
 }
 
 // GenerateAPIKey 生成一个随机的 API key
@@ -259,57 +291,16 @@ func GenerateAPIKey() (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
-// GetAllObfuscatedAPIKeys 返回所有的混淆后的 API key 及其备注
-func (m *APIKeyManager) GetAllObfuscatedAPIKeys() map[string]string {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-
-	result := make(map[string]string)
-	for encryptedKey, name := range m.apiKeys {
-		obfuscatedKey := obfuscateAPIKey(encryptedKey)
-		result[name] = obfuscatedKey
-	}
-
-	return result
-}
-
-// obfuscateAPIKey 混淆 API key,只显示前4个和后4个字符
-func obfuscateAPIKey(encryptedKey string) string {
-	if len(encryptedKey) <= 8 {
-		return encryptedKey
-	}
-	return encryptedKey[:4] + "..." + encryptedKey[len(encryptedKey)-4:]
-}
-
-// DebugPrintKeys 打印存储的 API keys（用于调试）
-func (m *APIKeyManager) DebugPrintKeys() {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-	fmt.Println("存储的 API Keys:")
-	for encryptedKey, name := range m.apiKeys {
-		fmt.Printf("加密的: %s, 名称: %s\n", encryptedKey, name)
+// newKeyID 生成随机的 key ID
+func newKeyID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(b), nil
 }
 
 // GetFilePath 返回 API key 文件的路径
 func (m *APIKeyManager) GetFilePath() string {
 	return m.file
 }
-
-// saveAPIKeys 保存 API keys
-func (m *APIKeyManager) saveAPIKeys() error {
-	file, err := os.Create(m.file)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	for encryptedKey, name := range m.apiKeys {
-		_, err := fmt.Fprintf(file, "%s %s\n", encryptedKey, name)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestManager 在临时目录中创建 APIKeyManager
+func newTestManager(t *testing.T) *APIKeyManager {
+	t.Helper()
+	m, err := NewAPIKeyManager(filepath.Join(t.TempDir(), "api_keys.json"), []byte("secret"))
+	if err != nil {
+		t.Fatalf("创建 APIKeyManager 失败: %v", err)
+	}
+	return m
+}
+
+func TestHashAPIKey(t *testing.T) {
+	m := newTestManager(t)
+	other := &APIKeyManager{secret: []byte("other")}
+
+	hashed := m.hashAPIKey("key-1")
+	if !strings.HasPrefix(hashed, hashPrefix) || len(hashed) != len(hashPrefix)+64 {
+		t.Fatalf("hashAPIKey = %q", hashed)
+	}
+	if m.hashAPIKey("key-1") != hashed {
+		t.Error("同一个 key 的 HMAC 不一致")
+	}
+	if m.hashAPIKey("key-2") == hashed || other.hashAPIKey("key-1") == hashed {
+		t.Error("不同的 key 或密钥得到相同的 HMAC")
+	}
+}
+
+func TestAPIKeyLookup(t *testing.T) {
+	m := newTestManager(t)
+	for _, name := range []string{"alice", "bob"} {
+		if err := m.AddAPIKey("key-"+name, name, KeyOptions{}); err != nil {
+			t.Fatalf("添加 API key 失败: %v", err)
+		}
+	}
+
+	for key, want := range map[string]string{"key-alice": "alice", "key-bob": "bob"} {
+		if !m.IsValidAPIKey(key) {
+			t.Errorf("IsValidAPIKey(%q) = false", key)
+		}
+		if name, ok := m.GetAPIKeyName(key); !ok || name != want {
+			t.Errorf("GetAPIKeyName(%q) = %q, %v", key, name, ok)
+		}
+	}
+	for _, key := range []string{"", "key-", "key-alicE", "key-alice "} {
+		if m.IsValidAPIKey(key) {
+			t.Errorf("IsValidAPIKey(%q) = true", key)
+		}
+	}
+
+	// 文件中只有 HMAC，没有 key 本身
+	data, err := os.ReadFile(m.GetFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("key-alice")) || !bytes.Contains(data, []byte(m.hashAPIKey("key-alice"))) {
+		t.Errorf("API keys 文件内容:\n%s", data)
+	}
+
+	if err := m.AddAPIKey("key-alice", "carol", KeyOptions{}); err == nil {
+		t.Error("重复的 key 被添加")
+	}
+}
+
+func TestLegacyKeysMigratedOnLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api_keys.json")
+	legacy := encryptLegacyKey("old-key")
+	content := `{"version":1,"keys":[{"id":"x","name":"old","hash":"` + legacy + `","legacy":true}]}`
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewAPIKeyManager(file, []byte("secret"))
+	if err != nil {
+		t.Fatalf("创建 APIKeyManager 失败: %v", err)
+	}
+	if !m.IsValidAPIKey("old-key") {
+		t.Error("旧格式的 key 无法认证")
+	}
+
+	// 加载后文件中不再有可还原的记录
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(legacy)) || !bytes.Contains(data, []byte(m.hashAPIKey("old-key"))) {
+		t.Errorf("旧记录没有被转换:\n%s", data)
+	}
+	if info, _ := m.GetAPIKeyInfo("old"); info.Legacy || info.ID == "x" {
+		t.Errorf("转换后的记录 = %+v", info)
+	}
+}
+
+func TestLegacyKeyAuthenticatesBeforeMigration(t *testing.T) {
+	m := newTestManager(t)
+	m.records["old"] = &keyRecord{ID: "x", Name: "old", Hash: encryptLegacyKey("old-key"), Legacy: true}
+
+	if name, ok := m.GetAPIKeyName("old-key"); !ok || name != "old" {
+		t.Errorf("GetAPIKeyName = %q, %v", name, ok)
+	}
+	if _, ok := m.GetAPIKeyName("old-kez"); ok {
+		t.Error("错误的 key 匹配了旧记录")
+	}
+}
+
+func TestMigrateLegacyKeys(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.AddAPIKey("new-key", "new", KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务运行期间有旧记录被写入文件
+	data, err := os.ReadFile(m.GetFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"id":"x","name":"old","hash":"` + encryptLegacyKey("old-key") + `","legacy":true},`
+	data = bytes.Replace(data, []byte(`"keys": [`), []byte(`"keys": [`+legacy), 1)
+	if err := os.WriteFile(m.GetFilePath(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := m.MigrateLegacyKeys()
+	if err != nil || migrated != 1 {
+		t.Fatalf("MigrateLegacyKeys = %d, %v", migrated, err)
+	}
+	if migrated, err := m.MigrateLegacyKeys(); err != nil || migrated != 0 {
+		t.Errorf("再次迁移 = %d, %v", migrated, err)
+	}
+	for _, key := range []string{"old-key", "new-key"} {
+		if !m.IsValidAPIKey(key) {
+			t.Errorf("迁移后 %q 无法认证", key)
+		}
+	}
+	if data, _ := os.ReadFile(m.GetFilePath()); bytes.Contains(data, []byte(`"legacy"`)) {
+		t.Errorf("迁移后文件中仍有旧记录:\n%s", data)
+	}
+}
+
+func TestLoadOrCreateSecret(t *testing.T) {
+	dir := t.TempDir()
+
+	// 不存在时生成并以 0600 权限保存
+	path := filepath.Join(dir, "api_keys.json.secret")
+	created, err := LoadOrCreateSecret(path)
+	if err != nil || len(created) != 64 {
+		t.Fatalf("LoadOrCreateSecret = %q, %v", created, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("密钥文件 = %v, %v", info, err)
+	}
+
+	// 再次加载得到同一个密钥
+	loaded, err := LoadOrCreateSecret(path)
+	if err != nil || !bytes.Equal(loaded, created) {
+		t.Errorf("再次加载 = %q, %v", loaded, err)
+	}
+
+	// 已有的密钥文件去掉首尾空白
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte("  my-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := LoadOrCreateSecret(existing); err != nil || string(secret) != "my-secret" {
+		t.Errorf("已有密钥 = %q, %v", secret, err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateSecret(empty); err == nil {
+		t.Error("空的密钥文件没有返回错误")
+	}
+}
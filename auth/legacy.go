@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// legacyEncryptionKey 是旧版本对 API key 做 XOR 的固定密钥
+//
+// XOR 是可逆的，任何能读取 API keys 文件的人都能还原出 key，
+// 这里只保留用于加载时转换旧记录，以及在转换写回之前认证。
+const legacyEncryptionKey = "clamav-api-secret"
+
+// encryptLegacyKey 按旧格式对 API key 做 XOR 并编码，用于比对尚未迁移的记录
+func encryptLegacyKey(apiKey string) string {
+	encrypted := make([]byte, len(apiKey))
+	for i := 0; i < len(apiKey); i++ {
+		encrypted[i] = apiKey[i] ^ legacyEncryptionKey[i%len(legacyEncryptionKey)]
+	}
+	return base64.StdEncoding.EncodeToString(encrypted)
+}
+
+// decryptLegacyKey 还原旧格式记录中的 API key，只在迁移时使用
+func decryptLegacyKey(encryptedKey string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encryptedKey)
+	if err != nil {
+		return "", err
+	}
+	decrypted := make([]byte, len(decoded))
+	for i := 0; i < len(decoded); i++ {
+		decrypted[i] = decoded[i] ^ legacyEncryptionKey[i%len(legacyEncryptionKey)]
+	}
+	return string(decrypted), nil
+}
+
+// isLegacyHash 判断记录中保存的是否为旧格式 XOR 加密的 key
+func isLegacyHash(stored string) bool {
+	return !strings.HasPrefix(stored, hashPrefix)
+}
+
+// legacyKeyID 为旧格式记录生成用于展示的 ID，不包含 key 本身的信息
+func legacyKeyID(encryptedKey string) string {
+	sum := sha256.Sum256([]byte(encryptedKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseTextStore 解析旧版本的文本格式 API keys 文件
+//
+// 每行为 "<key ID> hmac-sha256:<HMAC> <名称>" 或更早的 "<XOR 加密的 key> <名称>"，
+// 可选地以制表符分隔追加 URL 编码的属性。空行被忽略，格式错误的行返回带行号的错误。
+func parseTextStore(data []byte) (map[string]*keyRecord, error) {
+	records := make(map[string]*keyRecord)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		record, err := parseTextRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", n, err)
+		}
+		if _, exists := records[record.Name]; exists {
+			return nil, fmt.Errorf("第 %d 行: 重复的 API key 名称 '%s'", n, record.Name)
+		}
+		records[record.Name] = record
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// parseTextRecord 解析文本格式中的一行
+func parseTextRecord(line string) (*keyRecord, error) {
+	line, rawAttrs, _ := strings.Cut(line, "\t")
+	line = strings.TrimSpace(line)
+
+	attrs, err := url.ParseQuery(strings.TrimSpace(rawAttrs))
+	if err != nil {
+		return nil, fmt.Errorf("无法解析属性: %v", err)
+	}
+
+	record := &keyRecord{}
+	if parts := strings.SplitN(line, " ", 3); len(parts) == 3 && strings.HasPrefix(parts[1], hashPrefix) {
+		record.ID, record.Hash, record.Name = parts[0], parts[1], parts[2]
+	} else if parts := strings.SplitN(line, " ", 2); len(parts) == 2 && !strings.HasPrefix(parts[1], hashPrefix) {
+		// 更早的格式："<XOR 加密的 key> <名称>"
+		record.Hash, record.Name, record.Legacy = parts[0], parts[1], true
+		record.ID = legacyKeyID(record.Hash)
+	} else {
+		return nil, errors.New("格式应为 \"<key ID> hmac-sha256:<HMAC> <名称>\"")
+	}
+
+	record.Scopes = attrs["scope"]
+	record.Webhooks = attrs["webhook"]
+	record.CreatedAt = textTimeAttr(attrs, "created")
+	record.ExpiresAt = textTimeAttr(attrs, "expires")
+	record.LastUsedAt = textTimeAttr(attrs, "last_used")
+	if previous := attrs.Get("previous"); previous != "" {
+		record.Previous = &previousKey{Hash: previous, ExpiresAt: textTimeAttr(attrs, "previous_expires")}
+	}
+
+	return record, nil
+}
+
+// textTimeAttr 读取文本格式中 RFC 3339 格式的时间属性，不存在或格式错误时返回零值
+func textTimeAttr(attrs url.Values, name string) time.Time {
+	t, err := time.Parse(time.RFC3339, attrs.Get(name))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// MigrateLegacyKeys 将旧的 XOR 格式记录转换为 HMAC 记录，返回迁移的数量
+//
+// 迁移后 key 本身不变，客户端无需更换。加载文件时已自动迁移，这里用于立即处理加载后被写入文件的旧记录。
+func (m *APIKeyManager) MigrateLegacyKeys() (int, error) {
+	count := 0
+	err := m.update(func() error {
+		var err error
+		count, err = m.migrateLegacyRecords()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// hasLegacy 判断是否存在旧的 XOR 格式记录，调用方需持有读锁
+func (m *APIKeyManager) hasLegacy() bool {
+	for _, record := range m.records {
+		if record.Legacy || isLegacyHash(record.Hash) || (record.Previous != nil && isLegacyHash(record.Previous.Hash)) {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateLegacyRecords 将旧的 XOR 格式记录（包括轮换前的 key）转换为 HMAC 记录，返回转换的记录数量，调用方需持有写锁
+func (m *APIKeyManager) migrateLegacyRecords() (int, error) {
+	// 先计算所有新记录，全部成功后再替换，避免失败时内存中留下一半迁移的状态
+	migrated := make(map[string]*keyRecord)
+	for name, record := range m.records {
+		legacyPrevious := record.Previous != nil && isLegacyHash(record.Previous.Hash)
+		if !record.Legacy && !isLegacyHash(record.Hash) && !legacyPrevious {
+			continue
+		}
+
+		updated := *record
+		if record.Legacy || isLegacyHash(record.Hash) {
+			apiKey, err := decryptLegacyKey(record.Hash)
+			if err != nil {
+				return 0, fmt.Errorf("解析名称为 '%s' 的旧 API key 失败: %v", name, err)
+			}
+			id, err := newKeyID()
+			if err != nil {
+				return 0, fmt.Errorf("生成 key ID 失败: %v", err)
+			}
+			updated.ID, updated.Hash, updated.Legacy = id, m.hashAPIKey(apiKey), false
+		}
+		if legacyPrevious {
+			apiKey, err := decryptLegacyKey(record.Previous.Hash)
+			if err != nil {
+				return 0, fmt.Errorf("解析名称为 '%s' 的轮换前 API key 失败: %v", name, err)
+			}
+			updated.Previous = &previousKey{Hash: m.hashAPIKey(apiKey), ExpiresAt: record.Previous.ExpiresAt}
+		}
+		migrated[name] = &updated
+	}
+
+	for name, record := range migrated {
+		m.records[name] = record
+	}
+	if len(migrated) > 0 {
+		slog.Info("已将旧格式的 API key 转换为 HMAC 存储", "file", m.file, "count", len(migrated))
+	}
+	return len(migrated), nil
+}
+
+// backupTextStore 将旧的文本格式文件备份为 dst，调用方需持有写锁和文件锁
+//
+// 旧的 XOR 记录可以还原出 key，备份中替换为 m.records 中已转换的 HMAC 记录，其他行原样保留。
+func (m *APIKeyManager) backupTextStore(dst string) error {
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		return err
+	}
+
+	var backup bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		record, err := parseTextRecord(line)
+		if err == nil && (record.Legacy || (record.Previous != nil && isLegacyHash(record.Previous.Hash))) {
+			current, exists := m.records[record.Name]
+			if !exists || isLegacyHash(current.Hash) {
+				continue // 不能写入可还原的记录
+			}
+			line = formatTextRecord(line, current)
+		}
+		backup.WriteString(line)
+		backup.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dst, backup.Bytes())
+}
+
+// formatTextRecord 用 record 的 key ID 和 HMAC 替换文本格式的一行，保留其他属性
+func formatTextRecord(line string, record *keyRecord) string {
+	formatted := record.ID + " " + record.Hash + " " + record.Name
+
+	_, rawAttrs, _ := strings.Cut(line, "\t")
+	attrs, _ := url.ParseQuery(strings.TrimSpace(rawAttrs))
+	attrs.Del("previous")
+	attrs.Del("previous_expires")
+	if previous := record.Previous; previous != nil && !isLegacyHash(previous.Hash) {
+		attrs.Set("previous", previous.Hash)
+		attrs.Set("previous_expires", previous.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if len(attrs) > 0 {
+		formatted += "\t" + attrs.Encode()
+	}
+	return formatted
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// KeyOptions 是创建 API key 时的可选设置
+type KeyOptions struct {
+	Scopes    []string  // 权限范围，为空时使用 DefaultScopes
+	ExpiresAt time.Time // 过期时间，零值表示永不过期
+	Webhooks  []string  // 发现威胁时回调的地址
+}
+
+// apply 将创建时间和选项写入记录
+func (o KeyOptions) apply(record *keyRecord, now time.Time) {
+	record.CreatedAt = now.UTC().Truncate(time.Second)
+	if !o.ExpiresAt.IsZero() {
+		record.ExpiresAt = o.ExpiresAt.UTC()
+	}
+	record.Scopes = append([]string(nil), o.Scopes...)
+	record.Webhooks = append([]string(nil), o.Webhooks...)
+}
+
+// KeyInfo 是展示用的 API key 信息，不包含 key 或其 HMAC
+type KeyInfo struct {
+	Name              string    `json:"name"`
+	ID                string    `json:"id"`
+	Scopes            []string  `json:"scopes"`
+	Legacy            bool      `json:"legacy,omitempty"`           // 是否为需要迁移的旧格式
+	CreatedAt         time.Time `json:"createdAt,omitzero"`         // 旧版本创建的 key 为零值
+	ExpiresAt         time.Time `json:"expiresAt,omitzero"`         // 零值表示永不过期
+	LastUsedAt        time.Time `json:"lastUsedAt,omitzero"`        // 零值表示从未使用
+	PreviousExpiresAt time.Time `json:"previousExpiresAt,omitzero"` // 轮换前的 key 在此之前仍然有效
+}
+
+// Expired 判断 key 是否已过期
+func (k KeyInfo) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// Stale 判断 key 是否长期未使用：最近使用（从未使用时为创建时间）早于 now - after
+func (k KeyInfo) Stale(now time.Time, after time.Duration) bool {
+	last := k.LastUsedAt
+	if last.IsZero() {
+		last = k.CreatedAt
+	}
+	return last.IsZero() || now.Sub(last) > after
+}
+
+// ListAPIKeys 返回所有 API key 的信息，按名称排序
+func (m *APIKeyManager) ListAPIKeys() []KeyInfo {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	keys := make([]KeyInfo, 0, len(m.records))
+	for _, record := range m.records {
+		keys = append(keys, m.keyInfo(record))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+
+	return keys
+}
+
+// GetAPIKeyInfo 返回指定 API key 的信息
+func (m *APIKeyManager) GetAPIKeyInfo(name string) (KeyInfo, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	record, exists := m.records[name]
+	if !exists {
+		return KeyInfo{}, false
+	}
+	return m.keyInfo(record), true
+}
+
+// keyInfo 将记录转换为展示信息，调用方需持有读锁
+func (m *APIKeyManager) keyInfo(record *keyRecord) KeyInfo {
+	info := KeyInfo{
+		Name:       record.Name,
+		ID:         record.ID,
+		Scopes:     record.scopes(),
+		Legacy:     record.Legacy,
+		CreatedAt:  record.CreatedAt,
+		ExpiresAt:  record.ExpiresAt,
+		LastUsedAt: record.LastUsedAt,
+	}
+	if previous := record.Previous; previous != nil && time.Now().Before(previous.ExpiresAt) {
+		info.PreviousExpiresAt = previous.ExpiresAt
+	}
+
+	m.usedMutex.Lock()
+	if used, exists := m.lastUsed[record.Name]; exists && used.After(info.LastUsedAt) {
+		info.LastUsedAt = used
+	}
+	m.usedMutex.Unlock()
+
+	return info
+}
+
+// RotateAPIKey 为指定名称设置新的 API key，旧 key 在 grace 时长内仍然有效
+//
+// 再次轮换时，上一次轮换保留的旧 key 立即失效。
+func (m *APIKeyManager) RotateAPIKey(name, apiKey string, grace time.Duration) error {
+	return m.update(func() error {
+		record, exists := m.records[name]
+		if !exists {
+			return fmt.Errorf("%w: '%s'", ErrKeyNotFound, name)
+		}
+		if m.lookup(apiKey) != nil {
+			return fmt.Errorf("API key 已存在")
+		}
+
+		id, err := newKeyID()
+		if err != nil {
+			return fmt.Errorf("生成 key ID 失败: %v", err)
+		}
+
+		now := time.Now()
+		record.Previous = nil
+		if grace > 0 && !record.expired(now) {
+			record.Previous = &previousKey{Hash: record.Hash, ExpiresAt: now.Add(grace).UTC()}
+		}
+
+		record.ID = id
+		record.Hash = m.hashAPIKey(apiKey)
+		record.Legacy = false
+		return nil
+	})
+}
+
+// touch 在内存中记录 API key 的最近使用时间
+func (m *APIKeyManager) touch(name string) {
+	m.usedMutex.Lock()
+	m.lastUsed[name] = time.Now()
+	m.usedMutex.Unlock()
+}
+
+// FlushLastUsed 将内存中的最近使用时间写入文件
+func (m *APIKeyManager) FlushLastUsed() error {
+	m.usedMutex.Lock()
+	pending := m.lastUsed
+	m.lastUsed = make(map[string]time.Time)
+	m.usedMutex.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := m.update(func() error {
+		for name, used := range pending {
+			if record, exists := m.records[name]; exists && used.After(record.LastUsedAt) {
+				record.LastUsedAt = used.UTC().Truncate(time.Second)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		m.restoreLastUsed(pending)
+		return err
+	}
+	return nil
+}
+
+// restoreLastUsed 写入失败时放回未保存的最近使用时间
+func (m *APIKeyManager) restoreLastUsed(pending map[string]time.Time) {
+	m.usedMutex.Lock()
+	defer m.usedMutex.Unlock()
+	for name, used := range pending {
+		if used.After(m.lastUsed[name]) {
+			m.lastUsed[name] = used
+		}
+	}
+}
+
+// StartFlusher 每隔 interval 将最近使用时间写入文件，直到调用 Close
+func (m *APIKeyManager) StartFlusher(interval time.Duration) {
+	if interval <= 0 || m.stop != nil {
+		return
+	}
+
+	m.stop = make(chan struct{})
+	m.stopped = make(chan struct{})
+
+	go func() {
+		defer close(m.stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				if err := m.FlushLastUsed(); err != nil {
+					slog.Error("保存 API key 最近使用时间失败", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Close 停止定期写入，并写入尚未保存的最近使用时间
+func (m *APIKeyManager) Close() error {
+	if m.stop != nil {
+		close(m.stop)
+		<-m.stopped
+		m.stop = nil
+	}
+	return m.FlushLastUsed()
+}
+
+// expired 判断记录是否已过期
+func (r *keyRecord) expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
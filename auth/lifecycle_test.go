@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRotateAPIKey(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.AddAPIKey("key-1", "ci", KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := m.GetAPIKeyInfo("ci")
+
+	if err := m.RotateAPIKey("ci", "key-2", 100*time.Millisecond); err != nil {
+		t.Fatalf("轮换 API key 失败: %v", err)
+	}
+	info, _ := m.GetAPIKeyInfo("ci")
+	if info.ID == before.ID || info.PreviousExpiresAt.IsZero() {
+		t.Errorf("轮换后的信息 = %+v", info)
+	}
+
+	// 宽限期内新旧 key 都有效
+	for _, key := range []string{"key-1", "key-2"} {
+		if name, ok := m.GetAPIKeyName(key); !ok || name != "ci" {
+			t.Errorf("宽限期内 %q 认证结果 = %q, %v", key, name, ok)
+		}
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if m.IsValidAPIKey("key-1") {
+		t.Error("宽限期结束后旧 key 仍然有效")
+	}
+	if !m.IsValidAPIKey("key-2") {
+		t.Error("宽限期结束后新 key 无效")
+	}
+	if info, _ := m.GetAPIKeyInfo("ci"); !info.PreviousExpiresAt.IsZero() {
+		t.Errorf("宽限期结束后仍显示旧 key: %+v", info)
+	}
+}
+
+func TestRotateAPIKeyTwice(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.AddAPIKey("key-1", "ci", KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RotateAPIKey("ci", "key-2", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// 再次轮换时上一次保留的旧 key 立即失效
+	if err := m.RotateAPIKey("ci", "key-3", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if m.IsValidAPIKey("key-1") || !m.IsValidAPIKey("key-2") || !m.IsValidAPIKey("key-3") {
+		t.Error("再次轮换后的认证结果不正确")
+	}
+
+	// 不保留宽限期时旧 key 立即失效
+	if err := m.RotateAPIKey("ci", "key-4", 0); err != nil {
+		t.Fatal(err)
+	}
+	if m.IsValidAPIKey("key-3") || !m.IsValidAPIKey("key-4") {
+		t.Error("grace 为 0 时旧 key 仍然有效")
+	}
+
+	if err := m.RotateAPIKey("missing", "key-5", time.Hour); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("轮换不存在的 key 返回 %v", err)
+	}
+	if err := m.RotateAPIKey("ci", "key-4", time.Hour); err == nil {
+		t.Error("轮换为已存在的 key 没有返回错误")
+	}
+}
+
+func TestExpiredAPIKey(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.AddAPIKey("key-old", "old", KeyOptions{ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddAPIKey("key-new", "new", KeyOptions{ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.IsValidAPIKey("key-old") {
+		t.Error("已过期的 key 仍然有效")
+	}
+	if _, ok := m.GetAPIKeyName("key-old"); ok {
+		t.Error("GetAPIKeyName 返回了已过期的 key")
+	}
+	if !m.IsValidAPIKey("key-new") {
+		t.Error("未过期的 key 无效")
+	}
+
+	info, _ := m.GetAPIKeyInfo("old")
+	if !info.Expired(time.Now()) {
+		t.Errorf("KeyInfo.Expired = false: %+v", info)
+	}
+
+	// 已过期的 key 轮换时不保留宽限期
+	if err := m.RotateAPIKey("old", "key-rotated", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if m.IsValidAPIKey("key-old") {
+		t.Error("轮换后已过期的旧 key 变为有效")
+	}
+}
+
+func TestFlushLastUsed(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.AddAPIKey("key-1", "ci", KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有使用记录时不写文件
+	if err := m.FlushLastUsed(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	if !m.IsValidAPIKey("key-1") {
+		t.Fatal("key 无效")
+	}
+	// 写入文件之前最近使用时间已在列表中显示
+	if info, _ := m.GetAPIKeyInfo("ci"); info.LastUsedAt.Before(start) {
+		t.Errorf("写入前 LastUsedAt = %v", info.LastUsedAt)
+	}
+
+	other, err := NewAPIKeyManager(m.GetFilePath(), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := other.GetAPIKeyInfo("ci"); !info.LastUsedAt.IsZero() {
+		t.Errorf("写入前文件中的 LastUsedAt = %v", info.LastUsedAt)
+	}
+
+	if err := m.FlushLastUsed(); err != nil {
+		t.Fatalf("写入最近使用时间失败: %v", err)
+	}
+	if err := other.LoadAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := other.GetAPIKeyInfo("ci"); info.LastUsedAt.Before(start) {
+		t.Errorf("写入后文件中的 LastUsedAt = %v", info.LastUsedAt)
+	}
+
+	// Close 写入尚未保存的使用时间
+	m.StartFlusher(time.Hour)
+	m.touch("ci")
+	used := m.lastUsed["ci"]
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.LoadAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := other.GetAPIKeyInfo("ci"); !info.LastUsedAt.Equal(used.UTC().Truncate(time.Second)) {
+		t.Errorf("Close 后文件中的 LastUsedAt = %v，期望 %v", info.LastUsedAt, used)
+	}
+}
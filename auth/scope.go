@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+// API key 的权限范围
+const (
+	ScopeScan        = "scan"         // 上传文件扫描、查询任务和版本等只读接口
+	ScopeScanPath    = "scan:path"    // 扫描服务器上的文件路径
+	ScopeAdminReload = "admin:reload" // 重新加载病毒数据库
+	ScopeAdminKeys   = "admin:keys"   // 管理 API key
+)
+
+// Scopes 是所有可用的权限范围
+var Scopes = []string{ScopeScan, ScopeScanPath, ScopeAdminReload, ScopeAdminKeys}
+
+// DefaultScopes 是未指定权限范围的 API key（包括旧版本创建的 key）拥有的权限
+var DefaultScopes = []string{ScopeScan}
+
+// ValidateScopes 检查权限范围是否都是已知的
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("未知的权限范围 '%s'，可用的权限范围: %v", scope, Scopes)
+		}
+	}
+	return nil
+}
+
+// GetScopes 返回指定 API key 的权限范围
+func (m *APIKeyManager) GetScopes(name string) []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	record, exists := m.records[name]
+	if !exists {
+		return nil
+	}
+	return record.scopes()
+}
+
+// HasScope 检查指定 API key 是否拥有权限范围，scope 为空时任何有效的 key 都满足
+func (m *APIKeyManager) HasScope(name, scope string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	record, exists := m.records[name]
+	if !exists {
+		return false
+	}
+	return scope == "" || slices.Contains(record.scopes(), scope)
+}
+
+// SetScopes 设置指定 API key 的权限范围，scopes 为空时恢复为 DefaultScopes
+func (m *APIKeyManager) SetScopes(name string, scopes []string) error {
+	if err := ValidateScopes(scopes); err != nil {
+		return err
+	}
+
+	return m.update(func() error {
+		record, exists := m.records[name]
+		if !exists {
+			return fmt.Errorf("%w: '%s'", ErrKeyNotFound, name)
+		}
+		record.Scopes = append([]string(nil), scopes...)
+		return nil
+	})
+}
+
+// scopes 返回记录的权限范围，未设置时返回 DefaultScopes
+func (r *keyRecord) scopes() []string {
+	if len(r.Scopes) > 0 {
+		return append([]string(nil), r.Scopes...)
+	}
+	return append([]string(nil), DefaultScopes...)
+}
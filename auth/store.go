@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// keyStoreVersion 是当前 API keys 文件格式的版本
+const keyStoreVersion = 1
+
+// keyStore 是 API keys 文件的内容
+type keyStore struct {
+	Version int          `json:"version"`
+	Keys    []*keyRecord `json:"keys"`
+}
+
+// checkAndReload 在文件被修改或替换后重新加载 API keys，调用方需持有写锁
+//
+// 文件格式错误时返回错误并保留已加载的 API keys。
+func (m *APIKeyManager) checkAndReload() error {
+	fileInfo, err := os.Stat(m.file)
+	if err != nil {
+		return err
+	}
+
+	if m.fileInfo != nil && os.SameFile(fileInfo, m.fileInfo) &&
+		fileInfo.ModTime().Equal(m.fileInfo.ModTime()) && fileInfo.Size() == m.fileInfo.Size() {
+		return nil // 文件未被修改，无需重新加载
+	}
+
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		return err
+	}
+
+	records, text, err := decodeKeyStore(data)
+	if err != nil {
+		return fmt.Errorf("解析 %s 失败: %v", m.file, err)
+	}
+
+	m.records = records
+	m.fileInfo = fileInfo
+	m.text = text
+
+	return nil
+}
+
+// decodeKeyStore 解析 API keys 文件，text 表示文件是旧的文本格式
+func decodeKeyStore(data []byte) (records map[string]*keyRecord, text bool, err error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] != '{' {
+		records, err = parseTextStore(data)
+		return records, true, err
+	}
+	if len(trimmed) == 0 {
+		// 旧版本创建的空文件
+		return make(map[string]*keyRecord), true, nil
+	}
+
+	var store keyStore
+	if err := json.Unmarshal(trimmed, &store); err != nil {
+		return nil, false, err
+	}
+	if store.Version < 1 || store.Version > keyStoreVersion {
+		return nil, false, fmt.Errorf("不支持的文件版本 %d", store.Version)
+	}
+
+	records = make(map[string]*keyRecord, len(store.Keys))
+	for i, record := range store.Keys {
+		if record == nil || record.Name == "" || record.Hash == "" {
+			return nil, false, fmt.Errorf("第 %d 个 key 缺少名称或 hash", i+1)
+		}
+		if _, exists := records[record.Name]; exists {
+			return nil, false, fmt.Errorf("重复的 API key 名称 '%s'", record.Name)
+		}
+		records[record.Name] = record
+	}
+	return records, false, nil
+}
+
+// update 在文件锁内重新加载 API keys，执行 fn 修改记录后原子地写回文件
+//
+// 写回前将旧的 XOR 记录转换为 HMAC，文件中不会留下可还原的 key。
+// fn 返回错误时不写入文件，内存中的修改在下次加载时丢弃。
+func (m *APIKeyManager) update(fn func() error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	unlock, err := lockFile(m.file + ".lock")
+	if err != nil {
+		return fmt.Errorf("锁定 API keys 文件失败: %v", err)
+	}
+	defer unlock()
+
+	if err := m.checkAndReload(); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("重新加载 API keys 失败: %v", err)
+	}
+
+	if err := fn(); err != nil {
+		m.fileInfo = nil
+		return err
+	}
+	if _, err := m.migrateLegacyRecords(); err != nil {
+		m.fileInfo = nil
+		return err
+	}
+	if err := m.saveAPIKeys(); err != nil {
+		m.fileInfo = nil
+		return err
+	}
+	return nil
+}
+
+// saveAPIKeys 按名称排序保存 API keys，调用方需持有写锁和文件锁
+func (m *APIKeyManager) saveAPIKeys() error {
+	store := keyStore{Version: keyStoreVersion, Keys: make([]*keyRecord, 0, len(m.records))}
+	for _, record := range m.records {
+		store.Keys = append(store.Keys, record)
+	}
+	sort.Slice(store.Keys, func(i, j int) bool { return store.Keys[i].Name < store.Keys[j].Name })
+
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	text := m.text
+	if text {
+		if err := m.backupTextStore(m.file + ".bak"); err != nil {
+			return fmt.Errorf("备份旧格式的 API keys 文件失败: %v", err)
+		}
+	}
+
+	if err := writeFileAtomic(m.file, append(data, '\n')); err != nil {
+		return fmt.Errorf("保存 API keys 失败: %v", err)
+	}
+
+	fileInfo, err := os.Stat(m.file)
+	if err != nil {
+		return err
+	}
+	m.fileInfo = fileInfo
+	m.text = false
+	if text {
+		slog.Info("已将 API key 文件转换为 JSON 格式", "file", m.file, "backup", m.file+".bak")
+	}
+	return nil
+}
+
+// writeFileAtomic 先写入同目录下的临时文件并 fsync，再 rename 替换目标文件，
+// 写入过程中崩溃不会损坏原文件
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // rename 成功后文件已不存在，删除失败可以忽略
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
@@ -0,0 +1,13 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package auth
+
+// lockFile 在不支持 flock 的平台上不加锁，CLI 和服务不应同时修改 API keys 文件
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
+
+// syncDir 在不支持对目录 fsync 的平台上不做任何操作
+func syncDir(dir string) error {
+	return nil
+}
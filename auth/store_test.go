@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTextStoreConverted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api_keys.txt")
+	hasher := &APIKeyManager{secret: []byte("secret")}
+	legacy := encryptLegacyKey("old-key")
+	text := "0a1b2c3d " + hasher.hashAPIKey("new-key") + " new\tscope=scan&scope=admin%3Akeys\n" +
+		"\n" +
+		legacy + " old\twebhook=https%3A%2F%2Fexample.com%2Fhook\n"
+	if err := os.WriteFile(file, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewAPIKeyManager(file, []byte("secret"))
+	if err != nil {
+		t.Fatalf("创建 APIKeyManager 失败: %v", err)
+	}
+	for _, key := range []string{"new-key", "old-key"} {
+		if !m.IsValidAPIKey(key) {
+			t.Errorf("转换后 %q 无法认证", key)
+		}
+	}
+	if info, _ := m.GetAPIKeyInfo("new"); info.ID != "0a1b2c3d" || len(info.Scopes) != 2 {
+		t.Errorf("转换后的记录 = %+v", info)
+	}
+	if webhooks := m.GetWebhooks("old"); len(webhooks) != 1 || webhooks[0] != "https://example.com/hook" {
+		t.Errorf("转换后的 webhook = %v", webhooks)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("{")) || bytes.Contains(data, []byte(legacy)) {
+		t.Errorf("转换后的文件:\n%s", data)
+	}
+
+	// 备份保留文本格式和属性，但旧记录已替换为 HMAC
+	backup, err := os.ReadFile(file + ".bak")
+	if err != nil {
+		t.Fatalf("读取备份失败: %v", err)
+	}
+	if bytes.Contains(backup, []byte(legacy)) || !bytes.Contains(backup, []byte(m.hashAPIKey("old-key")+" old\twebhook=")) {
+		t.Errorf("备份中仍有可还原的记录:\n%s", backup)
+	}
+	records, err := parseTextStore(backup)
+	if err != nil || len(records) != 2 || records["old"].Legacy || records["new"].Hash != hasher.hashAPIKey("new-key") {
+		t.Errorf("解析备份 = %v, %v", records, err)
+	}
+}
+
+func TestDecodeKeyStoreErrors(t *testing.T) {
+	tests := map[string]string{
+		"格式错误的 JSON": `{"version":1,"keys":[`,
+		"未知版本":       `{"version":2,"keys":[]}`,
+		"缺少版本":       `{"keys":[]}`,
+		"缺少 hash":    `{"version":1,"keys":[{"name":"a"}]}`,
+		"重复名称":       `{"version":1,"keys":[{"name":"a","hash":"x"},{"name":"a","hash":"y"}]}`,
+		"文本格式错误":     "only-one-field\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "api_keys.json")
+			if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewAPIKeyManager(file, []byte("secret")); err == nil {
+				t.Error("没有返回错误")
+			}
+			// 出错时不能改写原文件
+			if data, _ := os.ReadFile(file); string(data) != content {
+				t.Errorf("文件被修改为:\n%s", data)
+			}
+		})
+	}
+
+	if _, _, err := decodeKeyStore([]byte(`{"version":2,"keys":[]}`)); err == nil || !strings.Contains(err.Error(), "不支持的文件版本 2") {
+		t.Errorf("未知版本返回 %v", err)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api_keys.json")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 写入前打开的文件仍然指向旧内容，说明文件是被整体替换而不是原地改写
+	old, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatalf("writeFileAtomic 失败: %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("文件内容 = %q", data)
+	}
+	buf := make([]byte, 8)
+	if n, _ := old.Read(buf); string(buf[:n]) != "old" {
+		t.Errorf("旧文件内容 = %q", buf[:n])
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("文件权限 = %v, %v", info, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("留下了临时文件: %v", entries)
+	}
+}
+
+func TestReloadAfterExternalChange(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api_keys.json")
+	server, err := NewAPIKeyManager(file, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := NewAPIKeyManager(file, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 另一个进程（命令行）添加和删除 key
+	if err := cli.AddAPIKey("key-1", "one", KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !server.IsValidAPIKey("key-1") {
+		t.Error("外部添加的 key 没有被重新加载")
+	}
+	if err := cli.RemoveAPIKey("one"); err != nil {
+		t.Fatal(err)
+	}
+	if server.IsValidAPIKey("key-1") {
+		t.Error("外部删除的 key 仍然有效")
+	}
+
+	// 两边交替修改时，各自的修改都不会丢失
+	if err := server.AddAPIKey("key-2", "two", KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.AddAPIKey("key-3", "three", KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !server.IsValidAPIKey("key-2") || !server.IsValidAPIKey("key-3") || len(server.ListAPIKeys()) != 2 {
+		t.Errorf("重新加载后 ListAPIKeys = %v", server.ListAPIKeys())
+	}
+
+	// 文件被改坏时保留已加载的 key
+	if err := os.WriteFile(file, []byte("{broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.LoadAPIKeys(); err == nil {
+		t.Error("格式错误的文件没有返回错误")
+	}
+	if _, ok := server.GetAPIKeyName("key-2"); !ok {
+		t.Error("文件格式错误后丢失了已加载的 key")
+	}
+}
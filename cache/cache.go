@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/clamav"
+)
+
+// Cache 是有容量上限和过期时间的 LRU 扫描结论缓存
+type Cache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // 最近使用的在前
+}
+
+// entry 是一条缓存记录
+type entry struct {
+	key     string
+	verdict clamav.Verdict
+	expires time.Time
+}
+
+// New 创建缓存，size 为最多保存的条数，ttl 为 0 时不过期
+func New(size int, ttl time.Duration) *Cache {
+	return &Cache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get 返回缓存的扫描结论，过期的记录会被删除
+func (c *Cache) Get(key string) (clamav.Verdict, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return clamav.Verdict{}, false
+	}
+
+	e := element.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(element)
+		return clamav.Verdict{}, false
+	}
+
+	c.order.MoveToFront(element)
+	return e.verdict, true
+}
+
+// Put 保存扫描结论，超出容量时淘汰最久未使用的记录
+func (c *Cache) Put(key string, verdict clamav.Verdict) {
+	if c.size <= 0 {
+		return
+	}
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, exists := c.entries[key]; exists {
+		e := element.Value.(*entry)
+		e.verdict = verdict
+		e.expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&entry{key: key, verdict: verdict, expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Purge 清空缓存
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Len 返回缓存的记录数
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove 删除一条记录，调用方需持有锁
+func (c *Cache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/clamav"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(2, 0)
+	c.Put("a", clamav.Verdict{Path: "a"})
+	c.Put("b", clamav.Verdict{Path: "b"})
+
+	// 访问 a 后，b 成为最久未使用的记录
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a 应在缓存中")
+	}
+	c.Put("c", clamav.Verdict{Path: "c"})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b 应被淘汰")
+	}
+	for _, key := range []string{"a", "c"} {
+		if verdict, ok := c.Get(key); !ok || verdict.Path != key {
+			t.Errorf("Get(%q) = %+v, %v", key, verdict, ok)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d，期望 2", c.Len())
+	}
+
+	// 更新已有记录不增加条数
+	c.Put("a", clamav.Verdict{Path: "a2"})
+	if verdict, _ := c.Get("a"); verdict.Path != "a2" || c.Len() != 2 {
+		t.Errorf("更新后 Get = %+v, Len = %d", verdict, c.Len())
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	c := New(10, 20*time.Millisecond)
+	c.Put("a", clamav.Verdict{Path: "a"})
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a 应在缓存中")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("过期的记录仍被返回")
+	}
+	if c.Len() != 0 {
+		t.Errorf("过期的记录没有被删除，Len = %d", c.Len())
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := New(0, time.Hour)
+	c.Put("a", clamav.Verdict{Path: "a"})
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Error("容量为0时不应缓存")
+	}
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/clamav"
+	"github.com/SmallGaoX/clamd-api/metrics"
+)
+
+// Options 扫描结论缓存配置
+type Options struct {
+	Size       int           // 最多缓存的结论数，0 表示不缓存
+	TTL        time.Duration // 结论的过期时间，0 表示不过期
+	VersionTTL time.Duration // 重新查询病毒库版本的间隔
+}
+
+// DefaultOptions 返回默认缓存配置
+func DefaultOptions() Options {
+	return Options{
+		Size:       10000,
+		TTL:        time.Hour,
+		VersionTTL: time.Minute,
+	}
+}
+
+// Result 是带缓存信息的扫描结果
+type Result struct {
+	Verdict clamav.Verdict
+	SHA256  string // 内容的十六进制 SHA-256
+	Cached  bool   // 结论是否来自缓存
+}
+
+// Scanner 按内容 SHA-256 和病毒库版本缓存流扫描结论，相同内容不再重复发送给 clamd
+type Scanner struct {
+	scanner clamav.ContextScanner
+	cache   *Cache
+	opts    Options
+
+	mu         sync.Mutex
+	version    string // 最近一次查询到的病毒库版本
+	checkedAt  time.Time
+	refreshing bool // 是否有请求正在查询病毒库版本
+}
+
+// NewScanner 创建带缓存的扫描器
+func NewScanner(scanner clamav.ContextScanner, opts Options) *Scanner {
+	if opts.VersionTTL <= 0 {
+		opts.VersionTTL = DefaultOptions().VersionTTL
+	}
+	return &Scanner{
+		scanner: scanner,
+		cache:   New(opts.Size, opts.TTL),
+		opts:    opts,
+	}
+}
+
+// ScanStreamContext 扫描数据流并返回内容的 SHA-256；缓存命中时不访问 clamd
+//
+// 可 Seek 的数据流会先计算哈希再回到原位置扫描，并原样交给底层扫描器，使扫描失败时可以换一个后端重试；
+// 否则在发送给 clamd 的同时计算哈希。扫描失败的结论不会被缓存。
+func (s *Scanner) ScanStreamContext(ctx context.Context, reader io.Reader) (Result, error) {
+	version, err := s.dbVersion(ctx)
+	if err != nil {
+		// 无法确定病毒库版本时只扫描不缓存
+		slog.Warn("获取病毒库版本失败，跳过扫描缓存", "error", err)
+	}
+
+	var sum string
+	var h hash.Hash
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		sum, err = hashSeeker(seeker)
+		if err != nil {
+			return Result{}, fmt.Errorf("计算文件哈希失败: %v", err)
+		}
+		if version != "" {
+			if verdict, ok := s.cache.Get(cacheKey(sum, version)); ok {
+				metrics.CacheHits.Inc()
+				return Result{Verdict: verdict, SHA256: sum, Cached: true}, nil
+			}
+		}
+	} else {
+		h = sha256.New()
+		reader = io.TeeReader(reader, h)
+	}
+
+	done := metrics.TrackScan()
+	verdict, err := s.scanner.ScanStreamContext(ctx, reader)
+	if err != nil {
+		done(string(clamav.StatusError))
+		return Result{}, err
+	}
+	done(string(verdict.Status))
+	if h != nil {
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if version != "" && verdict.Status != clamav.StatusError {
+		s.cache.Put(cacheKey(sum, version), verdict)
+	}
+
+	return Result{Verdict: verdict, SHA256: sum}, nil
+}
+
+// Purge 清空缓存，并在下次扫描时重新查询病毒库版本
+func (s *Scanner) Purge() {
+	s.mu.Lock()
+	s.version = ""
+	s.checkedAt = time.Time{}
+	s.mu.Unlock()
+
+	s.cache.Purge()
+}
+
+// Len 返回缓存的结论数
+func (s *Scanner) Len() int {
+	return s.cache.Len()
+}
+
+// dbVersion 返回当前病毒库版本
+//
+// 查询版本时不持有锁；已有版本时只由一个请求重新查询，其他请求继续使用旧版本。
+// 版本变化时不清空缓存：缓存键包含版本，旧版本的结论不会再被命中，由 LRU 和过期时间淘汰。
+// 多个后端病毒库版本不一致时，各版本的结论因此可以同时保留。
+func (s *Scanner) dbVersion(ctx context.Context) (string, error) {
+	if s.opts.Size <= 0 {
+		return "", nil
+	}
+
+	s.mu.Lock()
+	current := s.version
+	if current != "" && (s.refreshing || time.Since(s.checkedAt) < s.opts.VersionTTL) {
+		s.mu.Unlock()
+		return current, nil
+	}
+	s.refreshing = true
+	s.mu.Unlock()
+
+	version, err := s.scanner.GetVersionContext(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshing = false
+	if err != nil {
+		return "", err
+	}
+	if s.version != "" && version != s.version {
+		slog.Info("病毒库版本已变化", "from", s.version, "to", version)
+	}
+	s.version = version
+	s.checkedAt = time.Now()
+
+	return version, nil
+}
+
+// hashSeeker 计算从当前位置到末尾的 SHA-256，然后回到原位置
+func hashSeeker(seeker io.ReadSeeker) (string, error) {
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, seeker); err != nil {
+		return "", err
+	}
+
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheKey 由内容哈希和病毒库版本组成缓存键
+func cacheKey(sum, version string) string {
+	return sum + "|" + version
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/clamav"
+)
+
+// stubScanner 记录扫描和版本查询次数，病毒库版本可以随时修改
+type stubScanner struct {
+	clamav.ContextScanner
+
+	mu       sync.Mutex
+	version  string
+	versions int  // GetVersionContext 调用次数
+	scans    int  // ScanStreamContext 调用次数
+	seekable bool // 最近一次扫描收到的数据流是否可以 Seek
+}
+
+func (s *stubScanner) GetVersionContext(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions++
+	if s.version == "" {
+		return "", errors.New("clamd 不可用")
+	}
+	return s.version, nil
+}
+
+func (s *stubScanner) ScanStreamContext(ctx context.Context, reader io.Reader) (clamav.Verdict, error) {
+	_, seekable := reader.(io.Seeker)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return clamav.Verdict{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scans++
+	s.seekable = seekable
+	return clamav.Verdict{Path: "stream", Status: clamav.StatusClean}, nil
+}
+
+func (s *stubScanner) setVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+func (s *stubScanner) counts() (scans, versions int, seekable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scans, s.versions, s.seekable
+}
+
+func TestScannerCachesByVersion(t *testing.T) {
+	stub := &stubScanner{version: "ClamAV 1.3.1/27400"}
+	scanner := NewScanner(stub, Options{Size: 10, TTL: time.Hour, VersionTTL: time.Nanosecond})
+	ctx := context.Background()
+
+	scan := func(wantCached bool) {
+		t.Helper()
+		result, err := scanner.ScanStreamContext(ctx, strings.NewReader("hello"))
+		if err != nil {
+			t.Fatalf("扫描失败: %v", err)
+		}
+		if result.Cached != wantCached || result.SHA256 == "" {
+			t.Errorf("Result = %+v，期望 Cached = %v", result, wantCached)
+		}
+	}
+
+	scan(false)
+	scan(true)
+
+	// 病毒库更新后旧结论不再命中
+	stub.setVersion("ClamAV 1.3.1/27401")
+	scan(false)
+	scan(true)
+
+	// 另一个后端仍在使用旧版本时，旧版本的结论依然可用
+	stub.setVersion("ClamAV 1.3.1/27400")
+	scan(true)
+	if scans, _, _ := stub.counts(); scans != 2 {
+		t.Errorf("clamd 收到 %d 次扫描，期望 2 次", scans)
+	}
+}
+
+func TestScannerPassesSeekerThrough(t *testing.T) {
+	ctx := context.Background()
+	for name, opts := range map[string]Options{
+		"缓存":     {Size: 10},
+		"关闭缓存":   {Size: 0},
+		"无法获取版本": {Size: 10},
+	} {
+		stub := &stubScanner{version: "ClamAV 1.3.1/27400"}
+		if name == "无法获取版本" {
+			stub.version = ""
+		}
+		scanner := NewScanner(stub, opts)
+
+		// 可 Seek 的数据流原样交给底层扫描器，负载均衡器才能换后端重试
+		result, err := scanner.ScanStreamContext(ctx, strings.NewReader("hello"))
+		if err != nil {
+			t.Fatalf("%s: 扫描失败: %v", name, err)
+		}
+		if _, _, seekable := stub.counts(); !seekable {
+			t.Errorf("%s: 底层扫描器收到的数据流不能 Seek", name)
+		}
+
+		// 不能 Seek 的数据流在发送的同时计算哈希，结果相同
+		piped, err := scanner.ScanStreamContext(ctx, io.MultiReader(strings.NewReader("hello")))
+		if err != nil {
+			t.Fatalf("%s: 扫描失败: %v", name, err)
+		}
+		if piped.SHA256 != result.SHA256 || result.SHA256 == "" {
+			t.Errorf("%s: SHA256 = %q 与 %q 不一致", name, piped.SHA256, result.SHA256)
+		}
+	}
+}
+
+func TestScannerDisabled(t *testing.T) {
+	stub := &stubScanner{version: "ClamAV 1.3.1/27400"}
+	scanner := NewScanner(stub, Options{Size: 0})
+	for i := 0; i < 2; i++ {
+		if result, err := scanner.ScanStreamContext(context.Background(), strings.NewReader("hello")); err != nil || result.Cached {
+			t.Errorf("Result = %+v, %v", result, err)
+		}
+	}
+	if scans, versions, _ := stub.counts(); scans != 2 || versions != 0 {
+		t.Errorf("关闭缓存时扫描 %d 次、查询版本 %d 次，期望 2 次和 0 次", scans, versions)
+	}
+}
@@ -0,0 +1,54 @@
+package clamav
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// parseAddress 解析clamd地址，返回网络类型和拨号地址
+//
+// 支持以下格式：
+//   - unix:///var/run/clamav/clamd.ctl
+//   - tcp://127.0.0.1:3310
+//   - 127.0.0.1:3310（兼容旧配置，按TCP处理）
+//   - /var/run/clamav/clamd.ctl（绝对路径按Unix域套接字处理）
+func parseAddress(address string) (string, string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", "", fmt.Errorf("ClamAV地址为空")
+	}
+
+	if strings.HasPrefix(address, "/") {
+		return "unix", address, nil
+	}
+
+	if !strings.Contains(address, "://") {
+		return "tcp", address, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("解析ClamAV地址失败: %v", err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		if u.Host != "" {
+			// unix://relative/path 形式
+			path = u.Host + u.Path
+		}
+		if path == "" {
+			return "", "", fmt.Errorf("ClamAV地址缺少套接字路径: %s", address)
+		}
+		return "unix", path, nil
+	case "tcp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("ClamAV地址缺少主机: %s", address)
+		}
+		return "tcp", u.Host, nil
+	default:
+		return "", "", fmt.Errorf("不支持的ClamAV地址协议: %s", u.Scheme)
+	}
+}
@@ -0,0 +1,404 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Strategy 表示后端选择策略
+type Strategy string
+
+const (
+	RoundRobin       Strategy = "round_robin"       // 轮询
+	LeastOutstanding Strategy = "least_outstanding" // 选择进行中请求最少的后端
+)
+
+// BalancerOptions 多后端负载均衡配置
+type BalancerOptions struct {
+	Strategy       Strategy
+	HealthInterval time.Duration // PING 健康检查及 STATS 指标更新的间隔
+	HealthTimeout  time.Duration // 单次 PING 超时
+	FailThreshold  int           // 连续失败次数达到该值后摘除后端
+}
+
+// DefaultBalancerOptions 返回默认的负载均衡配置
+func DefaultBalancerOptions() BalancerOptions {
+	return BalancerOptions{
+		Strategy:       RoundRobin,
+		HealthInterval: 10 * time.Second,
+		HealthTimeout:  2 * time.Second,
+		FailThreshold:  3,
+	}
+}
+
+// Backend 表示一个clamd后端
+type Backend struct {
+	Address string
+	Scanner ContextScanner
+}
+
+// BackendStatus 表示后端的健康状态
+type BackendStatus struct {
+	Address             string    `json:"address"`
+	Healthy             bool      `json:"healthy"`
+	Outstanding         int64     `json:"outstanding"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastCheck           time.Time `json:"lastCheck"`
+	LastError           string    `json:"lastError,omitempty"`
+}
+
+// HealthReporter 由能够报告后端健康状态的扫描器实现
+type HealthReporter interface {
+	Backends() []BackendStatus
+}
+
+// backend 记录单个后端的运行状态
+type backend struct {
+	Backend
+	outstanding atomic.Int64
+
+	mu        sync.Mutex
+	healthy   bool
+	failures  int
+	lastCheck time.Time
+	lastErr   string
+}
+
+// Balancer 将请求分发到多个clamd后端，定期健康检查并在失败时切换后端
+type Balancer struct {
+	backends []*backend
+	opts     BalancerOptions
+	next     atomic.Uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBalancer 创建一个多后端扫描器并启动健康检查
+func NewBalancer(backends []Backend, opts BalancerOptions) (*Balancer, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("至少需要一个ClamAV后端")
+	}
+
+	defaults := DefaultBalancerOptions()
+	switch opts.Strategy {
+	case "":
+		opts.Strategy = defaults.Strategy
+	case RoundRobin, LeastOutstanding:
+	default:
+		return nil, fmt.Errorf("不支持的负载均衡策略: %s", opts.Strategy)
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = defaults.HealthInterval
+	}
+	if opts.HealthTimeout <= 0 {
+		opts.HealthTimeout = defaults.HealthTimeout
+	}
+	if opts.FailThreshold <= 0 {
+		opts.FailThreshold = defaults.FailThreshold
+	}
+
+	b := &Balancer{opts: opts, done: make(chan struct{})}
+	for _, be := range backends {
+		b.backends = append(b.backends, &backend{Backend: be, healthy: true})
+	}
+
+	go b.healthLoop()
+
+	return b, nil
+}
+
+// Backends 返回所有后端的健康状态
+func (b *Balancer) Backends() []BackendStatus {
+	statuses := make([]BackendStatus, 0, len(b.backends))
+	for _, be := range b.backends {
+		be.mu.Lock()
+		statuses = append(statuses, BackendStatus{
+			Address:             be.Address,
+			Healthy:             be.healthy,
+			Outstanding:         be.outstanding.Load(),
+			ConsecutiveFailures: be.failures,
+			LastCheck:           be.lastCheck,
+			LastError:           be.lastErr,
+		})
+		be.mu.Unlock()
+	}
+	return statuses
+}
+
+// Close 停止健康检查并关闭各后端持有的连接
+func (b *Balancer) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+
+	var errs []error
+	for _, be := range b.backends {
+		if closer, ok := be.Scanner.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetVersion 获取ClamAV版本信息
+func (b *Balancer) GetVersion() (string, error) {
+	return b.GetVersionContext(context.Background())
+}
+
+// GetVersionContext 从一个可用后端获取ClamAV版本信息
+func (b *Balancer) GetVersionContext(ctx context.Context) (string, error) {
+	var version string
+	err := b.do(ctx, func(be *backend) error {
+		var err error
+		version, err = be.Scanner.GetVersionContext(ctx)
+		return err
+	})
+	return version, err
+}
+
+// Ping 检查是否有可用的clamd
+func (b *Balancer) Ping() error {
+	return b.PingContext(context.Background())
+}
+
+// PingContext 检查是否有可用的clamd
+func (b *Balancer) PingContext(ctx context.Context) error {
+	return b.do(ctx, func(be *backend) error {
+		return be.Scanner.PingContext(ctx)
+	})
+}
+
+// Reload 重新加载所有后端的病毒数据库
+func (b *Balancer) Reload() error {
+	return b.ReloadContext(context.Background())
+}
+
+// ReloadContext 重新加载所有后端的病毒数据库
+func (b *Balancer) ReloadContext(ctx context.Context) error {
+	return b.broadcast(func(be *backend) error {
+		return be.Scanner.ReloadContext(ctx)
+	})
+}
+
+// Shutdown 关闭所有后端的clamd服务
+func (b *Balancer) Shutdown() error {
+	return b.broadcast(func(be *backend) error {
+		return be.Scanner.Shutdown()
+	})
+}
+
+// ScanFile 扫描单个文件
+func (b *Balancer) ScanFile(filePath string) (Verdict, error) {
+	return b.ScanFileContext(context.Background(), filePath)
+}
+
+// ScanFileContext 扫描单个文件，后端失败时换一个后端重试
+func (b *Balancer) ScanFileContext(ctx context.Context, filePath string) (Verdict, error) {
+	var verdict Verdict
+	err := b.do(ctx, func(be *backend) error {
+		var err error
+		verdict, err = be.Scanner.ScanFileContext(ctx, filePath)
+		return err
+	})
+	return verdict, err
+}
+
+// ScanStream 扫描文件流
+func (b *Balancer) ScanStream(reader io.Reader) (Verdict, error) {
+	return b.ScanStreamContext(context.Background(), reader)
+}
+
+// ScanStreamContext 扫描文件流
+//
+// 只有 reader 实现了 io.Seeker（如上传的 multipart 文件）时，才会在后端失败后
+// 回到起始位置换一个后端重试。
+func (b *Balancer) ScanStreamContext(ctx context.Context, reader io.Reader) (Verdict, error) {
+	seeker, seekable := reader.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	attempts := 0
+	var verdict Verdict
+	err := b.do(ctx, func(be *backend) error {
+		attempts++
+		if attempts > 1 {
+			if !seekable {
+				return errNoRetry
+			}
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return errNoRetry
+			}
+		}
+
+		var err error
+		verdict, err = be.Scanner.ScanStreamContext(ctx, reader)
+		return err
+	})
+	return verdict, err
+}
+
+// errNoRetry 表示请求无法在其他后端重试
+var errNoRetry = errors.New("无法重试")
+
+// do 选择后端执行操作，失败时依次尝试其他后端
+func (b *Balancer) do(ctx context.Context, fn func(be *backend) error) error {
+	tried := make(map[*backend]bool, len(b.backends))
+	var lastErr error
+
+	for len(tried) < len(b.backends) {
+		be := b.pick(tried)
+		if be == nil {
+			break
+		}
+		tried[be] = true
+
+		be.outstanding.Add(1)
+		err := fn(be)
+		be.outstanding.Add(-1)
+
+		if errors.Is(err, errNoRetry) {
+			break
+		}
+		if err == nil {
+			return nil
+		}
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return err
+		}
+
+		b.recordFailure(be, err)
+		lastErr = fmt.Errorf("后端 %s: %v", be.Address, err)
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("没有可用的ClamAV后端")
+	}
+	return lastErr
+}
+
+// broadcast 在所有后端上执行操作，汇总错误
+func (b *Balancer) broadcast(fn func(be *backend) error) error {
+	var errs []error
+	for _, be := range b.backends {
+		if err := fn(be); err != nil {
+			errs = append(errs, fmt.Errorf("后端 %s: %v", be.Address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pick 按策略选择一个未尝试过的后端，优先选择健康的后端
+func (b *Balancer) pick(tried map[*backend]bool) *backend {
+	var candidates []*backend
+	for _, be := range b.backends {
+		if !tried[be] && be.isHealthy() {
+			candidates = append(candidates, be)
+		}
+	}
+	if len(candidates) == 0 {
+		// 所有后端都被摘除时仍然尝试，避免健康检查误判导致完全不可用
+		for _, be := range b.backends {
+			if !tried[be] {
+				candidates = append(candidates, be)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	offset := int(b.next.Add(1)-1) % len(candidates)
+	if b.opts.Strategy == RoundRobin {
+		return candidates[offset]
+	}
+
+	// 从轮询位置开始查找，使负载相同的后端轮流被选中
+	best := candidates[offset]
+	for i := 1; i < len(candidates); i++ {
+		be := candidates[(offset+i)%len(candidates)]
+		if be.outstanding.Load() < best.outstanding.Load() {
+			best = be
+		}
+	}
+	return best
+}
+
+// healthLoop 定期对所有后端执行 PING
+func (b *Balancer) healthLoop() {
+	ticker := time.NewTicker(b.opts.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		b.checkAll()
+
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll 并发检查所有后端
+func (b *Balancer) checkAll() {
+	var wg sync.WaitGroup
+	for _, be := range b.backends {
+		wg.Add(1)
+		go func(be *backend) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), b.opts.HealthTimeout)
+			defer cancel()
+
+			if err := be.Scanner.PingContext(ctx); err != nil {
+				b.recordFailure(be, err)
+				return
+			}
+			b.recordSuccess(be)
+
+			// 顺便更新 STATS 指标，查询失败不影响后端的健康状态
+			b.collectStats(ctx, be)
+		}(be)
+	}
+	wg.Wait()
+}
+
+// recordFailure 记录一次失败，连续失败达到阈值时摘除后端
+func (b *Balancer) recordFailure(be *backend, err error) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	be.failures++
+	be.lastCheck = time.Now()
+	be.lastErr = err.Error()
+	if be.failures >= b.opts.FailThreshold {
+		be.healthy = false
+	}
+}
+
+// recordSuccess 记录一次成功的健康检查，恢复被摘除的后端
+func (b *Balancer) recordSuccess(be *backend) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	be.failures = 0
+	be.lastCheck = time.Now()
+	be.lastErr = ""
+	be.healthy = true
+}
+
+// isHealthy 判断后端是否可用
+func (be *backend) isHealthy() bool {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	return be.healthy
+}
@@ -0,0 +1,266 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubScanner 是记录调用次数的测试后端，err 不为空时所有命令都返回该错误
+type stubScanner struct {
+	mu      sync.Mutex
+	err     error
+	scans   int
+	streams []string // 收到的文件流内容
+}
+
+func (s *stubScanner) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *stubScanner) counts() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scans, append([]string(nil), s.streams...)
+}
+
+func (s *stubScanner) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *stubScanner) ScanFile(filePath string) (Verdict, error) {
+	return s.ScanFileContext(context.Background(), filePath)
+}
+
+func (s *stubScanner) ScanFileContext(ctx context.Context, filePath string) (Verdict, error) {
+	if err := s.failure(); err != nil {
+		return Verdict{}, err
+	}
+	return Verdict{Path: filePath, Status: StatusClean}, nil
+}
+
+func (s *stubScanner) GetVersion() (string, error) { return s.GetVersionContext(context.Background()) }
+
+func (s *stubScanner) GetVersionContext(ctx context.Context) (string, error) {
+	return fakeVersion, s.failure()
+}
+
+func (s *stubScanner) Ping() error { return s.PingContext(context.Background()) }
+
+func (s *stubScanner) PingContext(ctx context.Context) error { return s.failure() }
+
+func (s *stubScanner) Reload() error { return s.ReloadContext(context.Background()) }
+
+func (s *stubScanner) ReloadContext(ctx context.Context) error { return s.failure() }
+
+func (s *stubScanner) Shutdown() error { return s.failure() }
+
+func (s *stubScanner) ScanStream(reader io.Reader) (Verdict, error) {
+	return s.ScanStreamContext(context.Background(), reader)
+}
+
+// ScanStreamContext 读完文件流后才返回错误，模拟发送数据后后端断开
+func (s *stubScanner) ScanStreamContext(ctx context.Context, reader io.Reader) (Verdict, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scans++
+	if s.err != nil {
+		return Verdict{}, s.err
+	}
+	s.streams = append(s.streams, string(data))
+	return Verdict{Path: "stream", Status: StatusClean}, nil
+}
+
+// newStubBalancer 创建使用 n 个测试后端的负载均衡器
+func newStubBalancer(t *testing.T, n int, opts BalancerOptions) (*Balancer, []*stubScanner) {
+	t.Helper()
+
+	var stubs []*stubScanner
+	var backends []Backend
+	for i := 0; i < n; i++ {
+		stub := &stubScanner{}
+		stubs = append(stubs, stub)
+		backends = append(backends, Backend{Address: fmt.Sprintf("stub-%d", i), Scanner: stub})
+	}
+	if opts.HealthInterval == 0 {
+		opts.HealthInterval = time.Hour
+	}
+	balancer, err := NewBalancer(backends, opts)
+	if err != nil {
+		t.Fatalf("创建负载均衡器失败: %v", err)
+	}
+	t.Cleanup(func() { balancer.Close() })
+	return balancer, stubs
+}
+
+func TestBalancerFailover(t *testing.T) {
+	live := newFakeClamd(t, "unix")
+	deadAddress := "unix://" + filepath.Join(t.TempDir(), "missing.sock")
+
+	balancer, err := NewBalancer([]Backend{
+		{Address: deadAddress, Scanner: NewClient(deadAddress)},
+		{Address: live.address, Scanner: NewClient(live.address)},
+	}, BalancerOptions{Strategy: LeastOutstanding, HealthInterval: time.Hour, FailThreshold: 1})
+	if err != nil {
+		t.Fatalf("创建负载均衡器失败: %v", err)
+	}
+	defer balancer.Close()
+
+	for i := 0; i < 4; i++ {
+		verdict, err := balancer.ScanStream(strings.NewReader("payload " + eicarMarker))
+		if err != nil {
+			t.Fatalf("第 %d 次扫描失败: %v", i+1, err)
+		}
+		if !verdict.Infected() {
+			t.Errorf("第 %d 次扫描结论 = %+v", i+1, verdict)
+		}
+	}
+
+	for _, status := range balancer.Backends() {
+		wantHealthy := status.Address == live.address
+		if status.Healthy != wantHealthy {
+			t.Errorf("后端 %s 健康状态 = %v，期望 %v", status.Address, status.Healthy, wantHealthy)
+		}
+	}
+}
+
+func TestBalancerRejectsUnknownStrategy(t *testing.T) {
+	_, err := NewBalancer([]Backend{{Address: "localhost:3310", Scanner: NewClient("localhost:3310")}},
+		BalancerOptions{Strategy: "random"})
+	if err == nil {
+		t.Fatal("未知策略应返回错误")
+	}
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	balancer, stubs := newStubBalancer(t, 3, BalancerOptions{Strategy: RoundRobin})
+
+	for i := 0; i < 9; i++ {
+		if _, err := balancer.ScanStream(strings.NewReader("data")); err != nil {
+			t.Fatalf("第 %d 次扫描失败: %v", i+1, err)
+		}
+	}
+	for i, stub := range stubs {
+		if scans, _ := stub.counts(); scans != 3 {
+			t.Errorf("后端 %d 收到 %d 次扫描，期望 3 次", i, scans)
+		}
+	}
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	balancer, stubs := newStubBalancer(t, 3, BalancerOptions{Strategy: LeastOutstanding})
+
+	// 前两个后端仍有进行中的请求，新请求都交给空闲的后端
+	balancer.backends[0].outstanding.Store(2)
+	balancer.backends[1].outstanding.Store(1)
+	for i := 0; i < 4; i++ {
+		if _, err := balancer.ScanStream(strings.NewReader("data")); err != nil {
+			t.Fatalf("第 %d 次扫描失败: %v", i+1, err)
+		}
+	}
+	for i, want := range []int{0, 0, 4} {
+		if scans, _ := stubs[i].counts(); scans != want {
+			t.Errorf("后端 %d 收到 %d 次扫描，期望 %d 次", i, scans, want)
+		}
+	}
+
+	// 负载相同时轮流选择
+	balancer.backends[0].outstanding.Store(0)
+	balancer.backends[1].outstanding.Store(0)
+	for i := 0; i < 6; i++ {
+		if _, err := balancer.ScanStream(strings.NewReader("data")); err != nil {
+			t.Fatalf("扫描失败: %v", err)
+		}
+	}
+	for i, want := range []int{2, 2, 6} {
+		if scans, _ := stubs[i].counts(); scans != want {
+			t.Errorf("负载相同时后端 %d 收到 %d 次扫描，期望 %d 次", i, scans, want)
+		}
+	}
+}
+
+func TestBalancerBackendRecovers(t *testing.T) {
+	balancer, stubs := newStubBalancer(t, 2, BalancerOptions{HealthInterval: 20 * time.Millisecond, FailThreshold: 1})
+
+	stubs[0].setErr(errors.New("连接被拒绝"))
+	waitHealthy := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for balancer.Backends()[0].Healthy != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("后端健康状态没有变为 %v: %+v", want, balancer.Backends()[0])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitHealthy(false)
+
+	// 摘除期间请求只发给健康的后端
+	for i := 0; i < 4; i++ {
+		if _, err := balancer.ScanStream(strings.NewReader("data")); err != nil {
+			t.Fatalf("第 %d 次扫描失败: %v", i+1, err)
+		}
+	}
+	if scans, _ := stubs[0].counts(); scans != 0 {
+		t.Errorf("被摘除的后端收到 %d 次扫描", scans)
+	}
+
+	// 健康检查成功后恢复
+	stubs[0].setErr(nil)
+	waitHealthy(true)
+	if status := balancer.Backends()[0]; status.ConsecutiveFailures != 0 || status.LastError != "" {
+		t.Errorf("恢复后的状态 = %+v", status)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := balancer.ScanStream(strings.NewReader("data")); err != nil {
+			t.Fatalf("恢复后第 %d 次扫描失败: %v", i+1, err)
+		}
+	}
+	if scans, _ := stubs[0].counts(); scans != 2 {
+		t.Errorf("恢复的后端收到 %d 次扫描，期望 2 次", scans)
+	}
+}
+
+func TestBalancerRetriesSeekableStream(t *testing.T) {
+	// 可 Seek 的数据流在第一个后端失败后回到起始位置，由另一个后端完整扫描
+	balancer, stubs := newStubBalancer(t, 2, BalancerOptions{Strategy: RoundRobin})
+	stubs[0].setErr(errors.New("连接被重置"))
+
+	reader := strings.NewReader("header payload")
+	reader.Seek(int64(len("header ")), io.SeekStart)
+	if _, err := balancer.ScanStream(reader); err != nil {
+		t.Fatalf("扫描失败: %v", err)
+	}
+	if scans, _ := stubs[0].counts(); scans != 1 {
+		t.Errorf("第一个后端收到 %d 次扫描，期望 1 次", scans)
+	}
+	if _, streams := stubs[1].counts(); len(streams) != 1 || streams[0] != "payload" {
+		t.Errorf("重试的后端收到 %q，期望从原位置开始的 \"payload\"", streams)
+	}
+
+	// 无法 Seek 的数据流已被读取，不在其他后端重试
+	balancer, stubs = newStubBalancer(t, 2, BalancerOptions{Strategy: RoundRobin})
+	stubs[0].setErr(errors.New("连接被重置"))
+
+	if _, err := balancer.ScanStream(io.MultiReader(strings.NewReader("payload"))); err == nil {
+		t.Fatal("无法 Seek 的数据流扫描失败后没有返回错误")
+	}
+	if scans, _ := stubs[1].counts(); scans != 0 {
+		t.Errorf("无法 Seek 的数据流在另一个后端重试了 %d 次", scans)
+	}
+}
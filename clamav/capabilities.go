@@ -0,0 +1,230 @@
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnsupported 表示clamd不支持所需的命令
+var ErrUnsupported = errors.New("clamd 不支持该命令")
+
+// legacyCommands 是不支持 VERSIONCOMMANDS 的旧版本clamd（0.95 之前）提供的命令
+var legacyCommands = []string{"PING", "VERSION", "RELOAD", "SHUTDOWN", "SCAN", "CONTSCAN", "MULTISCAN"}
+
+// Capabilities 是clamd通过 VERSIONCOMMANDS 报告的版本和支持的命令
+//
+// clamd 的回复格式为 "ClamAV 1.3.1/27400/Mon Sep 30 09:30:00 2024| COMMANDS: SCAN QUIT RELOAD PING ..."。
+type Capabilities struct {
+	Version    string    `json:"version"`    // VERSION 部分
+	Commands   []string  `json:"commands"`   // 支持的命令
+	Legacy     bool      `json:"legacy"`     // clamd 不支持 VERSIONCOMMANDS，Commands 为旧版本的命令
+	DetectedAt time.Time `json:"detectedAt"` // 查询时间
+}
+
+// ParseVersionCommands 解析 VERSIONCOMMANDS 命令的回复
+func ParseVersionCommands(reply string) (Capabilities, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	version, commands, found := strings.Cut(reply, "| COMMANDS:")
+	if !found || !strings.HasPrefix(version, "ClamAV ") {
+		return Capabilities{}, fmt.Errorf("无法识别的VERSIONCOMMANDS回复: %s", reply)
+	}
+	return Capabilities{
+		Version:  strings.TrimSpace(version),
+		Commands: strings.Fields(commands),
+	}, nil
+}
+
+// Supports 判断clamd是否支持 command（不区分大小写）
+func (c Capabilities) Supports(command string) bool {
+	return slices.Contains(c.Commands, strings.ToUpper(command))
+}
+
+// Degraded 返回因clamd不支持相应命令而降级的功能
+func (c Capabilities) Degraded() []string {
+	var degraded []string
+	for _, feature := range []struct{ command, fallback string }{
+		{"IDSESSION", "连接池改用一次性连接"},
+		{"INSTREAM", "无法扫描上传的文件"},
+		{"ALLMATCHSCAN", "allmatchscan 模式改用 CONTSCAN，每个文件只报告第一个特征"},
+		{"MULTISCAN", "multiscan 模式改用 CONTSCAN"},
+		{"STATS", "无法查询 STATS"},
+	} {
+		if !c.Supports(feature.command) {
+			degraded = append(degraded, feature.command+": "+feature.fallback)
+		}
+	}
+	return degraded
+}
+
+// unsupported 返回表示clamd不支持 command 的错误
+func unsupported(command string) error {
+	return fmt.Errorf("%w: %s", ErrUnsupported, command)
+}
+
+// CapabilityDetector 由能够查询clamd支持的命令的扫描器实现
+type CapabilityDetector interface {
+	CapabilitiesContext(ctx context.Context) (Capabilities, error)
+}
+
+// 查询失败后的退避时间，连续失败时从 capabilityRetryMin 起加倍
+const (
+	capabilityRetryMin = time.Second
+	capabilityRetryMax = time.Minute
+)
+
+// capabilityCache 缓存一个clamd后端支持的命令，以及最近一次查询失败的原因
+type capabilityCache struct {
+	mu   sync.Mutex // 保证同时只有一个查询，并保护下面的失败状态
+	caps atomic.Pointer[Capabilities]
+
+	err     error            // 最近一次查询失败的原因，成功后清除
+	retryAt time.Time        // 查询失败后，在此之前直接返回 err
+	backoff time.Duration    // 上一次的退避时间
+	now     func() time.Time // 为 nil 时使用 time.Now，测试时替换
+}
+
+// failed 记录查询失败，调用方需持有 mu
+func (c *capabilityCache) failed(err error, now time.Time) {
+	c.backoff = min(max(c.backoff*2, capabilityRetryMin), capabilityRetryMax)
+	c.err = err
+	c.retryAt = now.Add(c.backoff)
+}
+
+// CapabilitiesContext 返回clamd支持的命令
+//
+// 首次调用时通过 VERSIONCOMMANDS 查询并缓存，连接clamd失败后重新查询（clamd 重启后可能已升级）。
+// clamd 不支持 VERSIONCOMMANDS 时按旧版本处理。查询失败时在退避时间内直接返回上次的错误，
+// 避免每个命令都多一次往返。
+func (c *Client) CapabilitiesContext(ctx context.Context) (Capabilities, error) {
+	if caps := c.capabilities.caps.Load(); caps != nil {
+		return *caps, nil
+	}
+
+	cache := &c.capabilities
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if caps := cache.caps.Load(); caps != nil {
+		return *caps, nil
+	}
+
+	now := time.Now()
+	if cache.now != nil {
+		now = cache.now()
+	}
+	if cache.err != nil && now.Before(cache.retryAt) {
+		return Capabilities{}, cache.err
+	}
+
+	caps, err := c.detectCapabilities(ctx)
+	if err != nil {
+		// 调用方取消或超时不代表clamd有问题
+		if ctx.Err() == nil {
+			cache.failed(err, now)
+		}
+		return Capabilities{}, err
+	}
+	cache.err, cache.backoff = nil, 0
+	cache.caps.Store(&caps)
+	return caps, nil
+}
+
+// forgetCapabilities 清除缓存的命令，下次使用时重新查询
+func (c *Client) forgetCapabilities() {
+	c.capabilities.caps.Store(nil)
+}
+
+// detectCapabilities 发送 VERSIONCOMMANDS 查询clamd支持的命令
+func (c *Client) detectCapabilities(ctx context.Context) (Capabilities, error) {
+	var reply string
+	err := c.converse(ctx, c.timeouts.Command, func(conn net.Conn) error {
+		if _, err := conn.Write([]byte("zVERSIONCOMMANDS\x00")); err != nil {
+			return fmt.Errorf("发送VERSIONCOMMANDS命令失败: %v", err)
+		}
+
+		response, err := bufio.NewReader(conn).ReadString('\x00')
+		if err != nil && response == "" {
+			return fmt.Errorf("读取VERSIONCOMMANDS响应失败: %v", err)
+		}
+		reply = response
+		return nil
+	})
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	if strings.HasPrefix(reply, "UNKNOWN COMMAND") {
+		version, err := c.GetVersionContext(ctx)
+		if err != nil {
+			return Capabilities{}, err
+		}
+		return Capabilities{Version: version, Commands: legacyCommands, Legacy: true, DetectedAt: time.Now()}, nil
+	}
+
+	caps, err := ParseVersionCommands(reply)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	caps.DetectedAt = time.Now()
+	return caps, nil
+}
+
+// supports 判断clamd是否支持 command；无法查询（或处于查询失败后的退避时间内）时假定支持，由clamd返回的错误说明原因
+func (c *Client) supports(ctx context.Context, command string) bool {
+	caps, err := c.CapabilitiesContext(ctx)
+	return err != nil || caps.Supports(command)
+}
+
+// CapabilitiesContext 返回clamd支持的命令，与连接池的一次性连接共用缓存
+func (p *Pool) CapabilitiesContext(ctx context.Context) (Capabilities, error) {
+	return p.client.CapabilitiesContext(ctx)
+}
+
+// BackendCapabilities 表示单个后端支持的命令
+type BackendCapabilities struct {
+	Address      string        `json:"address"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+	Degraded     []string      `json:"degraded,omitempty"` // 因不支持相应命令而降级的功能
+	Error        string        `json:"error,omitempty"`    // 查询失败的原因
+}
+
+// CapabilityReporter 由能够查询各后端支持的命令的扫描器实现
+type CapabilityReporter interface {
+	BackendCapabilities(ctx context.Context) []BackendCapabilities
+}
+
+// BackendCapabilities 并发查询所有后端支持的命令，已缓存的后端不会再次查询
+func (b *Balancer) BackendCapabilities(ctx context.Context) []BackendCapabilities {
+	results := make([]BackendCapabilities, len(b.backends))
+	var wg sync.WaitGroup
+	for i, be := range b.backends {
+		results[i].Address = be.Address
+		wg.Add(1)
+		go func(result *BackendCapabilities, be *backend) {
+			defer wg.Done()
+
+			detector, ok := be.Scanner.(CapabilityDetector)
+			if !ok {
+				result.Error = "后端不支持查询命令"
+				return
+			}
+			caps, err := detector.CapabilitiesContext(ctx)
+			if err != nil {
+				result.Error = err.Error()
+				return
+			}
+			result.Capabilities = &caps
+			result.Degraded = caps.Degraded()
+		}(&results[i], be)
+	}
+	wg.Wait()
+
+	return results
+}
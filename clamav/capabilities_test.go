@@ -0,0 +1,210 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVersionCommands(t *testing.T) {
+	caps, err := ParseVersionCommands(fakeVersion + "| COMMANDS: SCAN QUIT PING STATS IDSESSION INSTREAM\x00")
+	if err != nil {
+		t.Fatalf("ParseVersionCommands 失败: %v", err)
+	}
+	if caps.Version != fakeVersion || !caps.Supports("STATS") || !caps.Supports("idsession") || caps.Supports("ALLMATCHSCAN") {
+		t.Errorf("Capabilities = %+v", caps)
+	}
+	if degraded := caps.Degraded(); len(degraded) != 2 ||
+		!strings.HasPrefix(degraded[0], "ALLMATCHSCAN") || !strings.HasPrefix(degraded[1], "MULTISCAN") {
+		t.Errorf("Degraded = %v", degraded)
+	}
+
+	for _, bad := range []string{"", "UNKNOWN COMMAND", fakeVersion} {
+		if _, err := ParseVersionCommands(bad); err == nil {
+			t.Errorf("ParseVersionCommands(%q) 没有返回错误", bad)
+		}
+	}
+}
+
+func TestCapabilitiesCached(t *testing.T) {
+	fake := newFakeClamd(t, "unix")
+	pool := NewPool(fake.address, PoolOptions{})
+	defer pool.Close()
+
+	for i := 0; i < 3; i++ {
+		caps, err := pool.CapabilitiesContext(context.Background())
+		if err != nil {
+			t.Fatalf("CapabilitiesContext 失败: %v", err)
+		}
+		if caps.Legacy || !caps.Supports("ALLMATCHSCAN") || len(caps.Degraded()) != 0 {
+			t.Errorf("Capabilities = %+v", caps)
+		}
+		if err := pool.Ping(); err != nil {
+			t.Fatalf("Ping 失败: %v", err)
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if n := countCommand(fake.commands, "VERSIONCOMMANDS"); n != 1 {
+		t.Errorf("发送了 %d 次 VERSIONCOMMANDS，期望 1 次", n)
+	}
+	// 一个查询 VERSIONCOMMANDS 的一次性连接和一个会话连接
+	if fake.conns != 2 {
+		t.Errorf("建立了 %d 个连接，期望 2 个", fake.conns)
+	}
+}
+
+func TestCapabilitiesFailureBackoff(t *testing.T) {
+	fake := newFakeClamd(t, "unix")
+	fake.versionCommandsReply = "garbled"
+	client := newClient(fake.address, Timeouts{})
+	now := time.Now()
+	client.capabilities.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	versionCommands := func() int {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return countCommand(fake.commands, "VERSIONCOMMANDS")
+	}
+
+	// 查询失败时假定支持，退避时间内不再查询
+	for i := 0; i < 3; i++ {
+		if verdict, err := client.ScanStreamContext(ctx, strings.NewReader("data")); err != nil || !verdict.Clean() {
+			t.Fatalf("ScanStream = %+v, %v", verdict, err)
+		}
+	}
+	if _, err := client.CapabilitiesContext(ctx); err == nil {
+		t.Fatal("CapabilitiesContext 没有返回缓存的错误")
+	}
+	if n := versionCommands(); n != 1 {
+		t.Fatalf("退避时间内发送了 %d 次 VERSIONCOMMANDS，期望 1 次", n)
+	}
+
+	// 退避时间结束后重新查询，再次失败时退避时间加倍
+	now = now.Add(capabilityRetryMin)
+	client.CapabilitiesContext(ctx)
+	now = now.Add(capabilityRetryMin)
+	client.CapabilitiesContext(ctx)
+	if n := versionCommands(); n != 2 {
+		t.Fatalf("发送了 %d 次 VERSIONCOMMANDS，期望 2 次", n)
+	}
+	if client.capabilities.backoff != 2*capabilityRetryMin {
+		t.Errorf("退避时间 = %v", client.capabilities.backoff)
+	}
+
+	// 恢复后缓存结果并清除失败状态
+	fake.mu.Lock()
+	fake.versionCommandsReply = ""
+	fake.mu.Unlock()
+	now = now.Add(capabilityRetryMax)
+	if caps, err := client.CapabilitiesContext(ctx); err != nil || caps.Legacy {
+		t.Fatalf("CapabilitiesContext = %+v, %v", caps, err)
+	}
+	if client.capabilities.err != nil || client.capabilities.backoff != 0 {
+		t.Errorf("成功后仍保留失败状态: %v, %v", client.capabilities.err, client.capabilities.backoff)
+	}
+
+	// 调用方取消不记录为失败
+	client.forgetCapabilities()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	client.CapabilitiesContext(canceled)
+	if client.capabilities.err != nil {
+		t.Errorf("调用方取消被记录为失败: %v", client.capabilities.err)
+	}
+}
+
+func TestLegacyClamd(t *testing.T) {
+	for name, factory := range scannerFactories() {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeClamd(t, "unix")
+			fake.disable("VERSIONCOMMANDS", "IDSESSION", "INSTREAM", "STATS", "ALLMATCHSCAN")
+			scanner, closeScanner := factory(fake.address)
+			defer closeScanner()
+			ctx := context.Background()
+
+			caps, err := scanner.(CapabilityDetector).CapabilitiesContext(ctx)
+			if err != nil || !caps.Legacy || caps.Version != fakeVersion || caps.Supports("IDSESSION") {
+				t.Fatalf("CapabilitiesContext = %+v, %v", caps, err)
+			}
+
+			// 不支持会话时连接池改用一次性连接
+			if err := scanner.PingContext(ctx); err != nil {
+				t.Errorf("Ping 失败: %v", err)
+			}
+			if verdict, err := scanner.ScanFileContext(ctx, "/data/eicar.com"); err != nil || !verdict.Infected() {
+				t.Errorf("ScanFile = %+v, %v", verdict, err)
+			}
+
+			if _, err := scanner.ScanStreamContext(ctx, strings.NewReader("data")); !errors.Is(err, ErrUnsupported) {
+				t.Errorf("ScanStream 返回 %v，期望 ErrUnsupported", err)
+			}
+			if _, err := scanner.(StatsScanner).StatsContext(ctx); !errors.Is(err, ErrUnsupported) {
+				t.Errorf("Stats 返回 %v，期望 ErrUnsupported", err)
+			}
+
+			// ALLMATCHSCAN 改用 CONTSCAN，每个文件只有第一个特征
+			verdicts, err := scanner.(PathScanner).ScanPathContext(ctx, "/data/dir", ModeAllMatchScan)
+			if err != nil || len(verdicts) != 2 || len(verdicts[0].Signatures) != 1 {
+				t.Errorf("ALLMATCHSCAN = %+v, %v", verdicts, err)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			for _, cmd := range []string{"IDSESSION", "INSTREAM", "STATS", "ALLMATCHSCAN /data/dir"} {
+				if slices.Contains(fake.commands, cmd) {
+					t.Errorf("向旧版本clamd发送了 %s", cmd)
+				}
+			}
+			if !slices.Contains(fake.commands, "CONTSCAN /data/dir") {
+				t.Errorf("没有改用 CONTSCAN: %v", fake.commands)
+			}
+		})
+	}
+}
+
+func TestBalancerCapabilities(t *testing.T) {
+	current := newFakeClamd(t, "unix")
+	legacy := newFakeClamd(t, "unix")
+	legacy.disable("VERSIONCOMMANDS", "IDSESSION", "INSTREAM", "STATS", "ALLMATCHSCAN")
+	deadAddress := "unix:///nonexistent/clamd.sock"
+	balancer, err := NewBalancer([]Backend{
+		{Address: current.address, Scanner: NewClient(current.address)},
+		{Address: legacy.address, Scanner: NewClient(legacy.address)},
+		{Address: deadAddress, Scanner: NewClient(deadAddress)},
+	}, BalancerOptions{HealthInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("创建负载均衡器失败: %v", err)
+	}
+	defer balancer.Close()
+
+	results := balancer.BackendCapabilities(context.Background())
+	if len(results) != 3 {
+		t.Fatalf("BackendCapabilities = %+v", results)
+	}
+	if r := results[0]; r.Capabilities == nil || r.Capabilities.Legacy || len(r.Degraded) != 0 || r.Error != "" {
+		t.Errorf("当前版本后端的结果 = %+v", r)
+	}
+	if r := results[1]; r.Capabilities == nil || !r.Capabilities.Legacy || len(r.Degraded) != 4 {
+		t.Errorf("旧版本后端的结果 = %+v", r)
+	}
+	if r := results[2]; r.Address != deadAddress || r.Capabilities != nil || r.Error == "" {
+		t.Errorf("不可用后端的结果 = %+v", r)
+	}
+}
+
+// countCommand 返回 commands 中 cmd 出现的次数
+func countCommand(commands []string, cmd string) int {
+	n := 0
+	for _, c := range commands {
+		if c == cmd {
+			n++
+		}
+	}
+	return n
+}
@@ -2,194 +2,371 @@ package clamav
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"strings"
 	"time"
+
+	"github.com/SmallGaoX/clamd-api/metrics"
 )
 
 // Scanner 接口定义了防病毒扫描器的行为
 type Scanner interface {
-	ScanFile(filePath string) (string, error)
+	ScanFile(filePath string) (Verdict, error)
 	GetVersion() (string, error)
 	Ping() error
 	Reload() error
 	Shutdown() error
-	ScanStream(reader io.Reader) (string, error)
+	ScanStream(reader io.Reader) (Verdict, error)
+}
+
+// ContextScanner 是支持 context 的扫描器接口，取消 context 会中止与clamd的对话
+type ContextScanner interface {
+	Scanner
+	ScanFileContext(ctx context.Context, filePath string) (Verdict, error)
+	GetVersionContext(ctx context.Context) (string, error)
+	PingContext(ctx context.Context) error
+	ReloadContext(ctx context.Context) error
+	ScanStreamContext(ctx context.Context, reader io.Reader) (Verdict, error)
+}
+
+const chunkSize = 2048
+
+// Timeouts 定义与clamd通信的各项超时，context 的截止时间更早时以 context 为准
+type Timeouts struct {
+	Dial    time.Duration // 建立连接
+	Command time.Duration // PING、VERSION、RELOAD 等简单命令
+	Scan    time.Duration // SCAN、INSTREAM 扫描命令
+}
+
+// DefaultTimeouts 返回默认超时配置
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Dial:    10 * time.Second,
+		Command: 10 * time.Second,
+		Scan:    30 * time.Second,
+	}
+}
+
+// withDefaults 用默认值填充未设置的超时
+func (t Timeouts) withDefaults() Timeouts {
+	defaults := DefaultTimeouts()
+	if t.Dial <= 0 {
+		t.Dial = defaults.Dial
+	}
+	if t.Command <= 0 {
+		t.Command = defaults.Command
+	}
+	if t.Scan <= 0 {
+		t.Scan = defaults.Scan
+	}
+	return t
+}
+
+// deadline 计算本次操作的截止时间
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	d := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(d) {
+		return ctxDeadline
+	}
+	return d
+}
+
+// contextError 在 context 已结束时返回包装后的错误
+func contextError(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		// 连接的截止时间与 context 相同，超时可能先于 context 自身被感知
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			err = context.DeadlineExceeded
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("与ClamAV的通信已中止: %w", err)
+	}
+	return nil
 }
 
 // Client 结构体表示ClamAV客户端
 type Client struct {
-	address string
+	network  string
+	address  string
+	err      error // 地址解析错误，在连接时返回
+	timeouts Timeouts
+
+	capabilities capabilityCache // VERSIONCOMMANDS 报告的命令
 }
 
-// NewClient 创建一个新的ClamAV客户端
-func NewClient(address string) Scanner {
-	return &Client{address: address}
+// NewClient 创建一个新的ClamAV客户端，address 支持 unix:///path 和 tcp://host:port 格式
+func NewClient(address string) ContextScanner {
+	return newClient(address, Timeouts{})
 }
 
-// GetVersion 获取ClamAV版本信息
-func (c *Client) GetVersion() (string, error) {
-	conn, err := net.Dial("tcp", c.address)
-	if err != nil {
-		return "", fmt.Errorf("连接ClamAV失败: %v", err)
+// NewClientWithTimeouts 创建一个使用指定超时的ClamAV客户端
+func NewClientWithTimeouts(address string, timeouts Timeouts) ContextScanner {
+	return newClient(address, timeouts)
+}
+
+// newClient 解析地址并创建客户端
+func newClient(address string, timeouts Timeouts) *Client {
+	network, addr, err := parseAddress(address)
+	return &Client{network: network, address: addr, err: err, timeouts: timeouts.withDefaults()}
+}
+
+// dial 建立到clamd的连接
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	if c.err != nil {
+		return nil, c.err
 	}
-	defer conn.Close()
 
-	_, err = fmt.Fprintf(conn, "VERSION\n")
+	dialer := net.Dialer{Timeout: c.timeouts.Dial}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
 	if err != nil {
-		return "", fmt.Errorf("发送版本命令失败: %v", err)
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return nil, ctxErr
+		}
+		metrics.ClamdConnectionErrors.Inc(c.address)
+		c.forgetCapabilities()
+		return nil, fmt.Errorf("连接ClamAV失败: %v", err)
 	}
 
-	scanner := bufio.NewScanner(conn)
-	scanner.Scan()
-	version := scanner.Text()
-
-	return version, nil
+	return conn, nil
 }
 
-// Ping 检查clamd是否正在运行
-func (c *Client) Ping() error {
-	conn, err := net.Dial("tcp", c.address)
+// converse 建立一次性连接并执行一次对话，context 取消时立即关闭连接
+func (c *Client) converse(ctx context.Context, timeout time.Duration, fn func(conn net.Conn) error) error {
+	conn, err := c.dial(ctx)
 	if err != nil {
-		return fmt.Errorf("连接ClamAV失败: %v", err)
+		return err
 	}
 	defer conn.Close()
 
-	_, err = fmt.Fprintf(conn, "PING\n")
-	if err != nil {
-		return fmt.Errorf("发送PING命令失败: %v", err)
+	if err := conn.SetDeadline(deadline(ctx, timeout)); err != nil {
+		return fmt.Errorf("设置超时失败: %v", err)
 	}
 
-	scanner := bufio.NewScanner(conn)
-	scanner.Scan()
-	response := scanner.Text()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 
-	if response != "PONG" {
-		return fmt.Errorf("未收到预期的PONG响应: %s", response)
+	err = fn(conn)
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return ctxErr
+		}
 	}
 
-	return nil
+	return err
+}
+
+// GetVersion 获取ClamAV版本信息
+func (c *Client) GetVersion() (string, error) {
+	return c.GetVersionContext(context.Background())
+}
+
+// GetVersionContext 获取ClamAV版本信息
+func (c *Client) GetVersionContext(ctx context.Context) (string, error) {
+	var version string
+	err := c.converse(ctx, c.timeouts.Command, func(conn net.Conn) error {
+		_, err := fmt.Fprintf(conn, "VERSION\n")
+		if err != nil {
+			return fmt.Errorf("发送版本命令失败: %v", err)
+		}
+
+		scanner := bufio.NewScanner(conn)
+		if !scanner.Scan() {
+			return fmt.Errorf("读取版本信息失败: %v", scanner.Err())
+		}
+		version = scanner.Text()
+
+		return nil
+	})
+
+	return version, err
+}
+
+// Ping 检查clamd是否正在运行
+func (c *Client) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+// PingContext 检查clamd是否正在运行
+func (c *Client) PingContext(ctx context.Context) error {
+	return c.converse(ctx, c.timeouts.Command, func(conn net.Conn) error {
+		_, err := fmt.Fprintf(conn, "PING\n")
+		if err != nil {
+			return fmt.Errorf("发送PING命令失败: %v", err)
+		}
+
+		scanner := bufio.NewScanner(conn)
+		scanner.Scan()
+		response := scanner.Text()
+
+		if response != "PONG" {
+			return fmt.Errorf("未收到预期的PONG响应: %s", response)
+		}
+
+		return nil
+	})
 }
 
 // Reload 重新加载病毒数据库
 func (c *Client) Reload() error {
-	conn, err := net.Dial("tcp", c.address)
-	if err != nil {
-		return fmt.Errorf("连接ClamAV失败: %v", err)
-	}
-	defer conn.Close()
+	return c.ReloadContext(context.Background())
+}
 
-	_, err = fmt.Fprintf(conn, "RELOAD\n")
-	if err != nil {
-		return fmt.Errorf("发送RELOAD命令失败: %v", err)
-	}
+// ReloadContext 重新加载病毒数据库
+func (c *Client) ReloadContext(ctx context.Context) error {
+	return c.converse(ctx, c.timeouts.Command, func(conn net.Conn) error {
+		_, err := fmt.Fprintf(conn, "RELOAD\n")
+		if err != nil {
+			return fmt.Errorf("发送RELOAD命令失败: %v", err)
+		}
 
-	scanner := bufio.NewScanner(conn)
-	scanner.Scan()
-	response := scanner.Text()
+		scanner := bufio.NewScanner(conn)
+		scanner.Scan()
+		response := scanner.Text()
 
-	if response != "RELOADING" {
-		return fmt.Errorf("未收到预期的RELOADING响应: %s", response)
-	}
+		if response != "RELOADING" {
+			return fmt.Errorf("未收到预期的RELOADING响应: %s", response)
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // Shutdown 关闭clamd服务
 func (c *Client) Shutdown() error {
-	conn, err := net.Dial("tcp", c.address)
-	if err != nil {
-		return fmt.Errorf("连接ClamAV失败: %v", err)
-	}
-	defer conn.Close()
-
-	_, err = fmt.Fprintf(conn, "SHUTDOWN\n")
-	if err != nil {
-		return fmt.Errorf("发送SHUTDOWN命令失败: %v", err)
-	}
+	return c.converse(context.Background(), c.timeouts.Command, func(conn net.Conn) error {
+		_, err := fmt.Fprintf(conn, "SHUTDOWN\n")
+		if err != nil {
+			return fmt.Errorf("发送SHUTDOWN命令失败: %v", err)
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // ScanFile 扫描单个文件
-func (c *Client) ScanFile(filePath string) (string, error) {
-	conn, err := net.DialTimeout("tcp", c.address, 10*time.Second)
-	if err != nil {
-		return "", fmt.Errorf("连接ClamAV失败: %v", err)
-	}
-	defer conn.Close()
+func (c *Client) ScanFile(filePath string) (Verdict, error) {
+	return c.ScanFileContext(context.Background(), filePath)
+}
 
-	// 发送SCAN命令
-	_, err = fmt.Fprintf(conn, "SCAN %s\n", filePath)
-	if err != nil {
-		return "", fmt.Errorf("发送SCAN命令失败: %v", err)
-	}
+// ScanFileContext 扫描单个文件
+func (c *Client) ScanFileContext(ctx context.Context, filePath string) (Verdict, error) {
+	var result Verdict
+	err := c.converse(ctx, c.timeouts.Scan, func(conn net.Conn) error {
+		// 发送SCAN命令
+		_, err := fmt.Fprintf(conn, "SCAN %s\n", filePath)
+		if err != nil {
+			return fmt.Errorf("发送SCAN命令失败: %v", err)
+		}
 
-	// 读取扫描结果
-	response, err := bufio.NewReader(conn).ReadString('\n')
-	if err != nil {
-		return "", fmt.Errorf("读取扫描结果失败: %v", err)
-	}
+		// 读取扫描结果
+		response, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("读取扫描结果失败: %v", err)
+		}
+
+		result = ParseVerdict(filePath, response)
+		return nil
+	})
 
-	return strings.TrimSpace(response), nil
+	return result, err
 }
 
 // ScanStream 扫描文件流
-func (c *Client) ScanStream(reader io.Reader) (string, error) {
-	conn, err := net.DialTimeout("tcp", c.address, 10*time.Second)
-	if err != nil {
-		return "", fmt.Errorf("连接ClamAV失败: %v", err)
+func (c *Client) ScanStream(reader io.Reader) (Verdict, error) {
+	return c.ScanStreamContext(context.Background(), reader)
+}
+
+// ScanStreamContext 扫描文件流，返回clamd报告的所有命中的特征
+func (c *Client) ScanStreamContext(ctx context.Context, reader io.Reader) (Verdict, error) {
+	if !c.supports(ctx, "INSTREAM") {
+		return Verdict{}, unsupported("INSTREAM")
 	}
-	defer conn.Close()
 
-	// 设置超时
-	err = conn.SetDeadline(time.Now().Add(30 * time.Second))
-	if err != nil {
-		return "", fmt.Errorf("设置超时失败: %v", err)
+	var result Verdict
+	err := c.converse(ctx, c.timeouts.Scan, func(conn net.Conn) error {
+		// 发送INSTREAM命令
+		_, err := conn.Write([]byte("zINSTREAM\x00"))
+		if err != nil {
+			return fmt.Errorf("发送INSTREAM命令失败: %v", err)
+		}
+
+		// 发送文件内容及结束标记
+		if err := writeStream(conn, reader); err != nil {
+			return err
+		}
+
+		// 读取扫描结果，同一个流命中多个特征时clamd会返回多行，读取到连接关闭为止
+		response, err := io.ReadAll(conn)
+		if err != nil {
+			return fmt.Errorf("读取扫描结果失败: %v", err)
+		}
+
+		result, err = streamVerdict(string(response))
+		return err
+	})
+
+	return result, err
+}
+
+// streamVerdict 将 INSTREAM 的回复合并为一个结论
+//
+// 回复中任何一行发现威胁时结论为 infected，包含所有行的特征。
+func streamVerdict(reply string) (Verdict, error) {
+	verdicts := ParseVerdicts(reply)
+	if len(verdicts) == 0 {
+		return Verdict{}, errors.New("clamd 没有返回扫描结果")
 	}
 
-	// 发送INSTREAM命令
-	_, err = conn.Write([]byte("zINSTREAM\x00"))
-	if err != nil {
-		return "", fmt.Errorf("发送INSTREAM命令失败: %v", err)
+	verdict := verdicts[0]
+	for _, v := range verdicts[1:] {
+		verdict.Raw += "\n" + v.Raw
+		if !v.Infected() {
+			continue
+		}
+		if !verdict.Infected() {
+			verdict.Status = StatusInfected
+			verdict.Error = ""
+		}
+		verdict.Signatures = append(verdict.Signatures, v.Signatures...)
 	}
+	return verdict, nil
+}
 
-	// 发送文件内容
-	buf := make([]byte, 2048)
+// writeStream 按 INSTREAM 协议分块发送数据，并以长度为0的块结束
+func writeStream(w io.Writer, reader io.Reader) error {
+	buf := make([]byte, chunkSize)
 	for {
-		n, err := reader.Read(buf)
-		if err != nil && err != io.EOF {
-			return "", fmt.Errorf("读取文件流失败: %v", err)
-		}
-		if n == 0 {
-			break
+		n, readErr := reader.Read(buf)
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("读取文件流失败: %v", readErr)
 		}
 
-		err = binary.Write(conn, binary.BigEndian, uint32(n))
-		if err != nil {
-			return "", fmt.Errorf("发送数据大小失败: %v", err)
+		if n > 0 {
+			if err := binary.Write(w, binary.BigEndian, uint32(n)); err != nil {
+				return fmt.Errorf("发送数据大小失败: %v", err)
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return fmt.Errorf("发送数据失败: %v", err)
+			}
+			metrics.StreamedBytes.Add(float64(n))
 		}
 
-		_, err = conn.Write(buf[:n])
-		if err != nil {
-			return "", fmt.Errorf("发送数据失败: %v", err)
+		if readErr == io.EOF {
+			break
 		}
 	}
 
 	// 发送结束标记
-	err = binary.Write(conn, binary.BigEndian, uint32(0))
+	err := binary.Write(w, binary.BigEndian, uint32(0))
 	if err != nil {
-		return "", fmt.Errorf("发送结束标记失败: %v", err)
+		return fmt.Errorf("发送结束标记失败: %v", err)
 	}
 
-	// 读取扫描结果
-	response, err := bufio.NewReader(conn).ReadString('\x00')
-	if err != nil {
-		return "", fmt.Errorf("读取扫描结果失败: %v", err)
-	}
-
-	return strings.TrimSpace(strings.TrimSuffix(response, "\x00")), nil
+	return nil
 }
@@ -0,0 +1,173 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		addr    string
+		wantErr bool
+	}{
+		{address: "unix:///var/run/clamav/clamd.ctl", network: "unix", addr: "/var/run/clamav/clamd.ctl"},
+		{address: "/var/run/clamav/clamd.ctl", network: "unix", addr: "/var/run/clamav/clamd.ctl"},
+		{address: "tcp://127.0.0.1:3310", network: "tcp", addr: "127.0.0.1:3310"},
+		{address: "localhost:3310", network: "tcp", addr: "localhost:3310"},
+		{address: "", wantErr: true},
+		{address: "tcp://", wantErr: true},
+		{address: "udp://127.0.0.1:3310", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		network, addr, err := parseAddress(tt.address)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseAddress(%q) 应返回错误", tt.address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAddress(%q) 返回错误: %v", tt.address, err)
+			continue
+		}
+		if network != tt.network || addr != tt.addr {
+			t.Errorf("parseAddress(%q) = %s, %s，期望 %s, %s", tt.address, network, addr, tt.network, tt.addr)
+		}
+	}
+}
+
+// scannerFactories 返回需要测试的 Scanner 实现
+func scannerFactories() map[string]func(address string) (ContextScanner, func()) {
+	return map[string]func(address string) (ContextScanner, func()){
+		"client": func(address string) (ContextScanner, func()) {
+			return NewClient(address), func() {}
+		},
+		"pool": func(address string) (ContextScanner, func()) {
+			pool := NewPool(address, PoolOptions{MaxSessions: 2, MaxInflight: 2})
+			return pool, func() { pool.Close() }
+		},
+	}
+}
+
+func TestScannerTransports(t *testing.T) {
+	for _, network := range []string{"unix", "tcp"} {
+		for name, factory := range scannerFactories() {
+			t.Run(network+"/"+name, func(t *testing.T) {
+				fake := newFakeClamd(t, network)
+				scanner, closeScanner := factory(fake.address)
+				defer closeScanner()
+
+				if err := scanner.Ping(); err != nil {
+					t.Fatalf("Ping 失败: %v", err)
+				}
+
+				version, err := scanner.GetVersion()
+				if err != nil {
+					t.Fatalf("GetVersion 失败: %v", err)
+				}
+				if version != fakeVersion {
+					t.Errorf("GetVersion = %q，期望 %q", version, fakeVersion)
+				}
+
+				verdict, err := scanner.ScanFile("/data/report.pdf")
+				if err != nil {
+					t.Fatalf("ScanFile 失败: %v", err)
+				}
+				if !verdict.Clean() || verdict.Path != "/data/report.pdf" {
+					t.Errorf("ScanFile = %+v", verdict)
+				}
+
+				verdict, err = scanner.ScanStream(strings.NewReader("hello world"))
+				if err != nil {
+					t.Fatalf("ScanStream 失败: %v", err)
+				}
+				if !verdict.Clean() {
+					t.Errorf("ScanStream = %+v", verdict)
+				}
+
+				verdict, err = scanner.ScanStream(strings.NewReader(strings.Repeat("x", 5000) + eicarMarker))
+				if err != nil {
+					t.Fatalf("ScanStream 失败: %v", err)
+				}
+				if !verdict.Infected() || len(verdict.Signatures) != 1 || verdict.Signatures[0] != fakeSignature {
+					t.Errorf("ScanStream = %+v", verdict)
+				}
+
+				if err := scanner.Reload(); err != nil {
+					t.Fatalf("Reload 失败: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestScannerContextCancel(t *testing.T) {
+	for name, factory := range scannerFactories() {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeClamd(t, "unix")
+			scanner, closeScanner := factory(fake.address)
+			defer closeScanner()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			_, err := scanner.ScanFileContext(ctx, "/data/slow.bin")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("期望 context.DeadlineExceeded，实际: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed >= slowScanDelay {
+				t.Errorf("取消后仍等待了 %s", elapsed)
+			}
+
+			// 取消后扫描器仍可继续使用
+			if err := scanner.PingContext(context.Background()); err != nil {
+				t.Fatalf("取消后 Ping 失败: %v", err)
+			}
+		})
+	}
+}
+
+func TestClientInvalidAddress(t *testing.T) {
+	client := NewClient("udp://127.0.0.1:3310")
+	if err := client.Ping(); err == nil {
+		t.Fatal("无效地址应返回错误")
+	}
+}
+
+func TestStreamVerdict(t *testing.T) {
+	verdict, err := streamVerdict("stream: Sig-1 FOUND\x00stream: Sig-2 FOUND\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verdict.Infected() || !reflect.DeepEqual(verdict.Signatures, []string{"Sig-1", "Sig-2"}) || verdict.Path != "stream" {
+		t.Errorf("streamVerdict = %+v", verdict)
+	}
+
+	verdict, err = streamVerdict("stream: INSTREAM size limit exceeded. ERROR\x00")
+	if err != nil || verdict.Status != StatusError || verdict.Error != "INSTREAM size limit exceeded" {
+		t.Errorf("streamVerdict = %+v, %v", verdict, err)
+	}
+
+	if _, err := streamVerdict(""); err == nil {
+		t.Error("空回复没有返回错误")
+	}
+}
+
+func TestScanStreamMultipleReplies(t *testing.T) {
+	fake := newFakeClamd(t, "unix")
+
+	// 一次性连接读取到连接关闭为止，同一个流的多行回复合并为一个结论
+	verdict, err := NewClient(fake.address).ScanStream(strings.NewReader(eicarMarker + multiMatchMarker))
+	want := []string{fakeSignature, fakeSecondSignature}
+	if err != nil || !reflect.DeepEqual(verdict.Signatures, want) {
+		t.Errorf("ScanStream = %+v, %v，期望特征 %v", verdict, err, want)
+	}
+}
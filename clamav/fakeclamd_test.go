@@ -0,0 +1,287 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeVersion   = "ClamAV 1.3.1/27400/Mon Sep 30 09:30:00 2024"
+	fakeSignature = "Eicar-Test-Signature"
+	// ALLMATCHSCAN 时额外命中的特征
+	fakeSecondSignature = "Win.Test.EICAR_HDB-1"
+	eicarMarker         = "EICAR-STANDARD-ANTIVIRUS-TEST-FILE"
+	// 流中同时包含该标记时，INSTREAM 返回每个命中的特征各一行
+	multiMatchMarker = "ALL-MATCHES"
+	slowScanDelay    = 2 * time.Second // 路径包含 "slow" 时的扫描耗时
+)
+
+// fakeStats 是 STATS 的回复：队列中有一条等待的命令，heap 等内存统计不可用
+const fakeStats = "POOLS: 1\n\nSTATE: VALID PRIMARY\nTHREADS: live 4  idle 0 max 4 idle-timeout 30\nQUEUE: 1 items\n" +
+	"\tINSTREAM 0.250000 \n\tSTATS 0.000066 \n\tINSTREAM 1.500000 /tmp/clamd-instream\n\n" +
+	"MEMSTATS: heap N/A mmap N/A used N/A free N/A releasable N/A pools 1 pools_used 565.778M pools_total 565.807M\nEND"
+
+// fakeCommands 是 VERSIONCOMMANDS 报告的命令
+var fakeCommands = []string{"SCAN", "QUIT", "RELOAD", "PING", "CONTSCAN", "VERSIONCOMMANDS", "VERSION", "END", "SHUTDOWN",
+	"MULTISCAN", "FILDES", "STATS", "IDSESSION", "INSTREAM", "DETSTATSCLEAR", "DETSTATS", "ALLMATCHSCAN"}
+
+// fakeClamd 是一个实现了部分clamd协议的测试服务器
+type fakeClamd struct {
+	t        *testing.T
+	listener net.Listener
+	address  string // 传给 NewClient 的地址
+
+	mu       sync.Mutex
+	conns    int             // 已接受的连接数
+	commands []string        // 收到的命令（不含前缀）
+	disabled map[string]bool // 模拟旧版本clamd不支持的命令
+
+	versionCommandsReply string // 非空时替换 VERSIONCOMMANDS 的回复
+}
+
+// newFakeClamd 在指定网络上启动测试服务器，network 为 "unix" 或 "tcp"
+func newFakeClamd(t *testing.T, network string) *fakeClamd {
+	t.Helper()
+
+	var (
+		listener net.Listener
+		address  string
+		err      error
+	)
+	switch network {
+	case "unix":
+		path := filepath.Join(t.TempDir(), "clamd.sock")
+		listener, err = net.Listen("unix", path)
+		address = "unix://" + path
+	case "tcp":
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		if err == nil {
+			address = "tcp://" + listener.Addr().String()
+		}
+	default:
+		t.Fatalf("不支持的网络类型: %s", network)
+	}
+	if err != nil {
+		t.Fatalf("启动测试clamd失败: %v", err)
+	}
+
+	f := &fakeClamd{t: t, listener: listener, address: address}
+	t.Cleanup(func() { listener.Close() })
+	go f.serve()
+
+	return f
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns++
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+// disable 使服务器像旧版本clamd一样不支持指定的命令
+func (f *fakeClamd) disable(commands ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.disabled == nil {
+		f.disabled = make(map[string]bool)
+	}
+	for _, cmd := range commands {
+		f.disabled[cmd] = true
+	}
+}
+
+// supports 判断服务器是否支持 cmd（只看命令名）
+func (f *fakeClamd) supports(cmd string) bool {
+	name, _, _ := strings.Cut(cmd, " ")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.disabled[name]
+}
+
+// connCount 返回已接受的连接数
+func (f *fakeClamd) connCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.conns
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	cmd, delim, err := readCommand(reader)
+	if err != nil {
+		return
+	}
+
+	if cmd != "IDSESSION" || !f.supports(cmd) {
+		if reply, ok := f.execute(cmd, reader); ok {
+			writeReply(conn, "", reply, delim)
+		}
+		return
+	}
+
+	// IDSESSION 模式下按收到命令的顺序编号回复
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for id := 1; ; id++ {
+		cmd, delim, err := readCommand(reader)
+		if err != nil || cmd == "END" {
+			return
+		}
+		if cmd == "INSTREAM" {
+			// 数据块必须在读取下一条命令前读完
+			reply, _ := f.execute(cmd, reader)
+			writeMu.Lock()
+			writeReply(conn, fmt.Sprintf("%d: ", id), reply, delim)
+			writeMu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(id int, cmd string, delim byte) {
+			defer wg.Done()
+			reply, ok := f.execute(cmd, nil)
+			if !ok {
+				return
+			}
+			writeMu.Lock()
+			writeReply(conn, fmt.Sprintf("%d: ", id), reply, delim)
+			writeMu.Unlock()
+		}(id, cmd, delim)
+	}
+}
+
+// execute 执行单条命令并返回响应
+func (f *fakeClamd) execute(cmd string, reader *bufio.Reader) (string, bool) {
+	f.mu.Lock()
+	f.commands = append(f.commands, cmd)
+	f.mu.Unlock()
+
+	switch {
+	case !f.supports(cmd):
+		return "UNKNOWN COMMAND", true
+	case cmd == "PING":
+		return "PONG", true
+	case cmd == "VERSION":
+		return fakeVersion, true
+	case cmd == "VERSIONCOMMANDS":
+		f.mu.Lock()
+		reply := f.versionCommandsReply
+		f.mu.Unlock()
+		if reply != "" {
+			return reply, true
+		}
+		commands := slices.DeleteFunc(slices.Clone(fakeCommands), func(c string) bool { return !f.supports(c) })
+		return fakeVersion + "| COMMANDS: " + strings.Join(commands, " "), true
+	case cmd == "RELOAD":
+		return "RELOADING", true
+	case cmd == "STATS":
+		return fakeStats, true
+	case cmd == "SHUTDOWN":
+		return "", false
+	case strings.HasPrefix(cmd, "SCAN "):
+		path := strings.TrimPrefix(cmd, "SCAN ")
+		if strings.Contains(path, "slow") {
+			time.Sleep(slowScanDelay)
+		}
+		if strings.Contains(path, "dir") {
+			return path + "/eicar.com: " + fakeSignature + " FOUND", true
+		}
+		if strings.Contains(path, "eicar") {
+			return path + ": " + fakeSignature + " FOUND", true
+		}
+		return path + ": OK", true
+	case strings.HasPrefix(cmd, "CONTSCAN "), strings.HasPrefix(cmd, "MULTISCAN "), strings.HasPrefix(cmd, "ALLMATCHSCAN "):
+		name, path, _ := strings.Cut(cmd, " ")
+		if !strings.Contains(path, "dir") {
+			return path + ": OK", true
+		}
+		// 目录中的每个被感染或无法扫描的文件各返回一行
+		lines := []string{path + "/eicar.com: " + fakeSignature + " FOUND"}
+		if name == "ALLMATCHSCAN" {
+			lines = append(lines, path+"/eicar.com: "+fakeSecondSignature+" FOUND")
+		}
+		lines = append(lines, path+"/locked: lstat() failed: Permission denied. ERROR")
+		return strings.Join(lines, "\n"), true
+	case cmd == "INSTREAM":
+		data, err := readChunks(reader)
+		if err != nil {
+			return "INSTREAM size limit exceeded. ERROR", true
+		}
+		if bytes.Contains(data, []byte(eicarMarker)) && bytes.Contains(data, []byte(multiMatchMarker)) {
+			return "stream: " + fakeSignature + " FOUND\nstream: " + fakeSecondSignature + " FOUND", true
+		}
+		if bytes.Contains(data, []byte(eicarMarker)) {
+			return "stream: " + fakeSignature + " FOUND", true
+		}
+		return "stream: OK", true
+	default:
+		return "UNKNOWN COMMAND", true
+	}
+}
+
+// writeReply 写入响应，多行响应的每一行都带有 prefix 并以 delim 结束
+func writeReply(w io.Writer, prefix, reply string, delim byte) {
+	for _, line := range strings.Split(reply, "\n") {
+		fmt.Fprintf(w, "%s%s%c", prefix, line, delim)
+	}
+}
+
+// readCommand 读取一条命令，返回去掉前缀后的命令及响应分隔符
+func readCommand(reader *bufio.Reader) (string, byte, error) {
+	prefix, err := reader.Peek(1)
+	if err != nil {
+		return "", 0, err
+	}
+
+	delim := byte('\n')
+	switch prefix[0] {
+	case 'z':
+		delim = 0
+		reader.Discard(1)
+	case 'n':
+		reader.Discard(1)
+	}
+
+	line, err := reader.ReadString(delim)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return strings.TrimSuffix(line, string(delim)), delim, nil
+}
+
+// readChunks 读取 INSTREAM 数据块直到长度为0的结束标记
+func readChunks(reader *bufio.Reader) ([]byte, error) {
+	var data bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, reader, int64(size)); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// ScanMode 表示扫描clamd所在主机上的路径时使用的命令
+type ScanMode string
+
+const (
+	ModeScan         ScanMode = "scan"         // SCAN：扫描目录时遇到第一个威胁即停止
+	ModeContScan     ScanMode = "contscan"     // CONTSCAN：发现威胁后继续扫描目录中的其他文件
+	ModeMultiScan    ScanMode = "multiscan"    // MULTISCAN：使用clamd的多个线程并行扫描目录
+	ModeAllMatchScan ScanMode = "allmatchscan" // ALLMATCHSCAN：继续扫描并报告每个文件命中的所有特征
+)
+
+// ParseScanMode 解析扫描模式（不区分大小写），为空时为 ModeScan
+func ParseScanMode(s string) (ScanMode, error) {
+	switch mode := ScanMode(strings.ToLower(s)); mode {
+	case "":
+		return ModeScan, nil
+	case ModeScan, ModeContScan, ModeMultiScan, ModeAllMatchScan:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("不支持的扫描模式 '%s'，可用的模式: scan、contscan、multiscan、allmatchscan", s)
+	}
+}
+
+// command 返回扫描模式对应的clamd命令
+func (m ScanMode) command() string {
+	return strings.ToUpper(string(m))
+}
+
+// PathScanner 由能够扫描clamd所在主机上的文件或目录的扫描器实现
+type PathScanner interface {
+	// ScanPathContext 按 mode 扫描 path，返回clamd报告的每个文件的结论
+	ScanPathContext(ctx context.Context, path string, mode ScanMode) ([]Verdict, error)
+}
+
+// ScanPathContext 扫描clamd所在主机上的文件或目录
+//
+// 扫描目录时clamd会返回多行回复，因此始终使用一次性连接并读取到连接关闭为止。
+// clamd 不支持 ALLMATCHSCAN 或 MULTISCAN 时改用 CONTSCAN。
+func (c *Client) ScanPathContext(ctx context.Context, path string, mode ScanMode) ([]Verdict, error) {
+	if path == "" || strings.ContainsAny(path, "\x00\r\n") {
+		return nil, errors.New("无效的扫描路径")
+	}
+	if mode == "" {
+		mode = ModeScan
+	}
+	if (mode == ModeAllMatchScan || mode == ModeMultiScan) && !c.supports(ctx, mode.command()) {
+		// 旧版本clamd不支持时改用 CONTSCAN，同样会扫描目录中的所有文件
+		mode = ModeContScan
+	}
+
+	var verdicts []Verdict
+	err := c.converse(ctx, c.timeouts.Scan, func(conn net.Conn) error {
+		if _, err := fmt.Fprintf(conn, "n%s %s\n", mode.command(), path); err != nil {
+			return fmt.Errorf("发送%s命令失败: %v", mode.command(), err)
+		}
+
+		reply, err := io.ReadAll(conn)
+		if err != nil {
+			return fmt.Errorf("读取扫描结果失败: %v", err)
+		}
+
+		verdicts = ParseVerdicts(string(reply))
+		if len(verdicts) == 0 {
+			return errors.New("clamd 没有返回扫描结果")
+		}
+		return nil
+	})
+
+	return verdicts, err
+}
+
+// ScanPathContext 扫描clamd所在主机上的文件或目录
+//
+// 会话中无法区分多行回复的结束位置，使用一次性连接执行。
+func (p *Pool) ScanPathContext(ctx context.Context, path string, mode ScanMode) ([]Verdict, error) {
+	return p.client.ScanPathContext(ctx, path, mode)
+}
+
+// ScanPathContext 在一个可用后端上扫描文件或目录，后端失败时换一个后端重试
+//
+// 各后端需要能够访问相同的路径。
+func (b *Balancer) ScanPathContext(ctx context.Context, path string, mode ScanMode) ([]Verdict, error) {
+	var verdicts []Verdict
+	err := b.do(ctx, func(be *backend) error {
+		scanner, ok := be.Scanner.(PathScanner)
+		if !ok {
+			return errors.New("后端不支持路径扫描")
+		}
+		var err error
+		verdicts, err = scanner.ScanPathContext(ctx, path, mode)
+		return err
+	})
+	return verdicts, err
+}
+
+// ParseVerdicts 解析clamd的多行扫描回复，每个文件返回一个结论
+//
+// ALLMATCHSCAN 对同一个文件命中的每个特征各返回一行，相邻的同一文件的行会合并为一个结论。
+func ParseVerdicts(reply string) []Verdict {
+	var verdicts []Verdict
+	for _, line := range strings.FieldsFunc(reply, func(r rune) bool { return r == '\n' || r == '\x00' }) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		v := ParseVerdict("", line)
+		if n := len(verdicts); n > 0 && v.Infected() && verdicts[n-1].Infected() && verdicts[n-1].Path == v.Path {
+			last := &verdicts[n-1]
+			last.Signatures = append(last.Signatures, v.Signatures...)
+			last.Raw += "\n" + v.Raw
+			continue
+		}
+		verdicts = append(verdicts, v)
+	}
+	return verdicts
+}
@@ -0,0 +1,88 @@
+package clamav
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseVerdicts(t *testing.T) {
+	reply := "/data/dir/a.exe: Sig-1 FOUND\n" +
+		"/data/dir/a.exe: Sig-2 FOUND\n" +
+		"/data/dir/b: lstat() failed: Permission denied. ERROR\n" +
+		"/data/dir/c.exe: Sig-1 FOUND\n\n"
+
+	got := ParseVerdicts(reply)
+	want := []Verdict{
+		{Path: "/data/dir/a.exe", Status: StatusInfected, Signatures: []string{"Sig-1", "Sig-2"},
+			Raw: "/data/dir/a.exe: Sig-1 FOUND\n/data/dir/a.exe: Sig-2 FOUND"},
+		{Path: "/data/dir/b", Status: StatusError, Error: "lstat() failed: Permission denied",
+			Raw: "/data/dir/b: lstat() failed: Permission denied. ERROR"},
+		{Path: "/data/dir/c.exe", Status: StatusInfected, Signatures: []string{"Sig-1"},
+			Raw: "/data/dir/c.exe: Sig-1 FOUND"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseVerdicts = %+v，期望 %+v", got, want)
+	}
+
+	if got := ParseVerdicts("/data/dir: OK\x00"); len(got) != 1 || !got[0].Clean() || got[0].Path != "/data/dir" {
+		t.Errorf("ParseVerdicts = %+v", got)
+	}
+}
+
+func TestParseScanMode(t *testing.T) {
+	for input, want := range map[string]ScanMode{"": ModeScan, "CONTSCAN": ModeContScan, "multiscan": ModeMultiScan, "AllMatchScan": ModeAllMatchScan} {
+		if got, err := ParseScanMode(input); err != nil || got != want {
+			t.Errorf("ParseScanMode(%q) = %q, %v，期望 %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseScanMode("instream"); err == nil {
+		t.Error("不支持的模式没有返回错误")
+	}
+}
+
+func TestScanPath(t *testing.T) {
+	for name, factory := range scannerFactories() {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeClamd(t, "unix")
+			scanner, closeScanner := factory(fake.address)
+			defer closeScanner()
+			pathScanner := scanner.(PathScanner)
+
+			tests := []struct {
+				mode     ScanMode
+				verdicts int
+				sigs     int
+			}{
+				{ModeScan, 1, 1},
+				{ModeContScan, 2, 1},
+				{ModeMultiScan, 2, 1},
+				{ModeAllMatchScan, 2, 2},
+			}
+			for _, tt := range tests {
+				verdicts, err := pathScanner.ScanPathContext(context.Background(), "/data/dir", tt.mode)
+				if err != nil {
+					t.Fatalf("%s 失败: %v", tt.mode, err)
+				}
+				if len(verdicts) != tt.verdicts {
+					t.Fatalf("%s 返回 %d 个结论，期望 %d: %+v", tt.mode, len(verdicts), tt.verdicts, verdicts)
+				}
+				if v := verdicts[0]; !v.Infected() || v.Path != "/data/dir/eicar.com" || len(v.Signatures) != tt.sigs {
+					t.Errorf("%s verdicts[0] = %+v", tt.mode, v)
+				}
+				if tt.verdicts > 1 && verdicts[1].Status != StatusError {
+					t.Errorf("%s verdicts[1] = %+v", tt.mode, verdicts[1])
+				}
+			}
+
+			verdicts, err := pathScanner.ScanPathContext(context.Background(), "/data/report.pdf", ModeContScan)
+			if err != nil || len(verdicts) != 1 || !verdicts[0].Clean() {
+				t.Errorf("扫描文件 = %+v, %v", verdicts, err)
+			}
+
+			if _, err := pathScanner.ScanPathContext(context.Background(), "/data/a\nPING", ModeScan); err == nil {
+				t.Error("包含换行的路径没有返回错误")
+			}
+		})
+	}
+}
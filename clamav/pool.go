@@ -0,0 +1,575 @@
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/metrics"
+)
+
+// errPoolClosed 表示连接池已关闭
+var errPoolClosed = errors.New("ClamAV连接池已关闭")
+
+// PoolOptions 连接池配置
+type PoolOptions struct {
+	MaxSessions int           // 最大会话（长连接）数
+	MaxInflight int           // 单个会话上同时进行的命令数
+	IdleTimeout time.Duration // 会话空闲超过该时间后关闭，应小于 clamd 的 IdleTimeout
+	Timeouts    Timeouts      // 连接及命令超时
+}
+
+// DefaultPoolOptions 返回默认的连接池配置
+func DefaultPoolOptions() PoolOptions {
+	return PoolOptions{
+		MaxSessions: 4,
+		MaxInflight: 4,
+		IdleTimeout: 20 * time.Second,
+	}
+}
+
+// Pool 基于 zIDSESSION 长连接的ClamAV客户端，在会话上按请求ID复用命令
+type Pool struct {
+	opts   PoolOptions
+	client *Client // 负责拨号；RELOAD、SHUTDOWN 不允许在会话中执行，使用一次性连接
+
+	mu       sync.Mutex
+	sessions []*session
+	dialing  int           // 正在建立的会话数，计入 MaxSessions
+	dialed   chan struct{} // 每次建立会话结束（无论成功与否）时关闭并替换
+	slots    chan struct{} // 限制同时进行的命令总数
+	closed   bool
+	done     chan struct{}
+}
+
+// NewPool 创建一个新的ClamAV连接池，address 格式与 NewClient 相同
+func NewPool(address string, opts PoolOptions) *Pool {
+	defaults := DefaultPoolOptions()
+	if opts.MaxSessions <= 0 {
+		opts.MaxSessions = defaults.MaxSessions
+	}
+	if opts.MaxInflight <= 0 {
+		opts.MaxInflight = defaults.MaxInflight
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaults.IdleTimeout
+	}
+
+	p := &Pool{
+		opts:   opts,
+		client: newClient(address, opts.Timeouts),
+		dialed: make(chan struct{}),
+		slots:  make(chan struct{}, opts.MaxSessions*opts.MaxInflight),
+		done:   make(chan struct{}),
+	}
+	go p.reapIdle()
+
+	return p
+}
+
+// GetVersion 获取ClamAV版本信息
+func (p *Pool) GetVersion() (string, error) {
+	return p.GetVersionContext(context.Background())
+}
+
+// GetVersionContext 获取ClamAV版本信息
+func (p *Pool) GetVersionContext(ctx context.Context) (string, error) {
+	if !p.sessionsSupported(ctx) {
+		return p.client.GetVersionContext(ctx)
+	}
+	return p.command(ctx, "VERSION", p.client.timeouts.Command)
+}
+
+// Ping 检查clamd是否正在运行
+func (p *Pool) Ping() error {
+	return p.PingContext(context.Background())
+}
+
+// PingContext 检查clamd是否正在运行
+func (p *Pool) PingContext(ctx context.Context) error {
+	if !p.sessionsSupported(ctx) {
+		return p.client.PingContext(ctx)
+	}
+
+	response, err := p.command(ctx, "PING", p.client.timeouts.Command)
+	if err != nil {
+		return err
+	}
+
+	if response != "PONG" {
+		return fmt.Errorf("未收到预期的PONG响应: %s", response)
+	}
+
+	return nil
+}
+
+// Reload 重新加载病毒数据库
+func (p *Pool) Reload() error {
+	return p.client.Reload()
+}
+
+// ReloadContext 重新加载病毒数据库
+func (p *Pool) ReloadContext(ctx context.Context) error {
+	return p.client.ReloadContext(ctx)
+}
+
+// Shutdown 关闭clamd服务
+func (p *Pool) Shutdown() error {
+	return p.client.Shutdown()
+}
+
+// ScanFile 扫描单个文件
+func (p *Pool) ScanFile(filePath string) (Verdict, error) {
+	return p.ScanFileContext(context.Background(), filePath)
+}
+
+// ScanFileContext 扫描单个文件
+func (p *Pool) ScanFileContext(ctx context.Context, filePath string) (Verdict, error) {
+	if !p.sessionsSupported(ctx) {
+		return p.client.ScanFileContext(ctx, filePath)
+	}
+
+	response, err := p.command(ctx, "SCAN "+filePath, p.client.timeouts.Scan)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	return ParseVerdict(filePath, response), nil
+}
+
+// ScanStream 扫描文件流
+func (p *Pool) ScanStream(reader io.Reader) (Verdict, error) {
+	return p.ScanStreamContext(context.Background(), reader)
+}
+
+// ScanStreamContext 扫描文件流
+func (p *Pool) ScanStreamContext(ctx context.Context, reader io.Reader) (Verdict, error) {
+	if !p.sessionsSupported(ctx) {
+		return p.client.ScanStreamContext(ctx, reader)
+	}
+	if !p.client.supports(ctx, "INSTREAM") {
+		return Verdict{}, unsupported("INSTREAM")
+	}
+
+	s, err := p.acquire(ctx)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer p.release(s)
+
+	// 文件流只能发送一次，会话失效时不重试
+	response, err := s.do(ctx, "INSTREAM", func(w io.Writer) error {
+		return writeStream(w, reader)
+	}, p.client.timeouts.Scan)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	return ParseVerdict("stream", response), nil
+}
+
+// Close 关闭连接池及其所有会话
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	sessions := p.sessions
+	p.sessions = nil
+	close(p.done)
+	p.mu.Unlock()
+
+	for _, s := range sessions {
+		s.end()
+	}
+
+	return nil
+}
+
+// sessionsSupported 判断clamd是否支持 IDSESSION，不支持时各命令改用一次性连接
+func (p *Pool) sessionsSupported(ctx context.Context) bool {
+	return p.client.supports(ctx, "IDSESSION")
+}
+
+// command 在会话中执行不带数据的命令，会话失效时换一个会话重试一次
+func (p *Pool) command(ctx context.Context, cmd string, timeout time.Duration) (string, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		s, err := p.acquire(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		response, err := s.do(ctx, cmd, nil, timeout)
+		p.release(s)
+		if err == nil {
+			return response, nil
+		}
+
+		lastErr = err
+		if ctx.Err() != nil || !s.isBroken() {
+			break
+		}
+	}
+
+	return "", lastErr
+}
+
+// acquire 获取一个可用会话，必要时建立新会话；池满时阻塞等待
+//
+// 建立会话时不持有 p.mu，clamd 连接缓慢时不会阻塞其他命令和空闲会话的清理。
+func (p *Pool) acquire(ctx context.Context) (*session, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.done:
+		return nil, errPoolClosed
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	}
+
+	var dialErr error
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, errPoolClosed
+		}
+
+		best := p.leastLoaded()
+		open := len(p.sessions) + p.dialing
+		// 有空闲会话、会话数已达上限或刚刚建立会话失败时使用现有会话
+		if best != nil && (best.inflight == 0 || open >= p.opts.MaxSessions || dialErr != nil) {
+			best.inflight++
+			p.mu.Unlock()
+			return best, nil
+		}
+		if dialErr != nil {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, dialErr
+		}
+		if open >= p.opts.MaxSessions {
+			// 所有会话都已满，等待正在建立的会话
+			dialed := p.dialed
+			p.mu.Unlock()
+			select {
+			case <-dialed:
+				continue
+			case <-p.done:
+				<-p.slots
+				return nil, errPoolClosed
+			case <-ctx.Done():
+				<-p.slots
+				return nil, contextError(ctx)
+			}
+		}
+
+		// 预留会话数后再建立连接
+		p.dialing++
+		p.mu.Unlock()
+
+		s, err := dialSession(ctx, p.client)
+
+		p.mu.Lock()
+		p.dialing--
+		close(p.dialed)
+		p.dialed = make(chan struct{})
+		if err != nil {
+			p.mu.Unlock()
+			dialErr = err
+			continue
+		}
+		if p.closed {
+			p.mu.Unlock()
+			s.end()
+			<-p.slots
+			return nil, errPoolClosed
+		}
+		s.inflight++
+		p.sessions = append(p.sessions, s)
+		p.mu.Unlock()
+		return s, nil
+	}
+}
+
+// leastLoaded 清理已失效的会话，返回未满的会话中负载最小的一个，调用时必须持有 p.mu
+func (p *Pool) leastLoaded() *session {
+	var best *session
+	live := p.sessions[:0]
+	for _, s := range p.sessions {
+		if s.isBroken() {
+			continue
+		}
+		live = append(live, s)
+		if s.inflight < p.opts.MaxInflight && (best == nil || s.inflight < best.inflight) {
+			best = s
+		}
+	}
+	p.sessions = live
+	return best
+}
+
+// release 归还会话
+func (p *Pool) release(s *session) {
+	p.mu.Lock()
+	s.inflight--
+	s.lastUsed = time.Now()
+	p.mu.Unlock()
+	<-p.slots
+}
+
+// reapIdle 定期关闭空闲超时的会话，避免被clamd主动断开
+func (p *Pool) reapIdle() {
+	ticker := time.NewTicker(p.opts.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		var idle []*session
+		p.mu.Lock()
+		live := p.sessions[:0]
+		for _, s := range p.sessions {
+			if s.inflight == 0 && (s.isBroken() || time.Since(s.lastUsed) > p.opts.IdleTimeout) {
+				idle = append(idle, s)
+				continue
+			}
+			live = append(live, s)
+		}
+		p.sessions = live
+		p.mu.Unlock()
+
+		for _, s := range idle {
+			s.end()
+		}
+	}
+}
+
+// sessionReply 表示会话中一条命令的响应
+type sessionReply struct {
+	text string
+	err  error
+}
+
+// session 表示一个 zIDSESSION 长连接
+type session struct {
+	conn     net.Conn
+	writeMu  sync.Mutex // 保证命令按顺序完整写入，使请求ID与clamd的编号一致
+	inflight int        // 由 Pool.mu 保护
+	lastUsed time.Time  // 由 Pool.mu 保护
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan sessionReply
+	err     error // 非空表示会话已失效
+}
+
+// dialSession 建立连接并进入 IDSESSION 模式
+func dialSession(ctx context.Context, client *Client) (*session, error) {
+	conn, err := client.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(client.timeouts.Dial))
+	if _, err := conn.Write([]byte("zIDSESSION\x00")); err != nil {
+		conn.Close()
+		metrics.ClamdConnectionErrors.Inc(client.address)
+		return nil, fmt.Errorf("发送IDSESSION命令失败: %v", err)
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	s := &session{
+		conn:     conn,
+		lastUsed: time.Now(),
+		pending:  make(map[uint64]chan sessionReply),
+	}
+	go s.readLoop()
+
+	return s, nil
+}
+
+// do 在会话中发送一条命令并等待对应ID的响应
+//
+// 写入过程中 context 被取消时会话无法继续使用，直接关闭；等待响应时被取消则放弃该响应，
+// 若会话上已没有其他命令则关闭会话，使clamd中止扫描。
+func (s *session) do(ctx context.Context, cmd string, payload func(io.Writer) error, timeout time.Duration) (string, error) {
+	ch := make(chan sessionReply, 1)
+
+	s.writeMu.Lock()
+	s.mu.Lock()
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		s.writeMu.Unlock()
+		return "", err
+	}
+	s.nextID++
+	id := s.nextID
+	s.pending[id] = ch
+	s.mu.Unlock()
+
+	until := deadline(ctx, timeout)
+	err := s.write(ctx, cmd, payload, until)
+	s.writeMu.Unlock()
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+		s.fail(err)
+		return "", err
+	}
+
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+
+	select {
+	case reply := <-ch:
+		return reply.text, reply.err
+	case <-timer.C:
+		// 无法确定clamd何时响应，放弃整个会话
+		err := contextError(ctx)
+		if err == nil {
+			err = fmt.Errorf("等待%s响应超时", cmd)
+		}
+		s.fail(err)
+		return "", err
+	case <-ctx.Done():
+		err := contextError(ctx)
+		s.abandon(id, err)
+		return "", err
+	}
+}
+
+// abandon 放弃等待指定ID的响应，会话空闲时直接关闭
+func (s *session) abandon(id uint64, err error) {
+	s.mu.Lock()
+	delete(s.pending, id)
+	idle := len(s.pending) == 0
+	s.mu.Unlock()
+
+	if idle {
+		s.fail(err)
+	}
+}
+
+// write 写入命令及可选的数据，context 取消时中断写入
+func (s *session) write(ctx context.Context, cmd string, payload func(io.Writer) error, until time.Time) error {
+	if err := s.conn.SetWriteDeadline(until); err != nil {
+		return fmt.Errorf("设置超时失败: %v", err)
+	}
+	stop := context.AfterFunc(ctx, func() { s.conn.SetWriteDeadline(time.Unix(1, 0)) })
+	defer func() {
+		if stop() {
+			s.conn.SetWriteDeadline(time.Time{})
+		}
+	}()
+
+	w := bufio.NewWriter(s.conn)
+	if _, err := w.WriteString("z" + cmd + "\x00"); err != nil {
+		return fmt.Errorf("发送%s命令失败: %v", cmd, err)
+	}
+	if payload != nil {
+		if err := payload(w); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("发送%s命令失败: %v", cmd, err)
+	}
+
+	return nil
+}
+
+// readLoop 读取会话响应并按请求ID分发
+func (s *session) readLoop() {
+	reader := bufio.NewReader(s.conn)
+	for {
+		line, err := reader.ReadString('\x00')
+		if err != nil {
+			s.fail(fmt.Errorf("读取会话响应失败: %v", err))
+			return
+		}
+
+		id, text, ok := parseSessionReply(line)
+		if !ok {
+			s.fail(fmt.Errorf("无法解析会话响应: %q", line))
+			return
+		}
+
+		s.mu.Lock()
+		ch, exists := s.pending[id]
+		delete(s.pending, id)
+		s.mu.Unlock()
+
+		if exists {
+			ch <- sessionReply{text: text}
+		}
+	}
+}
+
+// parseSessionReply 解析 "<id>: <响应>\x00" 格式的会话响应
+func parseSessionReply(line string) (uint64, string, bool) {
+	line = strings.TrimSuffix(line, "\x00")
+	idPart, text, found := strings.Cut(line, ": ")
+	if !found {
+		return 0, "", false
+	}
+
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return id, strings.TrimSpace(text), true
+}
+
+// fail 将会话标记为失效，关闭连接并通知所有等待中的命令
+func (s *session) fail(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	pending := s.pending
+	s.pending = make(map[uint64]chan sessionReply)
+	s.mu.Unlock()
+
+	s.conn.Close()
+	for _, ch := range pending {
+		ch <- sessionReply{err: err}
+	}
+}
+
+// isBroken 判断会话是否已失效
+func (s *session) isBroken() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil
+}
+
+// end 发送 END 结束会话并关闭连接
+func (s *session) end() {
+	s.writeMu.Lock()
+	if !s.isBroken() {
+		s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		s.conn.Write([]byte("zEND\x00"))
+	}
+	s.writeMu.Unlock()
+	s.fail(errors.New("会话已结束"))
+}
@@ -0,0 +1,130 @@
+package clamav
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolReusesSessions(t *testing.T) {
+	fake := newFakeClamd(t, "unix")
+	pool := NewPool(fake.address, PoolOptions{MaxSessions: 2, MaxInflight: 4})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				errs <- pool.Ping()
+				return
+			}
+			_, err := pool.ScanStream(strings.NewReader("data"))
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("并发命令失败: %v", err)
+		}
+	}
+
+	// 所有命令应复用最多两个会话连接，另有一个连接用于首次的 VERSIONCOMMANDS
+	if n := fake.connCount(); n > 3 {
+		t.Errorf("建立了 %d 个连接，期望不超过 3 个", n)
+	}
+}
+
+func TestPoolRecoversBrokenSession(t *testing.T) {
+	fake := newFakeClamd(t, "tcp")
+	pool := NewPool(fake.address, PoolOptions{MaxSessions: 1, MaxInflight: 1})
+	defer pool.Close()
+
+	if err := pool.Ping(); err != nil {
+		t.Fatalf("Ping 失败: %v", err)
+	}
+
+	// 模拟clamd断开会话
+	pool.mu.Lock()
+	for _, s := range pool.sessions {
+		s.conn.Close()
+	}
+	pool.mu.Unlock()
+
+	if err := pool.Ping(); err != nil {
+		t.Fatalf("会话断开后 Ping 失败: %v", err)
+	}
+	// 两个会话连接，加上首次查询 VERSIONCOMMANDS 的一次性连接
+	if n := fake.connCount(); n != 3 {
+		t.Errorf("建立了 %d 个连接，期望 3 个", n)
+	}
+}
+
+func TestPoolReusesIdleSession(t *testing.T) {
+	fake := newFakeClamd(t, "unix")
+	pool := NewPool(fake.address, PoolOptions{MaxSessions: 4})
+	defer pool.Close()
+
+	// 依次执行的命令都复用第一个会话，不会建立新的会话
+	for i := 0; i < 10; i++ {
+		if err := pool.Ping(); err != nil {
+			t.Fatalf("第 %d 次 Ping 失败: %v", i+1, err)
+		}
+	}
+	pool.mu.Lock()
+	sessions := len(pool.sessions)
+	pool.mu.Unlock()
+	if sessions != 1 {
+		t.Errorf("会话数 = %d，期望 1", sessions)
+	}
+	// 一个会话连接，加上首次查询 VERSIONCOMMANDS 的一次性连接
+	if n := fake.connCount(); n != 2 {
+		t.Errorf("建立了 %d 个连接，期望 2 个", n)
+	}
+}
+
+func TestPoolReapsIdleSessions(t *testing.T) {
+	fake := newFakeClamd(t, "unix")
+	pool := NewPool(fake.address, PoolOptions{MaxSessions: 1, IdleTimeout: 50 * time.Millisecond})
+	defer pool.Close()
+
+	if err := pool.Ping(); err != nil {
+		t.Fatalf("Ping 失败: %v", err)
+	}
+	pool.mu.Lock()
+	s := pool.sessions[0]
+	pool.mu.Unlock()
+
+	// 空闲超时后会话被关闭并移出连接池
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		pool.mu.Lock()
+		sessions := len(pool.sessions)
+		pool.mu.Unlock()
+		if sessions == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("空闲会话没有被关闭")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !s.isBroken() {
+		t.Error("被回收的会话仍然可用")
+	}
+
+	// 之后的命令建立新的会话
+	before := fake.connCount()
+	if err := pool.Ping(); err != nil {
+		t.Fatalf("回收后 Ping 失败: %v", err)
+	}
+	if n := fake.connCount(); n != before+1 {
+		t.Errorf("回收后建立了 %d 个连接，期望 1 个", n-before)
+	}
+}
@@ -0,0 +1,320 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SmallGaoX/clamd-api/metrics"
+)
+
+// Stats 是解析后的 STATS 回复
+//
+// clamd 的回复格式为：
+//
+//	POOLS: 1
+//
+//	STATE: VALID PRIMARY
+//	THREADS: live 1  idle 0 max 12 idle-timeout 30
+//	QUEUE: 0 items
+//		STATS 0.000066
+//
+//	MEMSTATS: heap 4.816M mmap 0.129M used 3.622M free 1.194M releasable 0.071M pools 1 pools_used 565.778M pools_total 565.807M
+//	END
+//
+// 有多个线程池时，线程数和队列为所有线程池之和。
+type Stats struct {
+	Pools   int         `json:"pools"`           // 线程池数
+	State   string      `json:"state"`           // 主线程池的状态，例如 "VALID PRIMARY"
+	Threads ThreadStats `json:"threads"`         // 线程数
+	Queue   QueueStats  `json:"queue"`           // 等待执行的命令
+	Tasks   []Task      `json:"tasks,omitempty"` // 正在执行的命令
+	Memory  MemoryStats `json:"memory"`          // 内存使用
+	Raw     string      `json:"-"`               // clamd 的原始回复
+}
+
+// ThreadStats 是 THREADS 行中的线程数
+type ThreadStats struct {
+	Live        int `json:"live"`        // 存在的线程数
+	Idle        int `json:"idle"`        // 空闲的线程数
+	Max         int `json:"max"`         // 线程数上限（MaxThreads）
+	IdleTimeout int `json:"idleTimeout"` // 空闲线程的退出时间（秒）
+}
+
+// QueueStats 是 QUEUE 行及其后列出的排队命令
+type QueueStats struct {
+	Length int    `json:"length"`          // 排队的命令数
+	Items  []Task `json:"items,omitempty"` // 排队的命令
+}
+
+// Task 是排队或正在执行的命令
+type Task struct {
+	Command string  `json:"command"`        // 命令名，例如 "INSTREAM"
+	Seconds float64 `json:"seconds"`        // 已排队或执行的时间（秒）
+	File    string  `json:"file,omitempty"` // 正在扫描的文件
+}
+
+// MemoryStats 是 MEMSTATS 行中的内存使用，单位为字节
+//
+// 平台不支持时 clamd 返回 N/A，对应的字段为 nil。
+type MemoryStats struct {
+	Heap       *int64 `json:"heapBytes,omitempty"`
+	Mmap       *int64 `json:"mmapBytes,omitempty"`
+	Used       *int64 `json:"usedBytes,omitempty"`
+	Free       *int64 `json:"freeBytes,omitempty"`
+	Releasable *int64 `json:"releasableBytes,omitempty"`
+	Pools      int    `json:"pools"`
+	PoolsUsed  *int64 `json:"poolsUsedBytes,omitempty"`
+	PoolsTotal *int64 `json:"poolsTotalBytes,omitempty"`
+}
+
+// ParseStats 解析 STATS 命令的回复
+func ParseStats(reply string) (Stats, error) {
+	reply = strings.TrimRight(reply, "\x00\n")
+	stats := Stats{Raw: reply}
+
+	var threads, queue, memory bool
+	queued := 0 // 当前线程池中尚未读到的排队命令数
+	for _, line := range strings.FieldsFunc(reply, func(r rune) bool { return r == '\n' || r == '\x00' }) {
+		line = strings.TrimRight(line, " ")
+
+		if task, ok := strings.CutPrefix(line, "\t"); ok {
+			parsed, err := parseTask(task)
+			if err != nil {
+				return stats, err
+			}
+			// QUEUE 行之后先列出排队的命令，再列出正在执行的命令
+			if queued > 0 {
+				stats.Queue.Items = append(stats.Queue.Items, parsed)
+				queued--
+			} else {
+				stats.Tasks = append(stats.Tasks, parsed)
+			}
+			continue
+		}
+
+		name, value, _ := strings.Cut(line, ": ")
+		var err error
+		switch name {
+		case "POOLS":
+			stats.Pools, err = strconv.Atoi(value)
+		case "STATE":
+			if stats.State == "" {
+				stats.State = strings.TrimSpace(value)
+			}
+		case "THREADS":
+			threads = true
+			err = parseFields(value, func(key, v string) error {
+				n, err := strconv.Atoi(v)
+				switch key {
+				case "live":
+					stats.Threads.Live += n
+				case "idle":
+					stats.Threads.Idle += n
+				case "max":
+					stats.Threads.Max += n
+				case "idle-timeout":
+					stats.Threads.IdleTimeout = n
+				}
+				return err
+			})
+		case "QUEUE":
+			queue = true
+			queued, err = strconv.Atoi(strings.TrimSuffix(value, " items"))
+			stats.Queue.Length += queued
+		case "MEMSTATS":
+			memory = true
+			err = parseFields(value, func(key, v string) error {
+				if key == "pools" {
+					n, err := strconv.Atoi(v)
+					stats.Memory.Pools = n
+					return err
+				}
+				bytes, err := parseMegabytes(v)
+				switch key {
+				case "heap":
+					stats.Memory.Heap = bytes
+				case "mmap":
+					stats.Memory.Mmap = bytes
+				case "used":
+					stats.Memory.Used = bytes
+				case "free":
+					stats.Memory.Free = bytes
+				case "releasable":
+					stats.Memory.Releasable = bytes
+				case "pools_used":
+					stats.Memory.PoolsUsed = bytes
+				case "pools_total":
+					stats.Memory.PoolsTotal = bytes
+				}
+				return err
+			})
+		}
+		if err != nil {
+			return stats, fmt.Errorf("无法识别的STATS回复 '%s': %v", line, err)
+		}
+	}
+
+	if !threads || !queue || !memory {
+		return stats, fmt.Errorf("STATS回复不完整: %s", reply)
+	}
+	return stats, nil
+}
+
+// parseFields 解析 "key value key value ..." 格式的字段
+func parseFields(s string, fn func(key, value string) error) error {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return errors.New("字段数量不成对")
+	}
+	for i := 0; i < len(fields); i += 2 {
+		if err := fn(fields[i], fields[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMegabytes 将 "4.816M" 解析为字节数，"N/A" 返回 nil
+func parseMegabytes(s string) (*int64, error) {
+	if s == "N/A" {
+		return nil, nil
+	}
+	value, err := strconv.ParseFloat(strings.TrimSuffix(s, "M"), 64)
+	if err != nil {
+		return nil, err
+	}
+	bytes := int64(value * 1024 * 1024)
+	return &bytes, nil
+}
+
+// parseTask 解析 "<命令> <秒数> [文件]" 格式的命令行
+func parseTask(s string) (Task, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), " ", 3)
+	if len(parts) < 2 {
+		return Task{}, fmt.Errorf("无法识别的STATS命令行: %s", s)
+	}
+	seconds, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return Task{}, fmt.Errorf("无法识别的STATS命令行: %s", s)
+	}
+	task := Task{Command: parts[0], Seconds: seconds}
+	if len(parts) == 3 {
+		task.File = parts[2]
+	}
+	return task, nil
+}
+
+// record 将统计信息写入 backend 对应的指标
+func (s Stats) record(backend string) {
+	metrics.ClamdThreads.Set(float64(s.Threads.Live), backend, "live")
+	metrics.ClamdThreads.Set(float64(s.Threads.Idle), backend, "idle")
+	metrics.ClamdThreads.Set(float64(s.Threads.Max), backend, "max")
+	metrics.ClamdQueueLength.Set(float64(s.Queue.Length), backend)
+
+	for kind, bytes := range map[string]*int64{
+		"heap":        s.Memory.Heap,
+		"mmap":        s.Memory.Mmap,
+		"used":        s.Memory.Used,
+		"free":        s.Memory.Free,
+		"releasable":  s.Memory.Releasable,
+		"pools_used":  s.Memory.PoolsUsed,
+		"pools_total": s.Memory.PoolsTotal,
+	} {
+		if bytes != nil {
+			metrics.ClamdMemoryBytes.Set(float64(*bytes), backend, kind)
+		}
+	}
+}
+
+// StatsScanner 由能够查询clamd STATS 的扫描器实现
+type StatsScanner interface {
+	StatsContext(ctx context.Context) (Stats, error)
+}
+
+// StatsContext 查询clamd的线程池、队列和内存使用
+func (c *Client) StatsContext(ctx context.Context) (Stats, error) {
+	if !c.supports(ctx, "STATS") {
+		return Stats{}, unsupported("STATS")
+	}
+
+	var stats Stats
+	err := c.converse(ctx, c.timeouts.Command, func(conn net.Conn) error {
+		if _, err := conn.Write([]byte("zSTATS\x00")); err != nil {
+			return fmt.Errorf("发送STATS命令失败: %v", err)
+		}
+
+		// 回复有多行，读取到连接关闭为止
+		reply, err := io.ReadAll(conn)
+		if err != nil {
+			return fmt.Errorf("读取STATS响应失败: %v", err)
+		}
+
+		stats, err = ParseStats(string(reply))
+		return err
+	})
+
+	return stats, err
+}
+
+// StatsContext 查询clamd的线程池、队列和内存使用
+//
+// 会话中无法区分多行回复的结束位置，使用一次性连接执行。
+func (p *Pool) StatsContext(ctx context.Context) (Stats, error) {
+	return p.client.StatsContext(ctx)
+}
+
+// BackendStats 表示单个后端的 STATS 查询结果
+type BackendStats struct {
+	Address string `json:"address"`
+	Stats   *Stats `json:"stats,omitempty"`
+	Error   string `json:"error,omitempty"` // 查询失败的原因
+}
+
+// StatsReporter 由能够查询各后端 STATS 的扫描器实现
+type StatsReporter interface {
+	BackendStats(ctx context.Context) []BackendStats
+}
+
+// BackendStats 并发查询所有后端（包括已摘除的后端）的 STATS，并更新对应的指标
+func (b *Balancer) BackendStats(ctx context.Context) []BackendStats {
+	results := make([]BackendStats, len(b.backends))
+	var wg sync.WaitGroup
+	for i, be := range b.backends {
+		results[i].Address = be.Address
+		wg.Add(1)
+		go func(result *BackendStats, be *backend) {
+			defer wg.Done()
+
+			stats, err := b.collectStats(ctx, be)
+			if err != nil {
+				result.Error = err.Error()
+				return
+			}
+			result.Stats = &stats
+		}(&results[i], be)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// collectStats 查询后端的 STATS 并更新指标
+func (b *Balancer) collectStats(ctx context.Context, be *backend) (Stats, error) {
+	scanner, ok := be.Scanner.(StatsScanner)
+	if !ok {
+		return Stats{}, errors.New("后端不支持STATS")
+	}
+
+	stats, err := scanner.StatsContext(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.record(be.Address)
+	return stats, nil
+}
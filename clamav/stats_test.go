@@ -0,0 +1,104 @@
+package clamav
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/metrics"
+)
+
+func TestParseStats(t *testing.T) {
+	reply := "POOLS: 1\n\nSTATE: VALID PRIMARY\nTHREADS: live 2  idle 1 max 12 idle-timeout 30\nQUEUE: 0 items\n" +
+		"\tSTATS 0.000066 \n\n" +
+		"MEMSTATS: heap 4.816M mmap 0.129M used 3.622M free 1.194M releasable 0.071M pools 1 pools_used 565.778M pools_total 565.807M\nEND\x00"
+
+	stats, err := ParseStats(reply)
+	if err != nil {
+		t.Fatalf("ParseStats 失败: %v", err)
+	}
+	if stats.Pools != 1 || stats.State != "VALID PRIMARY" {
+		t.Errorf("Pools = %d, State = %q", stats.Pools, stats.State)
+	}
+	if want := (ThreadStats{Live: 2, Idle: 1, Max: 12, IdleTimeout: 30}); stats.Threads != want {
+		t.Errorf("Threads = %+v，期望 %+v", stats.Threads, want)
+	}
+	if stats.Queue.Length != 0 || len(stats.Queue.Items) != 0 {
+		t.Errorf("Queue = %+v", stats.Queue)
+	}
+	if want := []Task{{Command: "STATS", Seconds: 0.000066}}; !reflect.DeepEqual(stats.Tasks, want) {
+		t.Errorf("Tasks = %+v，期望 %+v", stats.Tasks, want)
+	}
+	if stats.Memory.Heap == nil || *stats.Memory.Heap != 5049942 || stats.Memory.Pools != 1 {
+		t.Errorf("Memory = %+v", stats.Memory)
+	}
+
+	for _, bad := range []string{"", "POOLS: 1\nEND", "UNKNOWN COMMAND", strings.Replace(reply, "live 2", "live x", 1)} {
+		if _, err := ParseStats(bad); err == nil {
+			t.Errorf("ParseStats(%q) 没有返回错误", bad)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	for name, factory := range scannerFactories() {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeClamd(t, "unix")
+			scanner, closeScanner := factory(fake.address)
+			defer closeScanner()
+
+			stats, err := scanner.(StatsScanner).StatsContext(context.Background())
+			if err != nil {
+				t.Fatalf("StatsContext 失败: %v", err)
+			}
+			if stats.Threads.Live != 4 || stats.Threads.Max != 4 || stats.Queue.Length != 1 {
+				t.Errorf("Stats = %+v", stats)
+			}
+			if want := []Task{{Command: "INSTREAM", Seconds: 0.25}}; !reflect.DeepEqual(stats.Queue.Items, want) {
+				t.Errorf("Queue.Items = %+v，期望 %+v", stats.Queue.Items, want)
+			}
+			if len(stats.Tasks) != 2 || stats.Tasks[1].File != "/tmp/clamd-instream" {
+				t.Errorf("Tasks = %+v", stats.Tasks)
+			}
+			if stats.Memory.Heap != nil || stats.Memory.PoolsUsed == nil {
+				t.Errorf("Memory = %+v", stats.Memory)
+			}
+		})
+	}
+}
+
+func TestBalancerStats(t *testing.T) {
+	live := newFakeClamd(t, "unix")
+	deadAddress := "unix:///nonexistent/clamd.sock"
+	balancer, err := NewBalancer([]Backend{
+		{Address: live.address, Scanner: NewClient(live.address)},
+		{Address: deadAddress, Scanner: NewClient(deadAddress)},
+	}, BalancerOptions{HealthInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("创建负载均衡器失败: %v", err)
+	}
+	defer balancer.Close()
+
+	results := balancer.BackendStats(context.Background())
+	if len(results) != 2 || results[0].Stats == nil || results[0].Stats.Queue.Length != 1 {
+		t.Fatalf("BackendStats = %+v", results)
+	}
+	if results[1].Address != deadAddress || results[1].Stats != nil || results[1].Error == "" {
+		t.Errorf("不可用后端的结果 = %+v", results[1])
+	}
+
+	var out strings.Builder
+	metrics.Default.WriteTo(&out)
+	for _, want := range []string{
+		fmt.Sprintf(`clamd_api_clamd_queue_length{backend=%q} 1`, live.address),
+		fmt.Sprintf(`clamd_api_clamd_threads{backend=%q,state="idle"} 0`, live.address),
+		fmt.Sprintf(`clamd_api_clamd_memory_bytes{backend=%q,type="pools_total"}`, live.address),
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("指标中缺少 %s", want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package clamav
+
+import (
+	"strings"
+)
+
+// Status 表示扫描结论的类别
+type Status string
+
+const (
+	StatusClean    Status = "clean"    // 未发现威胁
+	StatusInfected Status = "infected" // 发现威胁
+	StatusError    Status = "error"    // clamd 无法完成扫描
+)
+
+// Verdict 表示clamd对单个扫描对象给出的结论
+type Verdict struct {
+	Path       string   `json:"path"`                 // clamd 回复中的对象名，INSTREAM 时为 "stream"
+	Status     Status   `json:"status"`               // 扫描结论
+	Signatures []string `json:"signatures,omitempty"` // 命中的病毒特征名
+	Error      string   `json:"error,omitempty"`      // clamd 返回的错误信息，如 "INSTREAM size limit exceeded"
+	Raw        string   `json:"raw"`                  // clamd 的原始回复
+}
+
+// Clean 判断是否未发现威胁
+func (v Verdict) Clean() bool {
+	return v.Status == StatusClean
+}
+
+// Infected 判断是否发现威胁
+func (v Verdict) Infected() bool {
+	return v.Status == StatusInfected
+}
+
+// ParseVerdict 解析clamd的扫描回复
+//
+// path 为发送给clamd的对象名（INSTREAM 时为 "stream"），用于正确剥离包含冒号的文件路径；
+// 为空时按回复格式推断对象名。
+func ParseVerdict(path, reply string) Verdict {
+	reply = strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+	v := Verdict{Raw: reply}
+
+	body := reply
+	if path != "" && strings.HasPrefix(reply, path+": ") {
+		v.Path = path
+		body = reply[len(path)+2:]
+	} else {
+		v.Path, body = splitReply(reply)
+	}
+
+	switch {
+	case body == "OK":
+		v.Status = StatusClean
+	case strings.HasSuffix(body, " FOUND"):
+		v.Status = StatusInfected
+		v.Signatures = []string{strings.TrimSpace(strings.TrimSuffix(body, " FOUND"))}
+	case body == "ERROR" || strings.HasSuffix(body, " ERROR"):
+		v.Status = StatusError
+		v.Error = strings.TrimSuffix(strings.TrimSpace(strings.TrimSuffix(body, "ERROR")), ".")
+	default:
+		v.Status = StatusError
+		v.Error = "无法识别的clamd回复: " + reply
+	}
+
+	return v
+}
+
+// splitReply 在对象名未知时拆分 "<对象名>: <结论>" 格式的回复
+func splitReply(reply string) (string, string) {
+	if strings.HasSuffix(reply, " ERROR") {
+		// 错误信息本身可能包含冒号，如 "lstat() failed: No such file or directory. ERROR"
+		if path, body, found := strings.Cut(reply, ": "); found {
+			return path, body
+		}
+		return "", reply
+	}
+
+	// 病毒特征名不包含 ": "，以最后一个分隔符为准
+	if i := strings.LastIndex(reply, ": "); i >= 0 {
+		return reply[:i], reply[i+2:]
+	}
+	return "", reply
+}
@@ -0,0 +1,59 @@
+package clamav
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVerdict(t *testing.T) {
+	tests := []struct {
+		path  string
+		reply string
+		want  Verdict
+	}{
+		{
+			path:  "stream",
+			reply: "stream: OK\x00",
+			want:  Verdict{Path: "stream", Status: StatusClean, Raw: "stream: OK"},
+		},
+		{
+			path:  "stream",
+			reply: "stream: Eicar-Test-Signature FOUND",
+			want: Verdict{Path: "stream", Status: StatusInfected, Signatures: []string{"Eicar-Test-Signature"},
+				Raw: "stream: Eicar-Test-Signature FOUND"},
+		},
+		{
+			path:  "stream",
+			reply: "INSTREAM size limit exceeded. ERROR",
+			want: Verdict{Status: StatusError, Error: "INSTREAM size limit exceeded",
+				Raw: "INSTREAM size limit exceeded. ERROR"},
+		},
+		{
+			path:  "C:/data/a: b.txt",
+			reply: "C:/data/a: b.txt: Win.Trojan.Example-1 FOUND",
+			want: Verdict{Path: "C:/data/a: b.txt", Status: StatusInfected, Signatures: []string{"Win.Trojan.Example-1"},
+				Raw: "C:/data/a: b.txt: Win.Trojan.Example-1 FOUND"},
+		},
+		{
+			path:  "/data/missing",
+			reply: "/data/missing: lstat() failed: No such file or directory. ERROR",
+			want: Verdict{Path: "/data/missing", Status: StatusError, Error: "lstat() failed: No such file or directory",
+				Raw: "/data/missing: lstat() failed: No such file or directory. ERROR"},
+		},
+		{
+			reply: "/data/a:b.txt: OK",
+			want:  Verdict{Path: "/data/a:b.txt", Status: StatusClean, Raw: "/data/a:b.txt: OK"},
+		},
+		{
+			reply: "UNKNOWN COMMAND",
+			want:  Verdict{Status: StatusError, Error: "无法识别的clamd回复: UNKNOWN COMMAND", Raw: "UNKNOWN COMMAND"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := ParseVerdict(tt.path, tt.reply)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVerdict(%q, %q) = %+v，期望 %+v", tt.path, tt.reply, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package clamav
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VersionInfo 是解析后的 VERSION 回复
+//
+// clamd 的回复格式为 "ClamAV 1.3.1/27400/Mon Sep 30 09:30:00 2024"，
+// 未加载病毒库时只有 "ClamAV 1.3.1"，此时 Database 为 0，DatabaseTime 为零值。
+type VersionInfo struct {
+	Engine       string    `json:"engine"`       // 引擎版本，例如 "1.3.1"
+	Database     int       `json:"database"`     // 病毒库版本号
+	DatabaseTime time.Time `json:"databaseTime"` // 病毒库发布时间
+	Raw          string    `json:"raw"`          // clamd 的原始回复
+}
+
+// ParseVersion 解析 VERSION 命令的回复
+func ParseVersion(reply string) (VersionInfo, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	info := VersionInfo{Raw: reply}
+
+	parts := strings.SplitN(reply, "/", 3)
+	engine, ok := strings.CutPrefix(parts[0], "ClamAV ")
+	if !ok || engine == "" {
+		return info, fmt.Errorf("无法识别的版本信息: %s", reply)
+	}
+	info.Engine = strings.TrimSpace(engine)
+
+	if len(parts) == 1 {
+		return info, nil
+	}
+
+	database, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return info, fmt.Errorf("无法识别的病毒库版本: %s", reply)
+	}
+	info.Database = database
+
+	if len(parts) == 3 {
+		// clamd 使用 ctime 格式且不带时区，按 UTC 解析
+		databaseTime, err := time.Parse(time.ANSIC, strings.TrimSpace(parts[2]))
+		if err != nil {
+			return info, fmt.Errorf("无法识别的病毒库时间: %s", reply)
+		}
+		info.DatabaseTime = databaseTime
+	}
+
+	return info, nil
+}
+
+// DatabaseAge 返回病毒库相对 now 的时长，病毒库时间未知时返回 0
+func (v VersionInfo) DatabaseAge(now time.Time) time.Duration {
+	if v.DatabaseTime.IsZero() {
+		return 0
+	}
+	return now.Sub(v.DatabaseTime)
+}
@@ -0,0 +1,61 @@
+package clamav
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		reply   string
+		want    VersionInfo
+		wantErr bool
+	}{
+		{
+			reply: "ClamAV 1.3.1/27400/Mon Sep 30 09:30:00 2024",
+			want: VersionInfo{Engine: "1.3.1", Database: 27400,
+				DatabaseTime: time.Date(2024, 9, 30, 9, 30, 0, 0, time.UTC),
+				Raw:          "ClamAV 1.3.1/27400/Mon Sep 30 09:30:00 2024"},
+		},
+		{
+			reply: "ClamAV 0.103.8/26800/Sun Feb  5 08:20:10 2023\n",
+			want: VersionInfo{Engine: "0.103.8", Database: 26800,
+				DatabaseTime: time.Date(2023, 2, 5, 8, 20, 10, 0, time.UTC),
+				Raw:          "ClamAV 0.103.8/26800/Sun Feb  5 08:20:10 2023"},
+		},
+		{
+			reply: "ClamAV 1.3.1\x00",
+			want:  VersionInfo{Engine: "1.3.1", Raw: "ClamAV 1.3.1"},
+		},
+		{reply: "PONG", wantErr: true},
+		{reply: "ClamAV 1.3.1/abc/Mon Sep 30 09:30:00 2024", wantErr: true},
+		{reply: "ClamAV 1.3.1/27400/yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.reply)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseVersion(%q) 错误 = %v, 期望出错 %v", tt.reply, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVersion(%q) = %+v, 期望 %+v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestVersionInfoDatabaseAge(t *testing.T) {
+	info, err := ParseVersion(fakeVersion)
+	if err != nil {
+		t.Fatalf("ParseVersion 失败: %v", err)
+	}
+
+	now := info.DatabaseTime.Add(36 * time.Hour)
+	if age := info.DatabaseAge(now); age != 36*time.Hour {
+		t.Errorf("DatabaseAge = %v, 期望 36h", age)
+	}
+	if age := (VersionInfo{Engine: "1.3.1"}).DatabaseAge(now); age != 0 {
+		t.Errorf("未知病毒库时间的 DatabaseAge = %v, 期望 0", age)
+	}
+}
@@ -1,27 +1,40 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"os/user"
 	"path/filepath"
 	"runtime"
-	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/SmallGaoX/clamd-api/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"github.com/SmallGaoX/clamd-api/api"
+	"github.com/SmallGaoX/clamd-api/audit"
 	"github.com/SmallGaoX/clamd-api/auth"
 	"github.com/SmallGaoX/clamd-api/clamav"
 	"github.com/SmallGaoX/clamd-api/config"
+	"github.com/SmallGaoX/clamd-api/jobs"
+	"github.com/SmallGaoX/clamd-api/logging"
+	"github.com/SmallGaoX/clamd-api/metrics"
+	"github.com/SmallGaoX/clamd-api/ratelimit"
+	"github.com/SmallGaoX/clamd-api/webhook"
 )
 
 var (
 	cfgFile       string
 	apiKeyManager *auth.APIKeyManager
+	auditLog      *audit.Logger
 	executableDir string
 )
 
@@ -37,7 +50,7 @@ var rootCmd = &cobra.Command{
 var apiKeyCmd = &cobra.Command{
 	Use:   "apikey",
 	Short: "管理 API keys",
-	Long:  `添加、删除、列出 API keys。使用 'add' 添加新的 key，'del' 删除已有的 key，'list' 列出所有 key。`,
+	Long:  `添加、删除、列出 API keys。使用 'add' 添加新的 key，'del' 删除已有的 key，'list' 列出所有 key，'rotate' 轮换 key。`,
 }
 
 // addAPIKeyCmd 表示添加API key的命令
@@ -62,10 +75,38 @@ var delAPIKeyCmd = &cobra.Command{
 var listAPIKeysCmd = &cobra.Command{
 	Use:   "list",
 	Short: "列出所有的 API key",
-	Long:  `显示系统中所有的 API key 及其对应的名称。`,
+	Long:  `显示系统中所有的 API key 的名称、key ID、权限、创建时间、过期时间和最近使用时间，并标记已过期和长期未使用的 key。`,
 	Run:   listAPIKeys,
 }
 
+// webhookAPIKeyCmd 表示设置API key威胁告警webhook的命令
+var webhookAPIKeyCmd = &cobra.Command{
+	Use:   "webhook <name> [url...]",
+	Short: "设置 API key 的威胁告警 webhook",
+	Long:  `设置指定 API key 在扫描发现威胁时回调的 webhook 地址，可以指定多个地址。不指定地址时清除已有的 webhook。`,
+	Args:  cobra.MinimumNArgs(1),
+	Run:   setAPIKeyWebhooks,
+}
+
+// rotateAPIKeyCmd 表示轮换API key的命令
+var rotateAPIKeyCmd = &cobra.Command{
+	Use:   "rotate <name>",
+	Short: "轮换指定名称的 API key",
+	Long:  `为指定名称生成新的 API key，旧的 key 在 --grace 指定的宽限期内仍然有效，便于客户端平滑切换。`,
+	Args:  cobra.ExactArgs(1),
+	Run:   rotateAPIKey,
+}
+
+// migrateAPIKeysCmd 表示迁移旧格式API key的命令
+var migrateAPIKeysCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "将旧格式的 API key 迁移为 HMAC 存储",
+	Long: `将 API keys 文件中可还原的旧格式（XOR 加密）记录转换为使用服务端密钥计算的 HMAC，迁移后 key 本身不变。
+加载 API keys 文件时已自动转换，此命令用于立即转换。`,
+	Args: cobra.NoArgs,
+	Run:  migrateAPIKeys,
+}
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "打印版本信息",
@@ -81,7 +122,7 @@ var versionCmd = &cobra.Command{
 func initLogger() {
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Fatalf("加载配置失败: %v", err)
+		fatalf("加载配置失败: %v", err)
 	}
 
 	logFile := cfg.LogFile
@@ -91,29 +132,87 @@ func initLogger() {
 
 	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		log.Fatalf("无法打开日志文件: %v", err)
+		fatalf("无法打开日志文件: %v", err)
+	}
+
+	logger, err := logging.New(file, logging.Options{Level: cfg.LogLevel, Format: cfg.LogFormat})
+	if err != nil {
+		fatalf("初始化日志失败: %v", err)
+	}
+	// 同时接管标准库 log 包的输出
+	slog.SetDefault(logger)
+	loggerReady = true
+}
+
+// loggerReady 表示日志已写入日志文件，此前 slog 的默认输出就是标准错误
+var loggerReady bool
+
+// fatalf 记录错误并输出到标准错误后退出，用于启动和命令行操作失败
+func fatalf(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if loggerReady {
+		slog.Error(message)
+	}
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
+}
+
+// initAuditLog 打开审计日志
+func initAuditLog() {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fatalf("加载配置失败: %v", err)
+	}
+
+	path := cfg.AuditLog
+	if path != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(executableDir, path)
+	}
+
+	auditLog, err = audit.Open(audit.Options{
+		Path:       path,
+		MaxSize:    int64(cfg.AuditMaxSizeMB) << 20,
+		MaxBackups: cfg.AuditMaxBackups,
+	})
+	if err != nil {
+		fatalf("打开审计日志失败: %v", err)
 	}
+}
+
+// auditCLI 写入通过命令行管理 API key 的审计记录
+func auditCLI(event, name string) {
+	entry := audit.Entry{Event: event, User: currentUser(), Target: name}
+	if info, exists := apiKeyManager.GetAPIKeyInfo(name); exists {
+		entry.TargetID = info.ID
+	}
+	auditLog.Log(entry)
+}
 
-	log.SetOutput(file)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+// currentUser 返回执行命令的系统用户
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
 }
 
 func init() {
-	cobra.OnInitialize(initConfig, initAPIKeyManager, initLogger)
+	cobra.OnInitialize(initConfig, initAPIKeyManager, initLogger, initAuditLog)
 
 	// 获取可执行文件所在目录
 	ex, err := os.Executable()
 	if err != nil {
-		log.Fatalf("获取可执行文件路径失败: %v", err)
+		fatalf("获取可执行文件路径失败: %v", err)
 	}
 	executableDir = filepath.Dir(ex)
 
 	// 设置命令行参数
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "配置文件路径 (默认为 ./config.yaml)")
-	rootCmd.PersistentFlags().String("clamav_address", "localhost:3310", "ClamAV服务器地址")
+	rootCmd.PersistentFlags().String("clamav_address", "localhost:3310", "ClamAV服务器地址 (支持 tcp://host:port 或 unix:///path)")
 	rootCmd.PersistentFlags().String("temp_dir", "/tmp", "临时文件目录")
 	rootCmd.PersistentFlags().String("port", "8080", "API服务器端口")
 	rootCmd.PersistentFlags().String("api_key_file", "api_keys.txt", "API key 文件路径")
+	rootCmd.PersistentFlags().String("api_key_secret", "", "计算 API key HMAC 的密钥 (默认读取或生成 <api_key_file>.secret)")
 	rootCmd.PersistentFlags().StringP("file-list", "f", "", "从指定文件读取要扫描的文件列表")
 
 	// 绑定命令行参数到viper
@@ -121,10 +220,19 @@ func init() {
 	viper.BindPFlag("temp_dir", rootCmd.PersistentFlags().Lookup("temp_dir"))
 	viper.BindPFlag("port", rootCmd.PersistentFlags().Lookup("port"))
 	viper.BindPFlag("api_key_file", rootCmd.PersistentFlags().Lookup("api_key_file"))
+	viper.BindPFlag("api_key_secret", rootCmd.PersistentFlags().Lookup("api_key_secret"))
 	viper.BindPFlag("file_list", rootCmd.PersistentFlags().Lookup("file-list"))
 
+	addAPIKeyCmd.Flags().StringSlice("webhook", nil, "发现威胁时回调的 webhook 地址，可以重复指定")
+	addAPIKeyCmd.Flags().StringSlice("scope", nil, fmt.Sprintf("API key 的权限范围，可以重复指定 (可用: %s，默认: %s)",
+		strings.Join(auth.Scopes, ", "), strings.Join(auth.DefaultScopes, ", ")))
+
+	addAPIKeyCmd.Flags().String("expires", "", "过期时间，可以是时长（如 720h、90d）或日期（如 2025-12-31），默认永不过期")
+	rotateAPIKeyCmd.Flags().String("grace", "24h", "旧 key 继续有效的宽限期，0 表示立即失效")
+	listAPIKeysCmd.Flags().String("stale-after", "30d", "超过该时长未使用的 key 标记为长期未使用")
+
 	// 添加子命令
-	apiKeyCmd.AddCommand(addAPIKeyCmd, delAPIKeyCmd, listAPIKeysCmd)
+	apiKeyCmd.AddCommand(addAPIKeyCmd, delAPIKeyCmd, listAPIKeysCmd, rotateAPIKeyCmd, webhookAPIKeyCmd, migrateAPIKeysCmd)
 
 	rootCmd.AddCommand(apiKeyCmd)
 	rootCmd.AddCommand(versionCmd)
@@ -153,36 +261,223 @@ func initAPIKeyManager() {
 		apiKeyFilePath = filepath.Join(executableDir, apiKeyFilePath)
 	}
 
-	var err error
-	apiKeyManager, err = auth.NewAPIKeyManager(apiKeyFilePath)
+	secret, err := apiKeySecret(apiKeyFilePath)
+	if err != nil {
+		fatalf("加载 API key 密钥失败: %v", err)
+	}
+
+	apiKeyManager, err = auth.NewAPIKeyManager(apiKeyFilePath, secret)
 	if err != nil {
-		log.Fatalf("创建 API key 管理器失败: %v", err)
+		fatalf("创建 API key 管理器失败: %v", err)
+	}
+}
+
+// apiKeySecret 返回计算 API key HMAC 的密钥，优先使用配置项或环境变量 API_KEY_SECRET，
+// 都未设置时使用 API key 文件旁的 .secret 文件（不存在时自动生成）
+func apiKeySecret(apiKeyFilePath string) ([]byte, error) {
+	if secret := viper.GetString("api_key_secret"); secret != "" {
+		return []byte(secret), nil
 	}
+	return auth.LoadOrCreateSecret(apiKeyFilePath + ".secret")
 }
 
 // runServer 运行API服务器
 func runServer(cmd *cobra.Command, args []string) {
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Fatalf("加载配置失败: %v", err)
+		fatalf("加载配置失败: %v", err)
+	}
+
+	scanner, err := newScanner(cfg)
+	if err != nil {
+		fatalf("创建 ClamAV 客户端失败: %v", err)
 	}
+	defer scanner.Close()
 
-	scanner := clamav.NewClient(cfg.ClamAVAddress)
+	if cfg.WebhookSecret == "" {
+		slog.Warn("未配置 webhook_secret，webhook 回调将不会签名")
+	}
+	policy, err := webhook.NewPolicy(cfg.WebhookAllowedHosts)
+	if err != nil {
+		fatalf("无效的 webhook_allowed_hosts: %v", err)
+	}
+	dispatcher := webhook.NewDispatcher([]byte(cfg.WebhookSecret), webhook.Options{
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		Timeout:     cfg.WebhookTimeout,
+		Policy:      policy,
+	})
+	defer dispatcher.Close()
+	notifier := api.NewNotifier(dispatcher, apiKeyManager)
+
+	defer auditLog.Close()
+	handler := api.NewHandler(scanner, cfg, apiKeyManager, notifier, auditLog)
+
+	limiter := newLimiter(cfg)
+	jobManager, err := newJobManager(cfg, scanner, limiter, func(job *jobs.Job) {
+		api.AuditJob(auditLog, job)
+		notifier.JobFinished(job)
+	})
+	if err != nil {
+		fatalf("创建任务管理器失败: %v", err)
+	}
+	defer jobManager.Close()
+	jobHandler := api.NewJobHandler(jobManager, notifier, auditLog)
+
+	for _, route := range routes(cfg, handler, jobHandler, notifier, limiter) {
+		http.HandleFunc(route.pattern, route.handler)
+	}
 
-	handler := api.NewHandler(scanner, cfg, apiKeyManager)
+	apiKeyManager.StartFlusher(cfg.APIKeyFlushInterval)
+	defer apiKeyManager.Close()
 
-	// 设置路由
-	http.HandleFunc("/scan", api.LoggingMiddleware(api.AuthMiddleware(handler.ScanFileHandler, apiKeyManager)))
-	http.HandleFunc("/stream", api.LoggingMiddleware(api.AuthMiddleware(handler.ScanStreamHandler, apiKeyManager)))
-	http.HandleFunc("/version", api.LoggingMiddleware(api.AuthMiddleware(handler.VersionHandler, apiKeyManager)))
-	http.HandleFunc("/ping", api.LoggingMiddleware(api.AuthMiddleware(handler.PingHandler, apiKeyManager)))
-	http.HandleFunc("/reload", api.LoggingMiddleware(api.AuthMiddleware(handler.ReloadHandler, apiKeyManager)))
+	// 收到退出信号后停止接收请求，再执行上面的清理，避免丢失尚未写入的最近使用时间
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	apiKeyManager.DebugPrintKeys()
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: api.RequestIDMiddleware(http.DefaultServeMux)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
 
 	// 启动服务器
-	log.Printf("启动服务器,监听端口 %s...", cfg.Port)
-	log.Fatal(http.ListenAndServe(":"+cfg.Port, nil))
+	slog.Info("启动服务器", "port", cfg.Port, "keyCount", len(apiKeyManager.ListAPIKeys()))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		fatalf("服务器异常退出: %v", err)
+	}
+	slog.Info("服务器已停止")
+}
+
+// route 是一个 HTTP 路由
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+// routes 返回服务的所有路由，新增路由时需同步更新 api/openapi.json
+func routes(cfg *config.Config, handler *api.Handler, jobHandler *api.JobHandler, notifier *api.Notifier, limiter *ratelimit.Limiter) []route {
+	// withKey 为需要 API key 的路由加上访问日志、指标和认证
+	withKey := func(pattern string, next http.HandlerFunc, scope string) route {
+		return route{pattern, api.LoggingMiddleware(api.MetricsMiddleware(pattern, api.AuthMiddleware(next, apiKeyManager, scope)))}
+	}
+	// withAdmin 为管理接口加上访问日志、指标和认证，需要 admin:keys 权限或 admin_token 引导凭据
+	withAdmin := func(pattern string, next http.HandlerFunc) route {
+		return route{pattern, api.LoggingMiddleware(api.MetricsMiddleware(pattern, api.AdminAuthMiddleware(next, apiKeyManager, cfg.AdminToken)))}
+	}
+
+	usageHandler := api.NewUsageHandler(limiter)
+	adminHandler := api.NewAdminHandler(apiKeyManager, auditLog)
+
+	metricsHandler := metrics.Default.Handler()
+	if cfg.MetricsRequireKey {
+		metricsHandler = api.AuthMiddleware(metricsHandler, apiKeyManager, "")
+	}
+
+	return []route{
+		// 扫描接口按 API key 限流并限制同时进行的扫描数，限流在认证之后进行
+		withKey("/scan", api.RateLimitMiddleware(api.ConcurrencyMiddleware(handler.ScanFileHandler, limiter), limiter), auth.ScopeScan),
+		withKey("/stream", api.RateLimitMiddleware(api.ConcurrencyMiddleware(handler.ScanStreamHandler, limiter), limiter), auth.ScopeScan),
+		withKey("/version", handler.VersionHandler, auth.ScopeScan),
+		withKey("/ping", handler.PingHandler, auth.ScopeScan),
+		withKey("/reload", handler.ReloadHandler, auth.ScopeAdminReload),
+		withKey("/backends", handler.BackendsHandler, auth.ScopeScan),
+		withKey("/clamd/stats", handler.ClamdStatsHandler, auth.ScopeScan),
+		withKey("/clamd/capabilities", handler.ClamdCapabilitiesHandler, auth.ScopeScan),
+		withKey("/jobs", api.RateLimitMiddleware(jobHandler.SubmitHandler, limiter), auth.ScopeScan),
+		withKey("/jobs/{id}", jobHandler.StatusHandler, auth.ScopeScan),
+		withKey("/webhooks/deliveries", notifier.DeliveriesHandler, auth.ScopeScan),
+		withKey("/usage", usageHandler.UsageHandler, auth.ScopeScan),
+
+		withAdmin("/admin/keys", adminHandler.KeysHandler),
+		withAdmin("/admin/keys/{name}", adminHandler.KeyHandler),
+		withAdmin("/admin/usage", usageHandler.AllUsageHandler),
+
+		// 存活和就绪检查供 Kubernetes 等探针调用，不需要 API key，也不写访问日志
+		{"/healthz", api.MetricsMiddleware("/healthz", handler.HealthzHandler)},
+		{"/readyz", api.MetricsMiddleware("/readyz", handler.ReadyzHandler)},
+		{"/metrics", metricsHandler},
+		{"/openapi.json", api.LoggingMiddleware(api.MetricsMiddleware("/openapi.json", api.OpenAPIHandler))},
+	}
+}
+
+// newLimiter 根据配置创建按 API key 的限流器
+func newLimiter(cfg *config.Config) *ratelimit.Limiter {
+	keys := make(map[string]ratelimit.Limit, len(cfg.RateLimits))
+	for name, limit := range cfg.RateLimits {
+		keys[name] = ratelimit.Limit(limit)
+	}
+	return ratelimit.New(ratelimit.Options{
+		Default:       ratelimit.Limit(cfg.RateLimit),
+		Keys:          keys,
+		MaxConcurrent: cfg.MaxConcurrentScans,
+	})
+}
+
+// newScanner 根据配置为每个 clamd 后端创建客户端，并组合为负载均衡扫描器
+func newScanner(cfg *config.Config) (*clamav.Balancer, error) {
+	timeouts := clamav.Timeouts{
+		Dial:    cfg.DialTimeout,
+		Command: cfg.CommandTimeout,
+		Scan:    cfg.ScanTimeout,
+	}
+
+	var backends []clamav.Backend
+	for _, address := range cfg.ClamAVBackends {
+		var client clamav.ContextScanner
+		if cfg.PoolSize > 0 {
+			client = clamav.NewPool(address, clamav.PoolOptions{
+				MaxSessions: cfg.PoolSize,
+				MaxInflight: cfg.PoolInflight,
+				IdleTimeout: cfg.PoolIdleTimeout,
+				Timeouts:    timeouts,
+			})
+		} else {
+			client = clamav.NewClientWithTimeouts(address, timeouts)
+		}
+		backends = append(backends, clamav.Backend{Address: address, Scanner: client})
+	}
+
+	return clamav.NewBalancer(backends, clamav.BalancerOptions{
+		Strategy:       clamav.Strategy(cfg.BalanceStrategy),
+		HealthInterval: cfg.HealthInterval,
+		HealthTimeout:  cfg.HealthTimeout,
+		FailThreshold:  cfg.FailThreshold,
+	})
+}
+
+// newJobManager 根据配置创建异步扫描任务管理器，任务与 /scan、/stream 共用 limiter 的并发扫描上限
+func newJobManager(cfg *config.Config, scanner clamav.ContextScanner, limiter *ratelimit.Limiter, onFinish func(*jobs.Job)) (*jobs.Manager, error) {
+	var store jobs.Store
+	spoolDir := filepath.Join(cfg.TempDir, "clamd-api-jobs")
+	switch cfg.JobStore {
+	case "", "memory":
+		store = jobs.NewMemoryStore()
+	case "file":
+		dir := cfg.JobStoreDir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(executableDir, dir)
+		}
+		fileStore, err := jobs.NewFileStore(dir)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+		// 上传的文件与任务一起保存，temp_dir 可能是重启后清空的 tmpfs
+		spoolDir = filepath.Join(dir, "spool")
+	default:
+		return nil, fmt.Errorf("不支持的任务存储类型: %s", cfg.JobStore)
+	}
+
+	return jobs.NewManager(scanner, store, jobs.Options{
+		Workers:   cfg.JobWorkers,
+		QueueSize: cfg.JobQueueSize,
+		SpoolDir:  spoolDir,
+		TTL:       cfg.JobTTL,
+		Acquire:   limiter.Wait,
+		OnFinish:  onFinish,
+	})
 }
 
 // addAPIKey 添加新的API key
@@ -191,59 +486,210 @@ func addAPIKey(cmd *cobra.Command, args []string) {
 
 	apiKey, err := auth.GenerateAPIKey()
 	if err != nil {
-		log.Fatalf("生成 API key 失败: %v", err)
+		fatalf("生成 API key 失败: %v", err)
 	}
 
-	err = apiKeyManager.AddAPIKey(apiKey, name)
+	webhooks, _ := cmd.Flags().GetStringSlice("webhook")
+	for _, target := range webhooks {
+		if err := webhook.ValidateURL(target); err != nil {
+			fatalf("添加 API key 失败: %v", err)
+		}
+	}
+
+	scopes, _ := cmd.Flags().GetStringSlice("scope")
+	expiresAt, err := parseExpiry(mustGetString(cmd, "expires"), time.Now())
+	if err != nil {
+		fatalf("无效的 --expires: %v", err)
+	}
+
+	err = apiKeyManager.AddAPIKey(apiKey, name, auth.KeyOptions{Scopes: scopes, ExpiresAt: expiresAt, Webhooks: webhooks})
 	if err != nil {
-		log.Fatalf("添加 API key 失败: %v", err)
+		fatalf("添加 API key 失败: %v", err)
 	}
+	auditCLI(audit.EventKeyAdd, name)
 
-	fmt.Printf("成功添加 API key:\n名称: %s\nAPI Key: %s\n\n", name, apiKey)
+	fmt.Printf("成功添加 API key:\n名称: %s\nAPI Key: %s\n权限: %s\n过期时间: %s\n\n",
+		name, apiKey, strings.Join(apiKeyManager.GetScopes(name), ", "), formatTime(expiresAt, "永不过期"))
 	fmt.Println("请保存此 API key，因为它不会再次显示。")
 	fmt.Printf("API keys 文件位置: %s\n", apiKeyManager.GetFilePath())
 }
 
+// setAPIKeyWebhooks 设置指定API key的威胁告警webhook
+func setAPIKeyWebhooks(cmd *cobra.Command, args []string) {
+	name, webhooks := args[0], args[1:]
+
+	for _, target := range webhooks {
+		if err := webhook.ValidateURL(target); err != nil {
+			fatalf("设置 webhook 失败: %v", err)
+		}
+	}
+
+	if err := apiKeyManager.SetWebhooks(name, webhooks); err != nil {
+		fatalf("设置 webhook 失败: %v", err)
+	}
+
+	if len(webhooks) == 0 {
+		fmt.Printf("已清除名称为 '%s' 的 API key 的 webhook\n", name)
+		return
+	}
+	fmt.Printf("已为名称为 '%s' 的 API key 设置 webhook:\n", name)
+	for _, target := range webhooks {
+		fmt.Printf("  %s\n", target)
+	}
+}
+
 // delAPIKey 删除指定的API key
 func delAPIKey(cmd *cobra.Command, args []string) {
 	name := args[0]
 
 	fmt.Printf("正在删除名称为 '%s' 的 API key...\n", name)
 
+	info, _ := apiKeyManager.GetAPIKeyInfo(name)
 	err := apiKeyManager.RemoveAPIKey(name)
 	if err != nil {
-		log.Fatalf("删除 API key 失败: %v", err)
+		fatalf("删除 API key 失败: %v", err)
 	}
+	auditLog.Log(audit.Entry{Event: audit.EventKeyDelete, User: currentUser(), Target: name, TargetID: info.ID})
 
 	fmt.Printf("成功删除名称为 '%s' 的 API key\n", name)
 }
 
+// migrateAPIKeys 迁移旧格式的API key
+func migrateAPIKeys(cmd *cobra.Command, args []string) {
+	migrated, err := apiKeyManager.MigrateLegacyKeys()
+	if err != nil {
+		fatalf("迁移 API key 失败: %v", err)
+	}
+
+	if migrated == 0 {
+		fmt.Println("API keys 文件中已没有旧格式的记录")
+		return
+	}
+	auditLog.Log(audit.Entry{Event: audit.EventKeyMigrate, User: currentUser()})
+	fmt.Printf("成功迁移 %d 个 API key\n", migrated)
+	fmt.Printf("API keys 文件位置: %s\n", apiKeyManager.GetFilePath())
+}
+
 // listAPIKeys 列出所有的API key
 func listAPIKeys(cmd *cobra.Command, args []string) {
-	apiKeys := apiKeyManager.GetAllObfuscatedAPIKeys()
+	apiKeys := apiKeyManager.ListAPIKeys()
 
 	if len(apiKeys) == 0 {
 		fmt.Println("当前没有 API key")
 		return
 	}
 
+	staleAfter, err := parseDuration(mustGetString(cmd, "stale-after"))
+	if err != nil {
+		fatalf("无效的 --stale-after: %v", err)
+	}
+
 	fmt.Println("API Keys:")
 	fmt.Println("--------------------------------------------------")
 
-	var sortedNames []string
-	for name := range apiKeys {
-		sortedNames = append(sortedNames, name)
+	now := time.Now()
+	for _, key := range apiKeys {
+		var flags []string
+		if key.Legacy {
+			flags = append(flags, "旧格式，需要迁移")
+		}
+		if key.Expired(now) {
+			flags = append(flags, "已过期")
+		} else if key.Stale(now, staleAfter) {
+			flags = append(flags, "长期未使用")
+		}
+
+		fmt.Printf("名称: %s", key.Name)
+		if len(flags) > 0 {
+			fmt.Printf("（%s）", strings.Join(flags, "，"))
+		}
+		fmt.Printf("\nKey ID: %s\n权限: %s\n", key.ID, strings.Join(key.Scopes, ", "))
+		fmt.Printf("创建时间: %s\n过期时间: %s\n最近使用: %s\n",
+			formatTime(key.CreatedAt, "未知"), formatTime(key.ExpiresAt, "永不过期"), formatTime(key.LastUsedAt, "从未使用"))
+		if !key.PreviousExpiresAt.IsZero() {
+			fmt.Printf("轮换前的 key 有效至: %s\n", formatTime(key.PreviousExpiresAt, ""))
+		}
+		fmt.Println()
+	}
+}
+
+// rotateAPIKey 为指定名称生成新的API key，旧key在宽限期内仍然有效
+func rotateAPIKey(cmd *cobra.Command, args []string) {
+	name := args[0]
+
+	grace, err := parseDuration(mustGetString(cmd, "grace"))
+	if err != nil {
+		fatalf("无效的 --grace: %v", err)
+	}
+
+	apiKey, err := auth.GenerateAPIKey()
+	if err != nil {
+		fatalf("生成 API key 失败: %v", err)
+	}
+
+	if err := apiKeyManager.RotateAPIKey(name, apiKey, grace); err != nil {
+		fatalf("轮换 API key 失败: %v", err)
+	}
+	auditCLI(audit.EventKeyRotate, name)
+
+	fmt.Printf("成功轮换 API key:\n名称: %s\n新的 API Key: %s\n\n", name, apiKey)
+	if grace > 0 {
+		fmt.Printf("旧的 API key 在 %s 之前仍然有效。\n", formatTime(time.Now().Add(grace), ""))
+	} else {
+		fmt.Println("旧的 API key 已立即失效。")
+	}
+	fmt.Println("请保存此 API key，因为它不会再次显示。")
+}
+
+// parseExpiry 解析过期时间，支持时长（如 720h、90d）、日期（2006-01-02）和 RFC 3339 时间
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := parseDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
 	}
-	sort.Strings(sortedNames)
+	return time.Time{}, fmt.Errorf("无法识别的时间: %s", value)
+}
+
+// parseDuration 解析时长，在 time.ParseDuration 的基础上支持以天为单位（如 90d）
+func parseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("无效的时长: %s", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
 
-	for _, name := range sortedNames {
-		fmt.Printf("名称: %s\nAPI Key: %s\n\n", name, apiKeys[name])
+// formatTime 格式化时间，零值时返回 zero
+func formatTime(t time.Time, zero string) string {
+	if t.IsZero() {
+		return zero
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// mustGetString 读取字符串参数
+func mustGetString(cmd *cobra.Command, name string) string {
+	value, err := cmd.Flags().GetString(name)
+	if err != nil {
+		fatalf("读取参数 --%s 失败: %v", name, err)
 	}
+	return value
 }
 
 // Execute 执行根命令
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		log.Fatalf("执行命令失败: %v", err)
+		fatalf("执行命令失败: %v", err)
 	}
 }
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SmallGaoX/clamd-api/api"
+	"github.com/SmallGaoX/clamd-api/config"
+	"github.com/SmallGaoX/clamd-api/ratelimit"
+	"github.com/spf13/viper"
+)
+
+// openAPISpec 解析内嵌的 OpenAPI 文档
+func openAPISpec(t *testing.T) map[string]any {
+	t.Helper()
+
+	var spec map[string]any
+	if err := json.Unmarshal(api.OpenAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json 不是有效的 JSON: %v", err)
+	}
+	if version, _ := spec["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		t.Fatalf("openapi = %q，期望 3.x", version)
+	}
+	return spec
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	spec := openAPISpec(t)
+	paths, _ := spec["paths"].(map[string]any)
+
+	// 只收集路由模式，不调用处理程序
+	registered := map[string]bool{}
+	for _, route := range routes(&config.Config{}, nil, nil, nil, ratelimit.New(ratelimit.Options{})) {
+		if registered[route.pattern] {
+			t.Errorf("路由 %s 重复注册", route.pattern)
+		}
+		registered[route.pattern] = true
+
+		item, ok := paths[route.pattern].(map[string]any)
+		if !ok {
+			t.Errorf("路由 %s 没有写入 openapi.json", route.pattern)
+			continue
+		}
+		operations := 0
+		for _, method := range []string{"get", "post", "put", "patch", "delete"} {
+			if _, ok := item[method]; ok {
+				operations++
+			}
+		}
+		if operations == 0 {
+			t.Errorf("openapi.json 中 %s 没有任何操作", route.pattern)
+		}
+	}
+
+	for path := range paths {
+		if !registered[path] {
+			t.Errorf("openapi.json 中的 %s 没有注册路由", path)
+		}
+	}
+}
+
+func TestOpenAPIRefs(t *testing.T) {
+	spec := openAPISpec(t)
+	components, _ := spec["components"].(map[string]any)
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				parts := strings.Split(strings.TrimPrefix(ref, "#/components/"), "/")
+				section, _ := components[parts[0]].(map[string]any)
+				if len(parts) != 2 || section[parts[1]] == nil {
+					t.Errorf("无法解析的引用 %s", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(spec)
+}
+
+func TestAPIKeySecretEnv(t *testing.T) {
+	apiKeyFile := filepath.Join(t.TempDir(), "api_keys.json")
+	viper.AutomaticEnv()
+
+	// 环境变量优先，不生成密钥文件
+	t.Setenv("API_KEY_SECRET", "from-env")
+	secret, err := apiKeySecret(apiKeyFile)
+	if err != nil || string(secret) != "from-env" {
+		t.Fatalf("apiKeySecret = %q, %v", secret, err)
+	}
+	if _, err := os.Stat(apiKeyFile + ".secret"); !os.IsNotExist(err) {
+		t.Errorf("设置环境变量时仍生成了密钥文件: %v", err)
+	}
+
+	// 未设置时使用 API key 文件旁的 .secret 文件
+	t.Setenv("API_KEY_SECRET", "")
+	secret, err = apiKeySecret(apiKeyFile)
+	if err != nil || len(secret) == 0 {
+		t.Fatalf("apiKeySecret = %q, %v", secret, err)
+	}
+	if _, err := os.Stat(apiKeyFile + ".secret"); err != nil {
+		t.Errorf("没有生成密钥文件: %v", err)
+	}
+}
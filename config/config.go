@@ -2,6 +2,9 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -13,16 +16,132 @@ type Config struct {
 	Port          string
 	APIKeyFile    string
 	LogFile       string
+
+	// 日志级别（debug、info、warn、error）和格式（text、json）
+	LogLevel  string
+	LogFormat string
+
+	// APIKeyFlushInterval 将 API key 最近使用时间写入文件的间隔
+	APIKeyFlushInterval time.Duration
+
+	// AdminToken 是管理接口的引导凭据，通过 X-Admin-Token 请求头传递，为空时只能使用 admin:keys 权限的 API key
+	AdminToken string
+
+	// ClamAVBackends 多个 clamd 后端地址，为空时只使用 ClamAVAddress
+	ClamAVBackends []string
+
+	// ClamAV 连接池配置，PoolSize 为0时每个命令单独建立连接
+	PoolSize        int
+	PoolInflight    int
+	PoolIdleTimeout time.Duration
+
+	// ScanRoots 允许通过 /stream 扫描的服务器目录（需要与 clamd 看到相同的文件系统），为空时不允许扫描服务器上的路径
+	ScanRoots []string
+
+	// 与 clamd 通信的超时配置
+	DialTimeout    time.Duration
+	CommandTimeout time.Duration
+	ScanTimeout    time.Duration
+
+	// 多后端负载均衡与健康检查配置
+	BalanceStrategy string
+	HealthInterval  time.Duration
+	HealthTimeout   time.Duration
+	FailThreshold   int
+
+	// 异步扫描任务配置
+	JobWorkers   int
+	JobQueueSize int
+	JobStore     string // memory 或 file
+	JobStoreDir  string
+	JobTTL       time.Duration
+
+	// Webhook 回调配置，WebhookSecret 为空时不对回调签名
+	WebhookSecret      string
+	WebhookMaxAttempts int
+	WebhookTimeout     time.Duration
+	// 允许投递的内网主机名、IP 或网段，其他回环、私有和链路本地地址一律拒绝
+	WebhookAllowedHosts []string
+
+	// 扫描结论缓存配置，CacheSize 为0时不缓存
+	CacheSize       int
+	CacheTTL        time.Duration
+	CacheVersionTTL time.Duration
+
+	// 审计日志配置，AuditLog 为空时不记录；AuditMaxSizeMB 为0时不轮转
+	AuditLog        string
+	AuditMaxSizeMB  int
+	AuditMaxBackups int
+
+	// MetricsRequireKey 为 true 时访问 /metrics 需要 API key
+	MetricsRequireKey bool
+
+	// 限流配置：RateLimit 是默认的限制，RateLimits 按 API key 名称单独配置；MaxConcurrentScans 为0时不限制并发
+	RateLimit          RateLimit
+	RateLimits         map[string]RateLimit
+	MaxConcurrentScans int
+
+	// 就绪检查配置，MaxSignatureAge 为0（默认）时不检查病毒库时效
+	ReadyTimeout    time.Duration
+	MaxSignatureAge time.Duration
+	// SignatureWarnAge 病毒库超过该时长未更新时记录警告并在 /version 中标记为过期，但不影响就绪状态，为0时不警告
+	SignatureWarnAge time.Duration
+}
+
+// RateLimit 是一个 API key 的限流配置，各项为0时表示不限制
+type RateLimit struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+	DailyBytes        int64   `mapstructure:"daily_bytes"`
 }
 
 // LoadConfig 加载配置
 func LoadConfig() (*Config, error) {
 	// 设置默认值
-	viper.SetDefault("clamav_address", "10.10.101.50:3310")
+	viper.SetDefault("clamav_address", "localhost:3310")
+	viper.SetDefault("clamav_backends", []string{})
 	viper.SetDefault("temp_dir", "/tmp")
+	viper.SetDefault("scan_roots", []string{})
 	viper.SetDefault("port", "8080")
 	viper.SetDefault("api_key_file", "api_keys.txt") // 修改这里，使用相对路径
 	viper.SetDefault("log_file", "clamd-api.log")
+	viper.SetDefault("log_level", "info")
+	viper.SetDefault("log_format", "text")
+	viper.SetDefault("api_key_flush_interval", "1m")
+	viper.SetDefault("admin_token", "")
+	viper.SetDefault("clamav_pool_size", 4)
+	viper.SetDefault("clamav_pool_inflight", 4)
+	viper.SetDefault("clamav_pool_idle_timeout", "20s")
+	viper.SetDefault("clamav_dial_timeout", "10s")
+	viper.SetDefault("clamav_command_timeout", "10s")
+	viper.SetDefault("clamav_scan_timeout", "30s")
+	viper.SetDefault("clamav_balance_strategy", "round_robin")
+	viper.SetDefault("clamav_health_interval", "10s")
+	viper.SetDefault("clamav_health_timeout", "2s")
+	viper.SetDefault("clamav_fail_threshold", 3)
+	viper.SetDefault("job_workers", 4)
+	viper.SetDefault("job_queue_size", 100)
+	viper.SetDefault("job_store", "memory")
+	viper.SetDefault("job_store_dir", "jobs")
+	viper.SetDefault("job_ttl", "24h")
+	viper.SetDefault("webhook_secret", "")
+	viper.SetDefault("webhook_max_attempts", 5)
+	viper.SetDefault("webhook_timeout", "10s")
+	viper.SetDefault("webhook_allowed_hosts", []string{})
+	viper.SetDefault("cache_size", 10000)
+	viper.SetDefault("cache_ttl", "1h")
+	viper.SetDefault("cache_version_ttl", "1m")
+	viper.SetDefault("audit_log", "audit.log")
+	viper.SetDefault("audit_max_size_mb", 100)
+	viper.SetDefault("audit_max_backups", 10)
+	viper.SetDefault("metrics_require_key", false)
+	viper.SetDefault("rate_limit.requests_per_second", 0)
+	viper.SetDefault("rate_limit.burst", 0)
+	viper.SetDefault("rate_limit.daily_bytes", 0)
+	viper.SetDefault("max_concurrent_scans", 0)
+	viper.SetDefault("ready_timeout", "2s")
+	viper.SetDefault("max_signature_age", 0)
+	viper.SetDefault("signature_warn_age", "24h")
 
 	// 读取配置文件
 	viper.SetConfigName("config")
@@ -45,6 +164,72 @@ func LoadConfig() (*Config, error) {
 		Port:          viper.GetString("port"),
 		APIKeyFile:    viper.GetString("api_key_file"),
 		LogFile:       viper.GetString("log_file"),
+
+		LogLevel:  viper.GetString("log_level"),
+		LogFormat: viper.GetString("log_format"),
+
+		APIKeyFlushInterval: viper.GetDuration("api_key_flush_interval"),
+		AdminToken:          viper.GetString("admin_token"),
+
+		ClamAVBackends: viper.GetStringSlice("clamav_backends"),
+		ScanRoots:      viper.GetStringSlice("scan_roots"),
+
+		PoolSize:        viper.GetInt("clamav_pool_size"),
+		PoolInflight:    viper.GetInt("clamav_pool_inflight"),
+		PoolIdleTimeout: viper.GetDuration("clamav_pool_idle_timeout"),
+
+		DialTimeout:    viper.GetDuration("clamav_dial_timeout"),
+		CommandTimeout: viper.GetDuration("clamav_command_timeout"),
+		ScanTimeout:    viper.GetDuration("clamav_scan_timeout"),
+
+		BalanceStrategy: viper.GetString("clamav_balance_strategy"),
+		HealthInterval:  viper.GetDuration("clamav_health_interval"),
+		HealthTimeout:   viper.GetDuration("clamav_health_timeout"),
+		FailThreshold:   viper.GetInt("clamav_fail_threshold"),
+
+		JobWorkers:   viper.GetInt("job_workers"),
+		JobQueueSize: viper.GetInt("job_queue_size"),
+		JobStore:     viper.GetString("job_store"),
+		JobStoreDir:  viper.GetString("job_store_dir"),
+		JobTTL:       viper.GetDuration("job_ttl"),
+
+		WebhookSecret:       viper.GetString("webhook_secret"),
+		WebhookMaxAttempts:  viper.GetInt("webhook_max_attempts"),
+		WebhookTimeout:      viper.GetDuration("webhook_timeout"),
+		WebhookAllowedHosts: viper.GetStringSlice("webhook_allowed_hosts"),
+
+		CacheSize:       viper.GetInt("cache_size"),
+		CacheTTL:        viper.GetDuration("cache_ttl"),
+		CacheVersionTTL: viper.GetDuration("cache_version_ttl"),
+
+		AuditLog:        viper.GetString("audit_log"),
+		AuditMaxSizeMB:  viper.GetInt("audit_max_size_mb"),
+		AuditMaxBackups: viper.GetInt("audit_max_backups"),
+
+		MetricsRequireKey: viper.GetBool("metrics_require_key"),
+
+		MaxConcurrentScans: viper.GetInt("max_concurrent_scans"),
+
+		ReadyTimeout:     viper.GetDuration("ready_timeout"),
+		MaxSignatureAge:  viper.GetDuration("max_signature_age"),
+		SignatureWarnAge: viper.GetDuration("signature_warn_age"),
+	}
+
+	if err := viper.UnmarshalKey("rate_limit", &config.RateLimit); err != nil {
+		return nil, fmt.Errorf("解析 rate_limit 失败: %v", err)
+	}
+	if err := viper.UnmarshalKey("rate_limits", &config.RateLimits); err != nil {
+		return nil, fmt.Errorf("解析 rate_limits 失败: %v", err)
+	}
+
+	for _, root := range config.ScanRoots {
+		if !filepath.IsAbs(root) {
+			return nil, fmt.Errorf("scan_roots 中的 '%s' 不是绝对路径", root)
+		}
+	}
+
+	if len(config.ClamAVBackends) == 0 {
+		config.ClamAVBackends = []string{config.ClamAVAddress}
 	}
 
 	return config, nil
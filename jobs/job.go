@@ -0,0 +1,84 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/clamav"
+)
+
+// Status 表示扫描任务的状态
+type Status string
+
+const (
+	StatusQueued   Status = "queued"   // 等待执行
+	StatusRunning  Status = "running"  // 正在扫描
+	StatusDone     Status = "done"     // 所有文件已扫描
+	StatusFailed   Status = "failed"   // 任务无法完成
+	StatusCanceled Status = "canceled" // 已被取消
+)
+
+var (
+	// ErrNotFound 表示任务不存在
+	ErrNotFound = errors.New("任务不存在")
+	// ErrQueueFull 表示任务队列已满
+	ErrQueueFull = errors.New("任务队列已满")
+)
+
+// File 表示任务中的单个待扫描文件
+type File struct {
+	Name      string          `json:"name"`
+	Size      int64           `json:"size"`
+	SHA256    string          `json:"sha256,omitempty"` // 上传内容的 SHA-256
+	SpoolPath string          `json:"spoolPath"`        // 上传内容在本地暂存的位置
+	Done      bool            `json:"done"`
+	Verdict   *clamav.Verdict `json:"verdict,omitempty"`
+	Error     string          `json:"error,omitempty"` // 与clamd通信失败等非扫描结论的错误
+}
+
+// Job 表示一个异步扫描任务
+type Job struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`                 // 提交任务的 API key 名称
+	CallbackURL string    `json:"callbackUrl,omitempty"` // 任务结束时推送结果的地址
+	Status      Status    `json:"status"`
+	Files       []File    `json:"files"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Finished 判断任务是否已结束
+func (j *Job) Finished() bool {
+	switch j.Status {
+	case StatusDone, StatusFailed, StatusCanceled:
+		return true
+	}
+	return false
+}
+
+// clone 返回任务的深拷贝，避免存储与调用方共享数据
+func (j *Job) clone() *Job {
+	c := *j
+	c.Files = make([]File, len(j.Files))
+	copy(c.Files, j.Files)
+	for i := range c.Files {
+		if v := c.Files[i].Verdict; v != nil {
+			verdict := *v
+			verdict.Signatures = append([]string(nil), v.Signatures...)
+			c.Files[i].Verdict = &verdict
+		}
+	}
+	return &c
+}
+
+// newID 生成随机的任务ID
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,411 @@
+package jobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/clamav"
+	"github.com/SmallGaoX/clamd-api/metrics"
+)
+
+// Options 任务管理器配置
+type Options struct {
+	Workers   int           // 并发执行任务的数量
+	QueueSize int           // 等待执行的任务上限
+	SpoolDir  string        // 上传文件的暂存目录
+	TTL       time.Duration // 已结束任务的保留时间，0 表示永久保留
+
+	// Acquire 在扫描每个文件前占用全局的并发扫描名额，与同步扫描接口共用上限；为 nil 时不限制
+	Acquire func(ctx context.Context) (release func(), err error)
+
+	// OnFinish 在任务完成或被取消后调用，用于推送回调
+	OnFinish func(job *Job)
+}
+
+// Upload 表示提交任务时上传的一个文件
+type Upload struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// Manager 使用有限的工作协程异步执行扫描任务
+type Manager struct {
+	scanner clamav.ContextScanner
+	store   Store
+	opts    Options
+	queue   chan string
+
+	mu      sync.Mutex
+	running map[string]*runningJob // 正在执行的任务
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// runningJob 是正在执行的任务
+type runningJob struct {
+	cancel context.CancelFunc
+	done   chan struct{} // 工作协程保存最终状态后关闭
+}
+
+// NewManager 创建任务管理器，恢复存储中未完成的任务并启动工作协程
+func NewManager(scanner clamav.ContextScanner, store Store, opts Options) (*Manager, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 100
+	}
+	if opts.SpoolDir == "" {
+		opts.SpoolDir = filepath.Join(os.TempDir(), "clamd-api-jobs")
+	}
+	if err := os.MkdirAll(opts.SpoolDir, 0700); err != nil {
+		return nil, fmt.Errorf("创建暂存目录失败: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		scanner: scanner,
+		store:   store,
+		opts:    opts,
+		queue:   make(chan string, opts.QueueSize),
+		running: make(map[string]*runningJob),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	if err := m.recover(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+	if opts.TTL > 0 {
+		m.wg.Add(1)
+		go m.expire()
+	}
+
+	return m, nil
+}
+
+// Submit 暂存上传的文件并创建任务，队列已满时返回 ErrQueueFull
+func (m *Manager) Submit(owner, callbackURL string, uploads []Upload) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, fmt.Errorf("生成任务ID失败: %v", err)
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:          id,
+		Owner:       owner,
+		CallbackURL: callbackURL,
+		Status:      StatusQueued,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	dir := m.spoolDir(id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("创建暂存目录失败: %v", err)
+	}
+
+	for i, upload := range uploads {
+		path := filepath.Join(dir, fmt.Sprintf("%d", i))
+		size, sum, err := spool(path, upload)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("暂存文件 %s 失败: %v", upload.Name, err)
+		}
+		job.Files = append(job.Files, File{Name: upload.Name, Size: size, SHA256: sum, SpoolPath: path})
+	}
+
+	if err := m.store.Save(job); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("保存任务失败: %v", err)
+	}
+
+	select {
+	case m.queue <- id:
+	default:
+		m.store.Delete(id)
+		os.RemoveAll(dir)
+		return nil, ErrQueueFull
+	}
+
+	return job, nil
+}
+
+// Get 获取任务
+func (m *Manager) Get(id string) (*Job, error) {
+	return m.store.Get(id)
+}
+
+// Cancel 取消任务；已结束的任务保持原状态
+//
+// 正在执行的任务等待工作协程中止扫描并记录取消状态后返回，返回的任务状态即为最终状态。
+func (m *Manager) Cancel(id string) (*Job, error) {
+	m.mu.Lock()
+	job, err := m.store.Get(id)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	switch job.Status {
+	case StatusQueued:
+		defer m.mu.Unlock()
+		job.Status = StatusCanceled
+		job.UpdatedAt = time.Now()
+		if err := m.store.Save(job); err != nil {
+			return nil, fmt.Errorf("保存任务失败: %v", err)
+		}
+		os.RemoveAll(m.spoolDir(id))
+		m.finished(job)
+		return job, nil
+	case StatusRunning:
+		running, exists := m.running[id]
+		m.mu.Unlock()
+		if !exists {
+			return job, nil
+		}
+
+		running.cancel()
+		select {
+		case <-running.done:
+		case <-m.ctx.Done():
+			// 服务正在关闭，任务在下次启动时重新执行
+			return job, nil
+		}
+		return m.store.Get(id)
+	default:
+		m.mu.Unlock()
+		return job, nil
+	}
+}
+
+// Close 停止所有工作协程，正在执行的任务在下次启动时重新执行
+func (m *Manager) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// recover 将上次退出时未完成的任务重新加入队列
+func (m *Manager) recover() error {
+	jobs, err := m.store.List()
+	if err != nil {
+		return fmt.Errorf("加载任务失败: %v", err)
+	}
+
+	for _, job := range jobs {
+		if job.Finished() {
+			continue
+		}
+
+		job.Status = StatusQueued
+		job.UpdatedAt = time.Now()
+		select {
+		case m.queue <- job.ID:
+		default:
+			job.Status = StatusFailed
+			job.Error = ErrQueueFull.Error()
+			os.RemoveAll(m.spoolDir(job.ID))
+		}
+		if err := m.store.Save(job); err != nil {
+			return fmt.Errorf("保存任务失败: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// worker 从队列中取出任务并执行
+func (m *Manager) worker() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case id := <-m.queue:
+			m.run(id)
+		}
+	}
+}
+
+// run 执行单个任务，每扫描完一个文件保存一次进度
+func (m *Manager) run(id string) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+
+	m.mu.Lock()
+	job, err := m.store.Get(id)
+	if err != nil || job.Status != StatusQueued {
+		m.mu.Unlock()
+		return
+	}
+	job.Status = StatusRunning
+	job.UpdatedAt = time.Now()
+	running := &runningJob{cancel: cancel, done: make(chan struct{})}
+	m.running[id] = running
+	err = m.store.Save(job)
+	m.mu.Unlock()
+	if err != nil {
+		slog.Error("保存任务失败", "jobId", id, "error", err)
+	}
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.running, id)
+		m.mu.Unlock()
+		close(running.done)
+	}()
+
+	for i := range job.Files {
+		file := &job.Files[i]
+		if file.Done {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		m.scanFile(ctx, file)
+		if ctx.Err() != nil {
+			// 被中止的文件保持未完成状态，重启后可以继续扫描
+			file.Done = false
+			file.Error = ""
+			break
+		}
+
+		job.UpdatedAt = time.Now()
+		if err := m.store.Save(job); err != nil {
+			slog.Error("保存任务进度失败", "jobId", id, "error", err)
+		}
+	}
+
+	if m.ctx.Err() != nil {
+		// 服务正在关闭，保留任务和暂存文件以便重启后继续
+		return
+	}
+
+	job.Status = StatusDone
+	if ctx.Err() != nil {
+		job.Status = StatusCanceled
+	}
+	job.UpdatedAt = time.Now()
+	if err := m.store.Save(job); err != nil {
+		slog.Error("保存任务失败", "jobId", id, "error", err)
+	}
+	os.RemoveAll(m.spoolDir(id))
+	m.finished(job)
+}
+
+// finished 通知任务已结束
+func (m *Manager) finished(job *Job) {
+	if m.opts.OnFinish != nil {
+		m.opts.OnFinish(job.clone())
+	}
+}
+
+// scanFile 扫描单个暂存文件并记录结果，等待并发扫描名额时 ctx 结束则不记录结果
+func (m *Manager) scanFile(ctx context.Context, file *File) {
+	if m.opts.Acquire != nil {
+		release, err := m.opts.Acquire(ctx)
+		if err != nil {
+			return
+		}
+		defer release()
+	}
+
+	f, err := os.Open(file.SpoolPath)
+	if err != nil {
+		file.Done = true
+		file.Error = fmt.Sprintf("打开暂存文件失败: %v", err)
+		return
+	}
+	defer f.Close()
+
+	done := metrics.TrackScan()
+	verdict, err := m.scanner.ScanStreamContext(ctx, f)
+	if err != nil {
+		done(string(clamav.StatusError))
+		file.Done = true
+		file.Error = fmt.Sprintf("扫描错误: %v", err)
+		return
+	}
+	done(string(verdict.Status))
+
+	file.Done = true
+	file.Verdict = &verdict
+}
+
+// expire 定期删除超过保留时间的已结束任务
+func (m *Manager) expire() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.opts.TTL / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		jobs, err := m.store.List()
+		if err != nil {
+			slog.Error("清理过期任务失败", "error", err)
+			continue
+		}
+		for _, job := range jobs {
+			if job.Finished() && time.Since(job.UpdatedAt) > m.opts.TTL {
+				m.store.Delete(job.ID)
+			}
+		}
+	}
+}
+
+// spoolDir 返回任务的暂存目录
+func (m *Manager) spoolDir(id string) string {
+	return filepath.Join(m.opts.SpoolDir, id)
+}
+
+// spool 将上传文件写入暂存路径，返回文件大小和内容的十六进制 SHA-256
+func spool(path string, upload Upload) (int64, string, error) {
+	src, err := upload.Open()
+	if err != nil {
+		return 0, "", err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, "", err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, h), src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, "", errors.Join(err, os.Remove(path))
+	}
+
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
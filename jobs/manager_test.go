@@ -0,0 +1,296 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/clamav"
+)
+
+// stubScanner 只实现流扫描：内容包含 "eicar" 时报告感染，block 非空时等待 context 取消
+type stubScanner struct {
+	clamav.ContextScanner
+	block chan struct{} // 开始扫描时关闭一次
+	once  sync.Once
+}
+
+func (s *stubScanner) ScanStreamContext(ctx context.Context, reader io.Reader) (clamav.Verdict, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return clamav.Verdict{}, err
+	}
+	if s.block != nil {
+		s.once.Do(func() { close(s.block) })
+		<-ctx.Done()
+		return clamav.Verdict{}, ctx.Err()
+	}
+	if strings.Contains(string(data), "eicar") {
+		return clamav.Verdict{Path: "stream", Status: clamav.StatusInfected, Signatures: []string{"Eicar-Test-Signature"}}, nil
+	}
+	return clamav.Verdict{Path: "stream", Status: clamav.StatusClean}, nil
+}
+
+// upload 返回内容为 content 的上传文件
+func upload(name, content string) Upload {
+	return Upload{Name: name, Open: func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(content)), nil
+	}}
+}
+
+// waitStatus 等待任务变为指定状态
+func waitStatus(t *testing.T, m *Manager, id string, want Status) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := m.Get(id)
+		if err == nil && job.Status == want {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("任务状态为 %+v, %v，期望 %s", job, err, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManagerSubmit(t *testing.T) {
+	finished := make(chan *Job, 1)
+	m, err := NewManager(&stubScanner{}, NewMemoryStore(), Options{
+		SpoolDir: t.TempDir(),
+		OnFinish: func(job *Job) { finished <- job },
+	})
+	if err != nil {
+		t.Fatalf("创建任务管理器失败: %v", err)
+	}
+	defer m.Close()
+
+	job, err := m.Submit("alice", "", []Upload{upload("a.txt", "hello"), upload("b.com", "eicar")})
+	if err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	if job.Status != StatusQueued || job.Owner != "alice" || len(job.Files) != 2 || job.Files[0].Size != 5 || job.Files[0].SHA256 == "" {
+		t.Fatalf("Submit = %+v", job)
+	}
+
+	done := waitStatus(t, m, job.ID, StatusDone)
+	if !done.Files[0].Done || !done.Files[0].Verdict.Clean() || !done.Files[1].Verdict.Infected() {
+		t.Errorf("扫描结果 = %+v", done.Files)
+	}
+	if got := <-finished; got.ID != job.ID || got.Status != StatusDone {
+		t.Errorf("OnFinish 收到 %+v", got)
+	}
+	if _, err := os.Stat(m.spoolDir(job.ID)); !os.IsNotExist(err) {
+		t.Errorf("任务结束后暂存目录仍然存在: %v", err)
+	}
+}
+
+func TestManagerCancelRunning(t *testing.T) {
+	scanner := &stubScanner{block: make(chan struct{})}
+	m, err := NewManager(scanner, NewMemoryStore(), Options{SpoolDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("创建任务管理器失败: %v", err)
+	}
+	defer m.Close()
+
+	job, err := m.Submit("alice", "", []Upload{upload("a.txt", "hello"), upload("b.txt", "world")})
+	if err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	<-scanner.block
+	// Cancel 等待工作协程记录取消状态后返回
+	canceled, err := m.Cancel(job.ID)
+	if err != nil {
+		t.Fatalf("取消任务失败: %v", err)
+	}
+	if canceled.Status != StatusCanceled {
+		t.Fatalf("Cancel 返回的任务状态为 %s", canceled.Status)
+	}
+	// 被中止的文件没有结论
+	for _, file := range canceled.Files {
+		if file.Done || file.Verdict != nil {
+			t.Errorf("被取消任务的文件 = %+v", file)
+		}
+	}
+
+	if _, err := m.Cancel("0123"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("取消不存在的任务返回 %v", err)
+	}
+}
+
+func TestManagerRecover(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建任务存储失败: %v", err)
+	}
+	spoolDir := t.TempDir()
+
+	// 模拟上次退出时一个任务正在执行、一个任务在排队、一个任务已结束
+	for _, job := range []*Job{
+		{ID: "aa", Status: StatusRunning},
+		{ID: "bb", Status: StatusQueued},
+		{ID: "cc", Status: StatusDone},
+	} {
+		dir := filepath.Join(spoolDir, job.ID)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "0")
+		if err := os.WriteFile(path, []byte("eicar"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		job.Files = []File{{Name: "a.com", SpoolPath: path}}
+		if err := store.Save(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 队列只能容纳一个任务，另一个未完成的任务因队列已满而失败
+	m, err := NewManager(&stubScanner{}, store, Options{SpoolDir: spoolDir, QueueSize: 1})
+	if err != nil {
+		t.Fatalf("创建任务管理器失败: %v", err)
+	}
+	defer m.Close()
+
+	var done, failed int
+	deadline := time.Now().Add(5 * time.Second)
+	for done+failed < 2 && time.Now().Before(deadline) {
+		done, failed = 0, 0
+		for _, id := range []string{"aa", "bb"} {
+			job, err := m.Get(id)
+			if err != nil {
+				t.Fatalf("获取任务失败: %v", err)
+			}
+			switch job.Status {
+			case StatusDone:
+				if !job.Files[0].Verdict.Infected() {
+					t.Errorf("恢复的任务结论 = %+v", job.Files[0])
+				}
+				done++
+			case StatusFailed:
+				if job.Error != ErrQueueFull.Error() {
+					t.Errorf("失败原因 = %q", job.Error)
+				}
+				failed++
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if done != 1 || failed != 1 {
+		t.Errorf("恢复后完成 %d 个、失败 %d 个任务，期望各 1 个", done, failed)
+	}
+
+	if job, err := m.Get("cc"); err != nil || job.Status != StatusDone || job.Files[0].Done {
+		t.Errorf("已结束的任务被重新执行: %+v, %v", job, err)
+	}
+}
+
+func TestManagerExpire(t *testing.T) {
+	m, err := NewManager(&stubScanner{}, NewMemoryStore(), Options{SpoolDir: t.TempDir(), TTL: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("创建任务管理器失败: %v", err)
+	}
+	defer m.Close()
+
+	job, err := m.Submit("alice", "", []Upload{upload("a.txt", "hello")})
+	if err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	waitStatus(t, m, job.ID, StatusDone)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := m.Get(job.ID); errors.Is(err, ErrNotFound) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("已结束的任务超过保留时间后没有被删除")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagerAcquire(t *testing.T) {
+	var mu sync.Mutex
+	active, peak, calls := 0, 0, 0
+	slots := make(chan struct{}, 1)
+	acquire := func(ctx context.Context) (func(), error) {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		mu.Lock()
+		active++
+		calls++
+		peak = max(peak, active)
+		mu.Unlock()
+		return func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+			<-slots
+		}, nil
+	}
+
+	m, err := NewManager(&stubScanner{}, NewMemoryStore(), Options{SpoolDir: t.TempDir(), Workers: 3, Acquire: acquire})
+	if err != nil {
+		t.Fatalf("创建任务管理器失败: %v", err)
+	}
+	defer m.Close()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		job, err := m.Submit("alice", "", []Upload{upload("a.txt", "hello"), upload("b.txt", "world")})
+		if err != nil {
+			t.Fatalf("提交任务失败: %v", err)
+		}
+		ids = append(ids, job.ID)
+	}
+	for _, id := range ids {
+		waitStatus(t, m, id, StatusDone)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 6 || peak != 1 {
+		t.Errorf("占用名额 %d 次，最多同时扫描 %d 个文件，期望 6 次、1 个", calls, peak)
+	}
+}
+
+func TestManagerCancelWaiting(t *testing.T) {
+	waiting := make(chan struct{})
+	var once sync.Once
+	acquire := func(ctx context.Context) (func(), error) {
+		// 名额一直被占用
+		once.Do(func() { close(waiting) })
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	m, err := NewManager(&stubScanner{}, NewMemoryStore(), Options{SpoolDir: t.TempDir(), Acquire: acquire})
+	if err != nil {
+		t.Fatalf("创建任务管理器失败: %v", err)
+	}
+	defer m.Close()
+
+	job, err := m.Submit("alice", "", []Upload{upload("a.txt", "hello")})
+	if err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	<-waiting
+	if _, err := m.Cancel(job.ID); err != nil {
+		t.Fatalf("取消任务失败: %v", err)
+	}
+
+	canceled := waitStatus(t, m, job.ID, StatusCanceled)
+	if file := canceled.Files[0]; file.Done || file.Verdict != nil || file.Error != "" {
+		t.Errorf("等待名额时被取消的文件 = %+v", file)
+	}
+}
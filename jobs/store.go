@@ -0,0 +1,181 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store 定义任务的持久化方式
+type Store interface {
+	Save(job *Job) error
+	Get(id string) (*Job, error)
+	List() ([]*Job, error)
+	Delete(id string) error
+}
+
+// MemoryStore 将任务保存在内存中，进程重启后丢失
+type MemoryStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+// NewMemoryStore 创建一个内存任务存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]*Job)}
+}
+
+// Save 保存任务
+func (s *MemoryStore) Save(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job.clone()
+	return nil
+}
+
+// Get 获取任务
+func (s *MemoryStore) Get(id string) (*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, exists := s.jobs[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return job.clone(), nil
+}
+
+// List 列出所有任务
+func (s *MemoryStore) List() ([]*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.clone())
+	}
+	return jobs, nil
+}
+
+// Delete 删除任务
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+// FileStore 将每个任务保存为目录下的一个 JSON 文件，进程重启后任务仍然存在
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore 创建一个磁盘任务存储，目录不存在时自动创建
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("创建任务目录失败: %v", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path 返回任务文件路径
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// Save 以先写临时文件再重命名的方式保存任务
+func (s *FileStore) Save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("序列化任务失败: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(s.dir, job.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("创建任务文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入任务文件失败: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入任务文件失败: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), s.path(job.ID))
+}
+
+// Get 获取任务
+func (s *FileStore) Get(id string) (*Job, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取任务文件失败: %v", err)
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("解析任务文件失败: %v", err)
+	}
+	return &job, nil
+}
+
+// List 列出所有任务
+func (s *FileStore) List() ([]*Job, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取任务目录失败: %v", err)
+	}
+
+	var jobs []*Job
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		job, err := s.Get(id)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Delete 删除任务
+func (s *FileStore) Delete(id string) error {
+	if !validID(id) {
+		return nil
+	}
+	err := os.Remove(s.path(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// validID 检查任务ID只包含十六进制字符，防止路径穿越
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
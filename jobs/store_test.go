@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("创建任务存储失败: %v", err)
+	}
+
+	job := &Job{ID: "0a1b", Owner: "alice", Status: StatusQueued, Files: []File{{Name: "a.txt"}}, CreatedAt: time.Now()}
+	if err := store.Save(job); err != nil {
+		t.Fatalf("保存任务失败: %v", err)
+	}
+	got, err := store.Get(job.ID)
+	if err != nil || got.Owner != "alice" || len(got.Files) != 1 {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+
+	// 写入过程中的临时文件不应留下
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "0a1b.json" {
+		t.Errorf("任务目录内容 = %v", entries)
+	}
+
+	if jobs, err := store.List(); err != nil || len(jobs) != 1 {
+		t.Errorf("List = %v, %v", jobs, err)
+	}
+	if err := store.Delete(job.ID); err != nil {
+		t.Fatalf("删除任务失败: %v", err)
+	}
+	if _, err := store.Get(job.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("删除后 Get 返回 %v", err)
+	}
+}
+
+func TestFileStoreRejectsTraversal(t *testing.T) {
+	base := t.TempDir()
+	store, err := NewFileStore(filepath.Join(base, "jobs"))
+	if err != nil {
+		t.Fatalf("创建任务存储失败: %v", err)
+	}
+
+	// 任务目录之外的 JSON 文件
+	secret := filepath.Join(base, "secret.json")
+	if err := os.WriteFile(secret, []byte(`{"id":"secret","owner":"bob"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"../secret", "..", "", "ABCD", "0a/../../secret", "/etc/passwd"} {
+		if _, err := store.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) 返回 %v，期望 ErrNotFound", id, err)
+		}
+		store.Delete(id)
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Errorf("任务目录之外的文件被删除: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Package logging 配置基于 log/slog 的结构化日志：可选的级别和输出格式、
+// 自动附加请求 ID，以及对密钥等敏感字段的脱敏
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// Redacted 是脱敏后输出的值
+const Redacted = "[REDACTED]"
+
+// Options 是日志配置
+type Options struct {
+	Level  string // debug、info、warn 或 error，为空时为 info
+	Format string // text 或 json，为空时为 text
+}
+
+// New 创建写入 w 的 logger，日志带有请求 ID 并对敏感字段脱敏
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerOpts := &slog.HandlerOptions{
+		Level:       level,
+		AddSource:   level <= slog.LevelDebug,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("未知的日志格式 '%s'，可用的格式: text、json", opts.Format)
+	}
+
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+// ParseLevel 解析日志级别，为空时为 info
+func ParseLevel(s string) (slog.Level, error) {
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("未知的日志级别 '%s'，可用的级别: debug、info、warn、error", s)
+	}
+	return level, nil
+}
+
+// requestIDKey 是请求 ID 在 context 中的键
+type requestIDKey struct{}
+
+// WithRequestID 返回带有请求 ID 的 context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID 返回 context 中的请求 ID，没有时返回空字符串
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler 为使用 *Context 方法记录的日志附加 context 中的请求 ID
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle 附加请求 ID 后交给底层 handler
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("requestId", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs 保持请求 ID 的附加
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup 保持请求 ID 的附加
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// sensitiveNames 是需要脱敏的字段名或查询参数名中包含的词（不区分大小写）
+var sensitiveNames = []string{"apikey", "api_key", "api-key", "token", "secret", "password", "authorization", "signature"}
+
+// IsSensitive 判断字段名或查询参数名是否可能包含敏感信息
+func IsSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveNames {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAttr 将敏感字段的值替换为 Redacted
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() != slog.KindGroup && IsSensitive(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
+
+// RedactURL 将 URL 中的用户信息和敏感查询参数替换为 Redacted，无法解析时整体脱敏
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Redacted
+	}
+	if u.User != nil {
+		u.User = url.User(Redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if IsSensitive(name) {
+				query[name] = []string{Redacted}
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import "time"
+
+// Default 是服务使用的指标注册表，由 /metrics 输出
+var Default = NewRegistry()
+
+// 服务指标；API key 只以名称作为标签，不会出现 key 本身
+var (
+	HTTPRequests = Default.NewCounter("clamd_api_http_requests_total",
+		"HTTP 请求数", "route", "method", "status")
+	HTTPDuration = Default.NewHistogram("clamd_api_http_request_duration_seconds",
+		"HTTP 请求耗时（秒）", DefBuckets, "route", "status")
+	APIKeyRequests = Default.NewCounter("clamd_api_key_requests_total",
+		"各 API key 通过认证的请求数", "key")
+	RateLimited = Default.NewCounter("clamd_api_rate_limited_total",
+		"因限流、每日配额或并发上限被拒绝的请求数", "key", "reason")
+
+	Scans = Default.NewCounter("clamd_api_scans_total",
+		"发送给 clamd 的扫描数，按结论区分", "verdict")
+	ScansInFlight = Default.NewGauge("clamd_api_scans_in_flight",
+		"正在进行的扫描数")
+	ScanDuration = Default.NewHistogram("clamd_api_scan_duration_seconds",
+		"单个文件的扫描耗时（秒）", DefBuckets)
+	CacheHits = Default.NewCounter("clamd_api_cache_hits_total",
+		"命中扫描结论缓存、未发送给 clamd 的扫描数")
+	StreamedBytes = Default.NewCounter("clamd_api_streamed_bytes_total",
+		"通过 INSTREAM 发送给 clamd 的字节数")
+	ClamdConnectionErrors = Default.NewCounter("clamd_api_clamd_connection_errors_total",
+		"与 clamd 建立连接或会话失败的次数", "backend")
+	SignatureAge = Default.NewGauge("clamd_api_signature_age_seconds",
+		"病毒库发布至今的时长（秒），在调用 /version 或 /readyz 时更新")
+
+	// 以下指标来自各后端的 STATS，随健康检查和 /clamd/stats 更新
+	ClamdThreads = Default.NewGauge("clamd_api_clamd_threads",
+		"clamd 线程池的线程数，state 为 live、idle 或 max", "backend", "state")
+	ClamdQueueLength = Default.NewGauge("clamd_api_clamd_queue_length",
+		"clamd 队列中等待执行的命令数", "backend")
+	ClamdMemoryBytes = Default.NewGauge("clamd_api_clamd_memory_bytes",
+		"clamd 的内存使用（字节），type 为 heap、mmap、used、free、releasable、pools_used 或 pools_total", "backend", "type")
+)
+
+// TrackScan 记录一次扫描开始，返回的函数在扫描结束时以结论（clean、infected 或 error）调用
+func TrackScan() func(verdict string) {
+	start := time.Now()
+	ScansInFlight.Inc()
+
+	return func(verdict string) {
+		ScansInFlight.Dec()
+		ScanDuration.Observe(time.Since(start).Seconds())
+		Scans.Inc(verdict)
+	}
+}
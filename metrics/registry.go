@@ -0,0 +1,301 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets 是默认的延迟直方图分桶（秒）
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry 保存一组指标，并以 Prometheus 文本格式输出
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	names    map[string]bool
+}
+
+// NewRegistry 创建一个空的指标注册表
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// Counter 是只增不减的计数器
+type Counter struct{ f *family }
+
+// Gauge 是可增可减的数值
+type Gauge struct{ f *family }
+
+// Histogram 按分桶统计观测值的分布
+type Histogram struct{ f *family }
+
+// family 是同名指标的所有标签组合
+type family struct {
+	name    string
+	help    string
+	kind    string // counter、gauge 或 histogram
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series 是一组标签值对应的数据
+type series struct {
+	values []string
+	value  float64  // counter 和 gauge 的值
+	counts []uint64 // histogram 各分桶的计数（不累加）
+	sum    float64
+	count  uint64
+}
+
+// NewCounter 注册计数器，labels 为标签名
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels, nil)}
+}
+
+// NewGauge 注册数值指标，labels 为标签名
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels, nil)}
+}
+
+// NewHistogram 注册直方图，buckets 为空时使用 DefBuckets
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(name, help, "histogram", labels, buckets)}
+}
+
+// register 注册指标，重名时 panic
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic(fmt.Sprintf("指标 %s 重复注册", name))
+	}
+	r.names[name] = true
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// Inc 将计数器加一
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 将计数器增加 v，v 不能为负数
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.with(labelValues, func(s *series) { s.value += v })
+}
+
+// Set 设置数值
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) { s.value = v })
+}
+
+// Add 增加数值，v 可以为负数
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) { s.value += v })
+}
+
+// Inc 将数值加一
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec 将数值减一
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.with(labelValues, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.f.buckets))
+		}
+		if i := sort.SearchFloat64s(h.f.buckets, v); i < len(h.f.buckets) {
+			s.counts[i]++
+		}
+		s.sum += v
+		s.count++
+	})
+}
+
+// with 找到标签值对应的数据并在加锁状态下修改
+func (f *family) with(labelValues []string, fn func(s *series)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("指标 %s 需要 %d 个标签值，实际为 %d 个", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{values: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+// WriteTo 以 Prometheus 文本格式输出所有指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// Handler 返回输出指标的 HTTP 处理程序
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	}
+}
+
+// write 输出一个指标的所有数据，按标签值排序
+func (f *family) write(w *countingWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// 没有标签的指标即使从未更新也输出 0
+	if len(f.labels) == 0 && len(keys) == 0 {
+		f.writeSeries(w, &series{counts: make([]uint64, len(f.buckets))})
+		return
+	}
+
+	for _, key := range keys {
+		f.writeSeries(w, f.series[key])
+	}
+}
+
+// writeSeries 输出一组标签值对应的数据
+func (f *family) writeSeries(w *countingWriter, s *series) {
+	if f.kind != "histogram" {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.values, "", ""), formatValue(s.value))
+		return
+	}
+
+	var cumulative uint64
+	for i, bound := range f.buckets {
+		if s.counts != nil {
+			cumulative += s.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.values, "le", formatValue(bound)), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.values, "le", "+Inf"), s.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.values, "", ""), formatValue(s.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.values, "", ""), s.count)
+}
+
+// formatLabels 生成 {name="value",...}，extraName 不为空时追加一个标签
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// formatValue 按 Prometheus 的要求格式化数值
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+// countingWriter 记录写入的字节数和第一个错误
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("test_requests_total", "请求数", "route", "status")
+	inflight := r.NewGauge("test_in_flight", "进行中的请求")
+	duration := r.NewHistogram("test_duration_seconds", "耗时", []float64{0.1, 1})
+	r.NewCounter("test_unused_total", "未使用的带标签计数器", "key")
+
+	requests.Inc("/scan", "200")
+	requests.Inc("/scan", "200")
+	requests.Add(3, "/stream", "500")
+	requests.Add(-1, "/stream", "500") // 计数器不能减少
+	requests.Inc("/a\"b", "200")
+	inflight.Inc()
+	inflight.Inc()
+	inflight.Dec()
+	duration.Observe(0.05)
+	duration.Observe(0.5)
+	duration.Observe(5)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo 失败: %v", err)
+	}
+
+	want := `# HELP test_requests_total 请求数
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b",status="200"} 1
+test_requests_total{route="/scan",status="200"} 2
+test_requests_total{route="/stream",status="500"} 3
+# HELP test_in_flight 进行中的请求
+# TYPE test_in_flight gauge
+test_in_flight 1
+# HELP test_duration_seconds 耗时
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 5.55
+test_duration_seconds_count 3
+# HELP test_unused_total 未使用的带标签计数器
+# TYPE test_unused_total counter
+`
+	if b.String() != want {
+		t.Errorf("输出不符合预期:\n%s\n期望:\n%s", b.String(), want)
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "计数").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type 为 %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "test_total 1\n") {
+		t.Errorf("输出中缺少指标:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/metrics", nil))
+	if rec.Code != 405 {
+		t.Errorf("POST 返回 %d，期望 405", rec.Code)
+	}
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "计数")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("期望重复注册时 panic")
+		}
+	}()
+	r.NewGauge("test_total", "计数")
+}
@@ -0,0 +1,283 @@
+// Package ratelimit 按 API key 名称实现令牌桶限流和每日上传字节配额，以及全局的并发扫描上限
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded 表示 API key 当天上传的字节数超过配额
+var ErrQuotaExceeded = errors.New("超过每日上传配额")
+
+// Limit 是一个 API key 的限制，各项为0时表示不限制
+type Limit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"` // 令牌桶每秒补充的令牌数
+	Burst             int     `json:"burst,omitempty"`             // 令牌桶容量，为0时取 RequestsPerSecond 向上取整
+	DailyBytes        int64   `json:"dailyBytes,omitempty"`        // 每天（UTC）最多上传的字节数
+}
+
+// burst 返回令牌桶容量
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.RequestsPerSecond))
+}
+
+// Options 是限流器的配置
+type Options struct {
+	Default       Limit            // 未单独配置的 API key 使用的限制
+	Keys          map[string]Limit // 按 API key 名称（不区分大小写）单独配置的限制，覆盖 Default
+	MaxConcurrent int              // 同时进行的扫描请求上限，为0时不限制
+}
+
+// Decision 是一次检查的结果，用于设置 X-RateLimit-* 响应头
+type Decision struct {
+	Allowed    bool
+	Limit      int64         // 令牌桶容量或每日配额
+	Remaining  int64         // 剩余令牌数或剩余字节数
+	Reset      time.Duration // 令牌桶补满或配额重置前的时长
+	RetryAfter time.Duration // 被拒绝时建议的重试间隔
+}
+
+// Usage 是一个 API key 当天的用量
+type Usage struct {
+	Key            string `json:"key"`
+	Day            string `json:"day"`      // UTC 日期
+	Requests       int64  `json:"requests"` // 通过限流的请求数
+	Limited        int64  `json:"limited"`  // 被限流或超过配额而拒绝的请求数
+	Bytes          int64  `json:"bytes"`    // 已上传的字节数
+	Limit          Limit  `json:"limit"`
+	BytesRemaining *int64 `json:"bytesRemaining,omitempty"` // 未配置配额时为空
+}
+
+// keyState 是一个 API key 的令牌桶和当天的计数
+type keyState struct {
+	tokens   float64
+	updated  time.Time
+	day      string
+	requests int64
+	limited  int64
+	bytes    int64
+}
+
+// Limiter 按 API key 名称（不区分大小写）限流，计数只保存在内存中，重启后重置
+type Limiter struct {
+	opts  Options
+	now   func() time.Time
+	mu    sync.Mutex
+	keys  map[string]*keyState // 键是小写的 API key 名称，与 LimitFor 一致
+	slots chan struct{}        // 并发扫描的信号量，MaxConcurrent 为0时为 nil
+}
+
+// New 创建一个新的限流器
+func New(opts Options) *Limiter {
+	keys := make(map[string]Limit, len(opts.Keys))
+	for name, limit := range opts.Keys {
+		keys[strings.ToLower(name)] = limit
+	}
+	opts.Keys = keys
+
+	l := &Limiter{
+		opts: opts,
+		now:  time.Now,
+		keys: make(map[string]*keyState),
+	}
+	if opts.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, opts.MaxConcurrent)
+	}
+	return l
+}
+
+// LimitFor 返回指定 API key 的限制
+func (l *Limiter) LimitFor(key string) Limit {
+	if limit, exists := l.opts.Keys[strings.ToLower(key)]; exists {
+		return limit
+	}
+	return l.opts.Default
+}
+
+// state 返回 API key 的状态，不存在时创建，跨天时重置计数，调用方需持有锁
+func (l *Limiter) state(key string, now time.Time) *keyState {
+	key = strings.ToLower(key)
+	day := now.UTC().Format(time.DateOnly)
+	s, exists := l.keys[key]
+	if !exists {
+		s = &keyState{tokens: l.LimitFor(key).burst(), updated: now, day: day}
+		l.keys[key] = s
+	}
+	if s.day != day {
+		s.day, s.requests, s.limited, s.bytes = day, 0, 0, 0
+	}
+	return s
+}
+
+// Allow 从 API key 的令牌桶中取出一个令牌，未配置速率时总是允许
+func (l *Limiter) Allow(key string) Decision {
+	limit := l.LimitFor(key)
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state(key, now)
+	if limit.RequestsPerSecond <= 0 {
+		s.requests++
+		return Decision{Allowed: true}
+	}
+
+	burst := limit.burst()
+	s.tokens = math.Min(burst, s.tokens+now.Sub(s.updated).Seconds()*limit.RequestsPerSecond)
+	s.updated = now
+
+	decision := Decision{Limit: int64(burst)}
+	if s.tokens >= 1 {
+		s.tokens--
+		s.requests++
+		decision.Allowed = true
+	} else {
+		s.limited++
+		decision.RetryAfter = seconds((1 - s.tokens) / limit.RequestsPerSecond)
+	}
+	decision.Remaining = int64(s.tokens)
+	decision.Reset = seconds((burst - s.tokens) / limit.RequestsPerSecond)
+	return decision
+}
+
+// CheckQuota 检查 API key 当天再上传 size 字节是否会超过配额，未配置配额时总是允许
+func (l *Limiter) CheckQuota(key string, size int64) Decision {
+	limit := l.LimitFor(key)
+	if limit.DailyBytes <= 0 {
+		return Decision{Allowed: true}
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state(key, now)
+	decision := Decision{
+		Limit:     limit.DailyBytes,
+		Remaining: max(0, limit.DailyBytes-s.bytes),
+		Reset:     untilTomorrow(now),
+	}
+	if s.bytes+max(size, 0) <= limit.DailyBytes && s.bytes < limit.DailyBytes {
+		decision.Allowed = true
+	} else {
+		s.limited++
+		decision.RetryAfter = decision.Reset
+	}
+	return decision
+}
+
+// AddBytes 记录 API key 上传的字节数，超过配额时返回 ErrQuotaExceeded
+func (l *Limiter) AddBytes(key string, n int64) error {
+	limit := l.LimitFor(key)
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state(key, now)
+	s.bytes += n
+	if limit.DailyBytes > 0 && s.bytes > limit.DailyBytes {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Acquire 占用一个并发扫描名额，没有空闲名额时立即返回 false；成功时需调用返回的函数释放
+func (l *Limiter) Acquire() (func(), bool) {
+	if l.slots == nil {
+		return func() {}, true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, true
+	default:
+		return nil, false
+	}
+}
+
+// Wait 与 Acquire 共用并发扫描名额，没有空闲名额时等待，直到获得名额或 ctx 结束；成功时需调用返回的函数释放
+func (l *Limiter) Wait(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Usage 返回 API key 当天的用量，只查询不会为没有请求过的 API key 创建状态
+func (l *Limiter) Usage(key string) Usage {
+	key = strings.ToLower(key)
+	now := l.now()
+	day := now.UTC().Format(time.DateOnly)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := keyState{day: day}
+	if existing, exists := l.keys[key]; exists && existing.day == day {
+		s = *existing
+	}
+	return l.usage(key, &s)
+}
+
+// AllUsage 返回当天有请求的所有 API key 的用量，按名称排序
+func (l *Limiter) AllUsage() []Usage {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	usage := make([]Usage, 0, len(l.keys))
+	for key := range l.keys {
+		s := l.state(key, now)
+		if s.requests == 0 && s.limited == 0 && s.bytes == 0 {
+			continue
+		}
+		usage = append(usage, l.usage(key, s))
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Key < usage[j].Key })
+	return usage
+}
+
+// usage 将状态转换为用量，调用方需持有锁
+func (l *Limiter) usage(key string, s *keyState) Usage {
+	limit := l.LimitFor(key)
+	usage := Usage{
+		Key:      key,
+		Day:      s.day,
+		Requests: s.requests,
+		Limited:  s.limited,
+		Bytes:    s.bytes,
+		Limit:    limit,
+	}
+	if limit.DailyBytes > 0 {
+		remaining := max(0, limit.DailyBytes-s.bytes)
+		usage.BytesRemaining = &remaining
+	}
+	return usage
+}
+
+// untilTomorrow 返回距离下一个 UTC 零点的时长
+func untilTomorrow(now time.Time) time.Duration {
+	now = now.UTC()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return tomorrow.Sub(now)
+}
+
+// seconds 将秒数转换为时长
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,231 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestLimiter 创建使用可控时钟的限流器
+func newTestLimiter(opts Options) (*Limiter, *time.Time) {
+	now := time.Date(2024, 10, 1, 23, 59, 0, 0, time.UTC)
+	l := New(opts)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestAllowTokenBucket(t *testing.T) {
+	l, now := newTestLimiter(Options{Default: Limit{RequestsPerSecond: 1, Burst: 2}})
+
+	for i := 0; i < 2; i++ {
+		if d := l.Allow("alice"); !d.Allowed {
+			t.Fatalf("第 %d 个请求被拒绝", i+1)
+		}
+	}
+
+	d := l.Allow("alice")
+	if d.Allowed {
+		t.Fatal("令牌耗尽后请求仍被允许")
+	}
+	if d.Limit != 2 || d.Remaining != 0 || d.RetryAfter != time.Second {
+		t.Errorf("decision = %+v", d)
+	}
+
+	// 其他 API key 有独立的令牌桶
+	if d := l.Allow("bob"); !d.Allowed {
+		t.Error("其他 API key 的请求被拒绝")
+	}
+
+	*now = now.Add(1500 * time.Millisecond)
+	if d := l.Allow("alice"); !d.Allowed {
+		t.Error("补充令牌后请求被拒绝")
+	}
+
+	if u := l.Usage("alice"); u.Requests != 3 || u.Limited != 1 {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestAllowPerKeyLimit(t *testing.T) {
+	l, _ := newTestLimiter(Options{
+		Default: Limit{RequestsPerSecond: 1},
+		Keys:    map[string]Limit{"Batch": {}},
+	})
+
+	// 单独配置为不限制的 API key，名称不区分大小写
+	for i := 0; i < 10; i++ {
+		if d := l.Allow("batch"); !d.Allowed || d.Limit != 0 {
+			t.Fatalf("decision = %+v", d)
+		}
+	}
+
+	if d := l.Allow("other"); !d.Allowed || d.Limit != 1 {
+		t.Fatalf("decision = %+v", d)
+	}
+	if d := l.Allow("other"); d.Allowed {
+		t.Fatal("超过默认限制的请求被允许")
+	}
+}
+
+func TestKeyNameCaseInsensitive(t *testing.T) {
+	l, _ := newTestLimiter(Options{Default: Limit{RequestsPerSecond: 1, DailyBytes: 100}})
+
+	// 大小写不同的名称共用同一个令牌桶和配额
+	if d := l.Allow("CI"); !d.Allowed {
+		t.Fatalf("decision = %+v", d)
+	}
+	if d := l.Allow("ci"); d.Allowed {
+		t.Fatal("大小写不同的名称使用了单独的令牌桶")
+	}
+	if err := l.AddBytes("Ci", 80); err != nil {
+		t.Fatal(err)
+	}
+	if d := l.CheckQuota("cI", 30); d.Allowed || d.Remaining != 20 {
+		t.Fatalf("decision = %+v", d)
+	}
+
+	usage := l.Usage("CI")
+	if usage.Key != "ci" || usage.Requests != 1 || usage.Limited != 2 || usage.Bytes != 80 {
+		t.Errorf("usage = %+v", usage)
+	}
+	if all := l.AllUsage(); len(all) != 1 {
+		t.Errorf("AllUsage = %+v", all)
+	}
+}
+
+func TestUsageDoesNotCreateState(t *testing.T) {
+	l, now := newTestLimiter(Options{Default: Limit{DailyBytes: 100}})
+
+	for i := 0; i < 100; i++ {
+		if usage := l.Usage(fmt.Sprintf("unknown-%d", i)); usage.Requests != 0 || *usage.BytesRemaining != 100 {
+			t.Fatalf("usage = %+v", usage)
+		}
+	}
+	if len(l.keys) != 0 {
+		t.Errorf("查询用量创建了 %d 个状态", len(l.keys))
+	}
+
+	// 前一天的计数不计入当天
+	l.AddBytes("alice", 60)
+	*now = now.Add(time.Hour)
+	if usage := l.Usage("alice"); usage.Bytes != 0 || usage.Day != now.UTC().Format(time.DateOnly) {
+		t.Errorf("跨天后 usage = %+v", usage)
+	}
+}
+
+func TestDailyQuota(t *testing.T) {
+	l, now := newTestLimiter(Options{Default: Limit{DailyBytes: 100}})
+
+	if d := l.CheckQuota("alice", 60); !d.Allowed || d.Remaining != 100 {
+		t.Fatalf("decision = %+v", d)
+	}
+	if err := l.AddBytes("alice", 60); err != nil {
+		t.Fatal(err)
+	}
+
+	d := l.CheckQuota("alice", 60)
+	if d.Allowed {
+		t.Fatal("超过配额的上传被允许")
+	}
+	if d.Remaining != 40 || d.RetryAfter != time.Minute {
+		t.Errorf("decision = %+v", d)
+	}
+
+	// 长度未知的请求在读取时计数
+	if d := l.CheckQuota("alice", -1); !d.Allowed {
+		t.Fatal("配额未用完时长度未知的请求被拒绝")
+	}
+	if err := l.AddBytes("alice", 50); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("AddBytes 返回 %v，期望 ErrQuotaExceeded", err)
+	}
+
+	u := l.Usage("alice")
+	if u.Bytes != 110 || u.BytesRemaining == nil || *u.BytesRemaining != 0 {
+		t.Errorf("usage = %+v", u)
+	}
+
+	// UTC 零点后重置
+	*now = now.Add(time.Minute)
+	if d := l.CheckQuota("alice", 100); !d.Allowed {
+		t.Fatal("第二天的上传被拒绝")
+	}
+	if u := l.Usage("alice"); u.Bytes != 0 || u.Day != "2024-10-02" {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	l := New(Options{MaxConcurrent: 1})
+
+	release, ok := l.Acquire()
+	if !ok {
+		t.Fatal("第一个请求没有获得名额")
+	}
+	if _, ok := l.Acquire(); ok {
+		t.Fatal("超过并发上限的请求获得了名额")
+	}
+	release()
+	if _, ok := l.Acquire(); !ok {
+		t.Fatal("释放后没有获得名额")
+	}
+
+	unlimited := New(Options{})
+	for i := 0; i < 100; i++ {
+		if _, ok := unlimited.Acquire(); !ok {
+			t.Fatal("未配置并发上限时请求被拒绝")
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	l := New(Options{MaxConcurrent: 1})
+
+	release, ok := l.Acquire()
+	if !ok {
+		t.Fatal("第一个请求没有获得名额")
+	}
+
+	// 名额被占用时等待，ctx 结束后返回错误
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait 返回 %v，期望超时", err)
+	}
+
+	// 名额释放后等待中的调用获得名额，且与 Acquire 共用上限
+	acquired := make(chan func())
+	go func() {
+		release, err := l.Wait(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- release
+	}()
+	release()
+	release = <-acquired
+	if _, ok := l.Acquire(); ok {
+		t.Fatal("Wait 占用名额时 Acquire 仍然成功")
+	}
+	release()
+
+	if _, err := New(Options{}).Wait(ctx); err != nil {
+		t.Errorf("未配置并发上限时 Wait 返回 %v", err)
+	}
+}
+
+func TestAllUsage(t *testing.T) {
+	l, _ := newTestLimiter(Options{})
+	l.Allow("bob")
+	l.Allow("alice")
+	l.Usage("carol") // 只查询、没有请求的 API key 不出现在列表中
+
+	usage := l.AllUsage()
+	if len(usage) != 2 || usage[0].Key != "alice" || usage[1].Key != "bob" {
+		t.Fatalf("usage = %+v", usage)
+	}
+	if usage[0].BytesRemaining != nil {
+		t.Error("未配置配额时 BytesRemaining 应为空")
+	}
+}
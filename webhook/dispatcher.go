@@ -0,0 +1,359 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SmallGaoX/clamd-api/logging"
+)
+
+// 事件类型
+const (
+	EventScanCompleted = "scan.completed" // 扫描请求或扫描任务完成
+	EventThreatFound   = "threat.found"   // 扫描发现威胁
+)
+
+// 请求头
+const (
+	HeaderSignature = "X-Clamd-Signature" // "sha256=<十六进制 HMAC>"
+	HeaderTimestamp = "X-Clamd-Timestamp" // 签名时使用的 Unix 时间戳
+	HeaderEvent     = "X-Clamd-Event"
+	HeaderDelivery  = "X-Clamd-Delivery"
+)
+
+// DeliveryStatus 表示投递状态
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"   // 等待投递或重试中
+	DeliveryDelivered DeliveryStatus = "delivered" // 接收方返回 2xx
+	DeliveryFailed    DeliveryStatus = "failed"    // 重试次数用尽或接收方拒绝
+)
+
+// ErrQueueFull 表示投递队列已满
+var ErrQueueFull = errors.New("webhook 投递队列已满")
+
+// Payload 是 POST 给接收方的 JSON 内容
+type Payload struct {
+	Event      string    `json:"event"`
+	DeliveryID string    `json:"deliveryId"`
+	Timestamp  time.Time `json:"timestamp"`
+	Data       any       `json:"data"`
+}
+
+// Delivery 记录一次 webhook 投递
+type Delivery struct {
+	ID             string         `json:"id"`
+	Owner          string         `json:"owner"` // 触发投递的 API key 名称
+	URL            string         `json:"url"`
+	Event          string         `json:"event"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	LastStatusCode int            `json:"lastStatusCode,omitempty"`
+	LastError      string         `json:"lastError,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	UpdatedAt      time.Time      `json:"updatedAt"`
+
+	body []byte
+}
+
+// Options 投递配置
+type Options struct {
+	MaxAttempts    int           // 最大尝试次数（含首次）
+	InitialBackoff time.Duration // 首次重试前的等待时间，之后每次翻倍
+	MaxBackoff     time.Duration // 重试等待时间上限
+	Timeout        time.Duration // 单次请求超时
+	Workers        int           // 并发投递数
+	QueueSize      int           // 等待投递的上限
+	LogSize        int           // 投递记录保留条数
+	Policy         *Policy       // 允许投递的地址，为 nil 时拒绝所有非公网地址
+}
+
+// DefaultOptions 返回默认投递配置
+func DefaultOptions() Options {
+	return Options{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Timeout:        10 * time.Second,
+		Workers:        4,
+		QueueSize:      1000,
+		LogSize:        1000,
+	}
+}
+
+// Dispatcher 异步投递签名的 webhook，失败时按指数退避重试
+type Dispatcher struct {
+	secret []byte
+	opts   Options
+	policy *Policy
+	client *http.Client
+	queue  chan *Delivery
+
+	mu         sync.Mutex
+	deliveries []*Delivery // 按创建时间排列，最多保留 LogSize 条
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher 创建投递器，secret 为空时不签名
+func NewDispatcher(secret []byte, opts Options) *Dispatcher {
+	defaults := DefaultOptions()
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaults.MaxAttempts
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaults.InitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaults.MaxBackoff
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaults.Timeout
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaults.Workers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaults.QueueSize
+	}
+	if opts.LogSize <= 0 {
+		opts.LogSize = defaults.LogSize
+	}
+	policy := opts.Policy
+	if policy == nil {
+		policy, _ = NewPolicy(nil)
+	}
+
+	// 不使用 HTTP_PROXY 等代理：经过代理时无法检查实际连接的地址
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = policy.dialContext(opts.Timeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		secret: secret,
+		opts:   opts,
+		policy: policy,
+		client: &http.Client{Timeout: opts.Timeout, Transport: transport},
+		queue:  make(chan *Delivery, opts.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+
+	return d
+}
+
+// Send 将事件加入投递队列
+func (d *Dispatcher) Send(owner, target, event string, data any) (*Delivery, error) {
+	if err := d.CheckURL(target); err != nil {
+		return nil, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, fmt.Errorf("生成投递ID失败: %v", err)
+	}
+
+	now := time.Now().UTC()
+	body, err := json.Marshal(Payload{Event: event, DeliveryID: id, Timestamp: now, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("序列化 webhook 内容失败: %v", err)
+	}
+
+	delivery := &Delivery{
+		ID:        id,
+		Owner:     owner,
+		URL:       target,
+		Event:     event,
+		Status:    DeliveryPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+		body:      body,
+	}
+
+	select {
+	case d.queue <- delivery:
+	default:
+		return nil, ErrQueueFull
+	}
+
+	d.mu.Lock()
+	d.deliveries = append(d.deliveries, delivery)
+	if len(d.deliveries) > d.opts.LogSize {
+		d.deliveries = d.deliveries[len(d.deliveries)-d.opts.LogSize:]
+	}
+	d.mu.Unlock()
+
+	return delivery, nil
+}
+
+// CheckURL 按投递地址策略检查回调地址
+func (d *Dispatcher) CheckURL(target string) error {
+	return d.policy.CheckURL(target)
+}
+
+// Deliveries 返回指定 API key 的投递记录，从新到旧排列
+func (d *Dispatcher) Deliveries(owner string) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := []Delivery{}
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		if d.deliveries[i].Owner == owner {
+			result = append(result, *d.deliveries[i])
+		}
+	}
+	return result
+}
+
+// Close 停止投递，队列中尚未投递的事件将被丢弃
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// worker 从队列中取出事件并投递
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case delivery := <-d.queue:
+			d.deliver(delivery)
+		}
+	}
+}
+
+// deliver 投递单个事件，失败时按指数退避重试
+func (d *Dispatcher) deliver(delivery *Delivery) {
+	backoff := d.opts.InitialBackoff
+
+	for attempt := 1; attempt <= d.opts.MaxAttempts; attempt++ {
+		statusCode, err := d.post(delivery)
+		retryable := err != nil || statusCode == http.StatusTooManyRequests || statusCode >= 500
+
+		d.mu.Lock()
+		delivery.Attempts = attempt
+		delivery.LastStatusCode = statusCode
+		delivery.LastError = ""
+		if err != nil {
+			delivery.LastError = err.Error()
+		}
+		delivery.UpdatedAt = time.Now().UTC()
+		switch {
+		case err == nil && statusCode >= 200 && statusCode < 300:
+			delivery.Status = DeliveryDelivered
+		case !retryable || attempt == d.opts.MaxAttempts:
+			delivery.Status = DeliveryFailed
+		}
+		status := delivery.Status
+		d.mu.Unlock()
+
+		if status != DeliveryPending {
+			if status == DeliveryFailed {
+				slog.Warn("webhook 投递失败", "event", delivery.Event, "url", logging.RedactURL(delivery.URL), "attempts", attempt, "status", statusCode, "error", err)
+			}
+			return
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > d.opts.MaxBackoff {
+			backoff = d.opts.MaxBackoff
+		}
+	}
+}
+
+// post 发送一次签名请求，返回接收方的状态码
+func (d *Dispatcher) post(delivery *Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "clamd-api-webhook")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if len(d.secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(d.secret, timestamp, delivery.body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	return resp.StatusCode, nil
+}
+
+// Sign 计算签名：HMAC-SHA256(secret, timestamp + "." + body)，接收方应使用相同方式校验
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验签名，供接收方或测试使用
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// ValidateURL 检查回调地址是否为合法的 http(s) 地址，不检查地址是否允许投递（见 Policy）
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("无效的回调地址: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("回调地址只支持 http 和 https: %s", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("回调地址缺少主机: %s", raw)
+	}
+	return nil
+}
+
+// newID 生成随机的投递ID
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receiver 是记录收到的请求的 httptest 接收方
+type receiver struct {
+	*httptest.Server
+	calls atomic.Int32
+}
+
+// newReceiver 创建接收方，前 fail 次请求返回 status，之后返回 200
+func newReceiver(t *testing.T, secret []byte, fail int32, status int) *receiver {
+	t.Helper()
+
+	r := &receiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := r.calls.Add(1)
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("读取请求体失败: %v", err)
+		}
+		if !Verify(secret, req.Header.Get(HeaderTimestamp), body, req.Header.Get(HeaderSignature)) {
+			t.Errorf("签名校验失败: %s", req.Header.Get(HeaderSignature))
+		}
+
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("解析请求体失败: %v", err)
+		}
+		if payload.Event != req.Header.Get(HeaderEvent) || payload.DeliveryID != req.Header.Get(HeaderDelivery) {
+			t.Errorf("请求头与内容不一致: %+v", payload)
+		}
+
+		if n <= fail {
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func newTestDispatcher(t *testing.T, secret []byte) *Dispatcher {
+	t.Helper()
+
+	// 测试接收方监听在回环地址上
+	policy, err := NewPolicy([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDispatcher(secret, Options{
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+		Timeout:        time.Second,
+		Policy:         policy,
+	})
+	t.Cleanup(d.Close)
+
+	return d
+}
+
+// waitDelivery 等待投递结束并返回最终记录
+func waitDelivery(t *testing.T, d *Dispatcher, owner, id string) Delivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, delivery := range d.Deliveries(owner) {
+			if delivery.ID == id && delivery.Status != DeliveryPending {
+				return delivery
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("投递 %s 未在期限内结束", id)
+	return Delivery{}
+}
+
+func TestDispatcherDeliversSignedPayload(t *testing.T) {
+	secret := []byte("test-secret")
+	r := newReceiver(t, secret, 0, 0)
+	d := newTestDispatcher(t, secret)
+
+	sent, err := d.Send("alice", r.URL, EventScanCompleted, map[string]string{"file": "a.txt"})
+	if err != nil {
+		t.Fatalf("Send 失败: %v", err)
+	}
+
+	delivery := waitDelivery(t, d, "alice", sent.ID)
+	if delivery.Status != DeliveryDelivered || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusOK {
+		t.Errorf("投递结果不符合预期: %+v", delivery)
+	}
+}
+
+func TestDispatcherRetriesServerErrors(t *testing.T) {
+	secret := []byte("test-secret")
+	r := newReceiver(t, secret, 2, http.StatusInternalServerError)
+	d := newTestDispatcher(t, secret)
+
+	sent, err := d.Send("alice", r.URL, EventThreatFound, nil)
+	if err != nil {
+		t.Fatalf("Send 失败: %v", err)
+	}
+
+	delivery := waitDelivery(t, d, "alice", sent.ID)
+	if delivery.Status != DeliveryDelivered || delivery.Attempts != 3 {
+		t.Errorf("投递结果不符合预期: %+v", delivery)
+	}
+	if got := r.calls.Load(); got != 3 {
+		t.Errorf("接收方收到 %d 次请求，期望 3 次", got)
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	secret := []byte("test-secret")
+
+	tests := []struct {
+		name     string
+		status   int
+		attempts int
+	}{
+		{"客户端错误不重试", http.StatusBadRequest, 1},
+		{"重试次数用尽", http.StatusServiceUnavailable, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t, secret, 100, tt.status)
+			d := newTestDispatcher(t, secret)
+
+			sent, err := d.Send("alice", r.URL, EventScanCompleted, nil)
+			if err != nil {
+				t.Fatalf("Send 失败: %v", err)
+			}
+
+			delivery := waitDelivery(t, d, "alice", sent.ID)
+			if delivery.Status != DeliveryFailed || delivery.Attempts != tt.attempts || delivery.LastStatusCode != tt.status {
+				t.Errorf("投递结果不符合预期: %+v", delivery)
+			}
+		})
+	}
+}
+
+func TestDispatcherDeliveryLog(t *testing.T) {
+	secret := []byte("test-secret")
+	r := newReceiver(t, secret, 0, 0)
+	d := newTestDispatcher(t, secret)
+
+	first, err := d.Send("alice", r.URL, EventScanCompleted, nil)
+	if err != nil {
+		t.Fatalf("Send 失败: %v", err)
+	}
+	waitDelivery(t, d, "alice", first.ID)
+	second, err := d.Send("alice", r.URL, EventThreatFound, nil)
+	if err != nil {
+		t.Fatalf("Send 失败: %v", err)
+	}
+	waitDelivery(t, d, "alice", second.ID)
+	if _, err := d.Send("bob", r.URL, EventScanCompleted, nil); err != nil {
+		t.Fatalf("Send 失败: %v", err)
+	}
+
+	log := d.Deliveries("alice")
+	if len(log) != 2 || log[0].ID != second.ID || log[1].ID != first.ID {
+		t.Errorf("投递记录不符合预期: %+v", log)
+	}
+
+	if _, err := d.Send("alice", "ftp://example.com/hook", EventScanCompleted, nil); err == nil {
+		t.Error("期望拒绝非 http(s) 回调地址")
+	}
+}
@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress 表示回调地址指向不允许投递的非公网地址
+var ErrForbiddenAddress = errors.New("不允许投递到非公网地址")
+
+// sharedAddressSpace 是运营商级 NAT 使用的 100.64.0.0/10，部分云平台的元数据服务也在其中
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Policy 限制 webhook 可以投递的地址
+//
+// 回调地址由调用方提供，为防止借此访问内网服务（SSRF），默认拒绝回环、私有、链路本地等非公网地址。
+// 地址在建立连接时按解析后的 IP 检查，DNS 重绑定无法绕过；允许列表中的主机名、IP 和网段不受限制。
+type Policy struct {
+	hosts    map[string]bool
+	prefixes []netip.Prefix
+}
+
+// NewPolicy 创建投递地址策略，allowedHosts 的每一项可以是主机名、IP 或 CIDR 网段
+func NewPolicy(allowedHosts []string) (*Policy, error) {
+	p := &Policy{hosts: make(map[string]bool)}
+	for _, entry := range allowedHosts {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("无效的网段 '%s': %v", entry, err)
+			}
+			p.prefixes = append(p.prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			p.prefixes = append(p.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p.hosts[entry] = true
+	}
+	return p, nil
+}
+
+// CheckURL 检查回调地址的格式，并拒绝直接指向非公网 IP 或 localhost 的地址
+//
+// 主机名解析得到的地址在投递时检查。
+func (p *Policy) CheckURL(raw string) error {
+	if err := ValidateURL(raw); err != nil {
+		return err
+	}
+
+	u, _ := url.Parse(raw)
+	host := strings.ToLower(u.Hostname())
+	if p.hosts[host] {
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !p.allowedAddr(addr) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// allowedAddr 判断是否允许连接 addr
+func (p *Policy) allowedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	// IsGlobalUnicast 已排除回环、链路本地、组播和未指定地址
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// dialContext 返回供 http.Transport 使用的拨号函数，在连接前检查解析后的 IP
+func (p *Policy) dialContext(timeout time.Duration) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: timeout}
+		host, _, _ := net.SplitHostPort(address)
+		if !p.hosts[strings.ToLower(host)] {
+			dialer.Control = func(network, address string, _ syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !p.allowedAddr(addrPort.Addr()) {
+					return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+				}
+				return nil
+			}
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+}
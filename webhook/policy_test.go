@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyCheckURL(t *testing.T) {
+	policy, err := NewPolicy([]string{"alerts.internal", "10.1.0.0/16", "192.168.1.10"})
+	if err != nil {
+		t.Fatalf("NewPolicy 失败: %v", err)
+	}
+
+	tests := []struct {
+		url       string
+		forbidden bool
+	}{
+		{"https://example.com/hook", false},
+		{"https://93.184.216.34/hook", false},
+		{"http://alerts.internal/hook", false},
+		{"http://10.1.2.3/hook", false},
+		{"http://192.168.1.10:8080/hook", false},
+		{"http://127.0.0.1/hook", true},
+		{"http://localhost:8080/hook", true},
+		{"http://api.localhost/hook", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://100.100.100.200/", true},
+		{"http://10.2.0.1/hook", true},
+		{"http://172.16.0.1/hook", true},
+		{"http://192.168.1.11/hook", true},
+		{"http://0.0.0.0/hook", true},
+		{"http://[::1]/hook", true},
+		{"http://[fd00::1]/hook", true},
+		{"http://[::ffff:127.0.0.1]/hook", true},
+	}
+	for _, tt := range tests {
+		err := policy.CheckURL(tt.url)
+		if got := errors.Is(err, ErrForbiddenAddress); got != tt.forbidden {
+			t.Errorf("CheckURL(%q) = %v，期望拒绝: %v", tt.url, err, tt.forbidden)
+		}
+	}
+
+	if err := policy.CheckURL("ftp://example.com/hook"); err == nil || errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("非 http(s) 地址返回 %v", err)
+	}
+	if _, err := NewPolicy([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("无效网段没有返回错误")
+	}
+}
+
+func TestPolicyDialChecksResolvedAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	// 主机名在连接时才解析，解析到回环地址同样被拒绝
+	policy, _ := NewPolicy(nil)
+	dial := policy.dialContext(time.Second)
+	for _, address := range []string{"127.0.0.1:" + port, "localhost:" + port} {
+		if conn, err := dial(context.Background(), "tcp", address); !errors.Is(err, ErrForbiddenAddress) {
+			if conn != nil {
+				conn.Close()
+			}
+			t.Errorf("连接 %s 返回 %v，期望 ErrForbiddenAddress", address, err)
+		}
+	}
+
+	// 允许列表中的主机名不受限制
+	policy, _ = NewPolicy([]string{"localhost"})
+	conn, err := policy.dialContext(time.Second)(context.Background(), "tcp", "localhost:"+port)
+	if err != nil {
+		t.Fatalf("连接允许的主机失败: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDispatcherRejectsPrivateAddress(t *testing.T) {
+	d := NewDispatcher(nil, Options{Timeout: time.Second})
+	t.Cleanup(d.Close)
+
+	for _, target := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/latest/meta-data"} {
+		if _, err := d.Send("alice", target, EventScanCompleted, nil); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("Send(%q) 返回 %v，期望 ErrForbiddenAddress", target, err)
+		}
+	}
+}